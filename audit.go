@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Finds the nearest revision of a vulnerable package that has a fix for every
+// advisory against it: the oldest newer release, if the package is on a
+// release tag and one is fixed, or else the oldest commit on its branch.
+// Returns the revision and a description of it, such as "v1.2.1" or
+// "master 1a2b3c4d5e6f".
+func fixedRevision(packageInfo *manifest.Package, advisories []*vcs.Advisory) (string, string, error) {
+	git := GitRepositoryFromPackage(packageInfo)
+	if !git.IsCloned() {
+		return "", "", errors.New("it is not installed; run deliver install first")
+	}
+	if err := git.Fetch(); err != nil {
+		return "", "", err
+	}
+	branch := packageInfo.GetBranch()
+	tip, err := git.RemoteRevision(branch)
+	if err != nil {
+		return "", "", err
+	}
+	if err := git.EnsureRevision(tip); err != nil {
+		return "", "", err
+	}
+
+	// Whether revision has a fix for every advisory.
+	fixesAll := func(revision string) bool {
+		for _, advisory := range advisories {
+			fixed := false
+			for _, fix := range advisory.Fixed {
+				if git.HasCommit(fix) {
+					if ok, _ := git.IsAncestor(fix, revision); ok {
+						fixed = true
+						break
+					}
+				}
+			}
+			if !fixed {
+				return false
+			}
+		}
+		return true
+	}
+
+	tags, err := git.RemoteTags()
+	if err != nil {
+		return "", "", err
+	}
+	lockedTag, current := newestTag(tags, func(tag string, v *vcs.Version) bool {
+		return tags[tag] == packageInfo.Revision
+	})
+	if current != nil {
+		names := []string{}
+		for tag := range tags {
+			names = append(names, tag)
+		}
+		sort.Strings(names)
+		var fixedTag string
+		var fixedVersion *vcs.Version
+		for _, tag := range names {
+			v, ok := vcs.ParseVersion(tag)
+			if !ok || !current.Less(v) || !inScope(current, v) || (fixedVersion != nil && !v.Less(fixedVersion)) {
+				continue
+			}
+			if !git.HasCommit(tags[tag]) && git.FetchRevision(tag) != nil {
+				continue
+			}
+			if fixesAll(tags[tag]) {
+				fixedTag, fixedVersion = tag, v
+			}
+		}
+		if fixedTag != "" {
+			return tags[fixedTag], fixedTag, nil
+		}
+	}
+
+	if !fixesAll(tip) {
+		return "", "", errors.New(fmt.Sprintf("%s doesn't have a fix for every advisory yet", branch))
+	}
+	if current != nil {
+		warnf("no release of %s after %s has the fixes, so it moves along %s instead", packageInfo.Name, lockedTag, branch)
+	}
+	// Step back to the oldest fix on the branch that still has them all.
+	nearest := tip
+	for _, advisory := range advisories {
+		for _, fix := range advisory.Fixed {
+			if !git.HasCommit(fix) {
+				continue
+			}
+			if before, _ := git.IsAncestor(fix, nearest); before && fixesAll(fix) {
+				nearest = fix
+			}
+		}
+	}
+	return nearest, branch + " " + vcs.ShortRevision(nearest), nil
+}
+
+// Moves each vulnerable package to the revision fixedRevision finds, installs
+// it there and writes the lockfile. Packages the package file pins to a
+// revision are left for the user to move. Returns the names of the packages
+// that still have advisories.
+func fixAdvisories(lockManifest *manifest.Manifest, vulnerable map[string][]*vcs.Advisory) []string {
+	packageManifest, err := manifest.Load(manifest.PACKAGE_FILE)
+	if err != nil {
+		packageManifest = &manifest.Manifest{}
+	}
+	previous := loadManifest(manifest.LOCK_FILE)
+
+	names := []string{}
+	for name := range vulnerable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	unfixed := []string{}
+	for _, name := range names {
+		packageInfo := lockManifest.Packages[name]
+		if pinned, ok := packageManifest.Packages[name]; ok && pinned.HasRevision() {
+			warnf("%s is pinned to %s in %s; move it to a fixed revision there", name, pinned.Revision, manifest.PACKAGE_FILE)
+			unfixed = append(unfixed, name)
+			continue
+		}
+		revision, description, err := fixedRevision(packageInfo, vulnerable[name])
+		if err != nil {
+			warnf("could not fix %s: %v", name, err)
+			unfixed = append(unfixed, name)
+			continue
+		}
+
+		ids := []string{}
+		for _, advisory := range vulnerable[name] {
+			ids = append(ids, advisory.ID)
+		}
+		fmt.Fprintf(os.Stdout, "%s: %s -> %s, fixing %s\n", colorize(os.Stdout, COLOR_BOLD, name), vcs.ShortRevision(packageInfo.Revision), description, strings.Join(ids, ", "))
+		packageInfo.Revision = revision
+		// The new revision's hash is recorded once it is installed.
+		packageInfo.Hash = ""
+		downloadPackage(packageInfo)
+
+		if remaining, err := vcs.AdvisoriesFor(revision); err == nil && len(remaining) > 0 {
+			warnf("%s still has %d advisories at %s", name, len(remaining), description)
+			unfixed = append(unfixed, name)
+		}
+	}
+
+	if len(unfixed) < len(names) && !*noRun {
+		completeLockfile(lockManifest)
+		printLockChanges(previous, lockManifest)
+		writeManifest(lockManifest, manifest.LOCK_FILE)
+		emitEvent(&Event{Type: EVENT_LOCKFILE_WRITTEN, Path: manifest.LOCK_FILE})
+	}
+	return unfixed
+}
+
+// Lists the advisories the OSV database has against the locked revisions, and
+// fails if there are any. With -fix, moves the vulnerable packages to the
+// nearest revisions that fix them and updates the lockfile.
+func auditCommand(args []string) {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "move vulnerable packages to the nearest revisions with fixes, install them and update the lockfile")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		panic(errors.New("Usage: deliver audit [-fix]"))
+	}
+
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	names := []string{}
+	for name, packageInfo := range lockManifest.Packages {
+		if packageInfo.HasRevision() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	vulnerable := map[string][]*vcs.Advisory{}
+	failed := 0
+	for _, name := range names {
+		advisories, err := vcs.AdvisoriesFor(lockManifest.Packages[name].Revision)
+		if err != nil {
+			warnf("could not look up advisories for %s: %v", name, err)
+			failed++
+			continue
+		}
+		if len(advisories) > 0 {
+			vulnerable[name] = advisories
+		}
+	}
+
+	if len(vulnerable) == 0 {
+		if failed == 0 {
+			fmt.Fprintf(os.Stdout, "no advisories against the %d locked packages\n", len(names))
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "PACKAGE\tREVISION\tADVISORY\tSUMMARY\n")
+		for _, name := range names {
+			for _, advisory := range vulnerable[name] {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, vcs.ShortRevision(lockManifest.Packages[name].Revision), advisory.ID, advisory.Summary)
+			}
+		}
+		w.Flush()
+	}
+
+	remaining := len(vulnerable)
+	if *fix && remaining > 0 {
+		remaining = len(fixAdvisories(lockManifest, vulnerable))
+	}
+	if failed > 0 {
+		panic(errors.New(fmt.Sprintf("%d packages could not be checked", failed)))
+	}
+	if remaining > 0 {
+		message := fmt.Sprintf("%d packages have advisories against their locked revisions", remaining)
+		if !*fix {
+			message += ". deliver audit -fix moves them to revisions with fixes"
+		}
+		panic(errors.New(message))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// The organization's baseline lockfile, once it has been read.
+var baselineLock *manifest.Manifest
+
+// Reads the lockfile -base_lock points to, from a path or an http(s) URL.
+// Returns nil if none is set.
+func loadBaseline() *manifest.Manifest {
+	if *baseLock == "" {
+		return nil
+	}
+	if baselineLock != nil {
+		return baselineLock
+	}
+	var data []byte
+	var err error
+	if strings.HasPrefix(*baseLock, "https://") || strings.HasPrefix(*baseLock, "http://") {
+		data, err = vcs.FetchUrl(*baseLock)
+	} else {
+		data, err = ioutil.ReadFile(*baseLock)
+	}
+	if err != nil {
+		panic(errors.New(fmt.Sprintf("could not read the baseline lockfile: %v", err)))
+	}
+	if baselineLock, err = manifest.ParseLockfile(data, *baseLock); err != nil {
+		panic(errors.New(fmt.Sprintf("%s: %v", *baseLock, err)))
+	}
+	return baselineLock
+}
+
+// Pins the packages of the package file that the baseline pins, unless the
+// package file pins them itself, so the lockfile inherits the baseline's
+// revisions.
+func applyBaseline(m *manifest.Manifest) {
+	baseline := loadBaseline()
+	if baseline == nil {
+		return
+	}
+	for name, packageInfo := range m.Packages {
+		pinned, ok := baseline.Packages[name]
+		if !ok || !pinned.HasRevision() || packageInfo.HasRevision() {
+			continue
+		}
+		if packageInfo.Source == "" {
+			packageInfo.Source = pinned.Source
+		}
+		if packageInfo.Branch == "" {
+			packageInfo.Branch = pinned.Branch
+		}
+		packageInfo.Revision = pinned.Revision
+		packageInfo.Hash = pinned.Hash
+	}
+}
+
+// Reports the locked packages whose revisions differ from the baseline's.
+// Packages the package file pins to another revision on purpose are listed,
+// but only the others fail the check.
+func checkCommand(args []string) {
+	if len(args) != 0 {
+		panic(errors.New("usage: deliver check"))
+	}
+	baseline := loadBaseline()
+	if baseline == nil {
+		panic(errors.New("there is no baseline to check against; set -base_lock, e.g. with deliver config set -global base_lock <url>"))
+	}
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	packageManifest, _ := loadPackageFile()
+
+	names := []string{}
+	for name := range lockManifest.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	drifted := []string{}
+	for _, name := range names {
+		locked := lockManifest.Packages[name]
+		pinned, ok := baseline.Packages[name]
+		if !ok || !pinned.HasRevision() || locked.Revision == pinned.Revision {
+			continue
+		}
+		if packageManifest != nil {
+			if wanted, ok := packageManifest.Packages[name]; ok && wanted.Revision == locked.Revision {
+				fmt.Fprintf(os.Stdout, "%s is pinned to %s in %s, overriding the baseline's %s\n",
+					name, vcs.ShortRevision(locked.Revision), manifest.PACKAGE_FILE, vcs.ShortRevision(pinned.Revision))
+				continue
+			}
+		}
+		fmt.Fprintf(os.Stdout, "%s is locked at %s, but the baseline pins %s%s\n",
+			colorize(os.Stdout, COLOR_BOLD, name), vcs.ShortRevision(locked.Revision), vcs.ShortRevision(pinned.Revision), ownersSuffix(locked))
+		drifted = append(drifted, name)
+	}
+	if len(drifted) > 0 {
+		panic(errors.New(fmt.Sprintf("%d packages drifted from the baseline; run deliver update to inherit its pins", len(drifted))))
+	}
+	fmt.Fprintf(os.Stdout, "%s follows the baseline\n", manifest.LOCK_FILE)
+}
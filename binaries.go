@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Records, in the bin directory, the asset and checksum each binary was
+// installed from, so binaries that are up to date aren't downloaded again.
+const BINARIES_FILE string = ".deliver-binaries.json"
+
+// Returns where a binary is installed: the workspace's bin directory.
+func binaryPath(name string) string {
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(getWorkspacePath(), "bin", name)
+}
+
+func loadInstalledBinaries() map[string]string {
+	installed := map[string]string{}
+	data, err := ioutil.ReadFile(filepath.Join(getWorkspacePath(), "bin", BINARIES_FILE))
+	if err == nil {
+		json.Unmarshal(data, &installed)
+	}
+	return installed
+}
+
+func writeInstalledBinaries(installed map[string]string) {
+	data, err := json.MarshalIndent(installed, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(getWorkspacePath(), "bin", BINARIES_FILE), append(data, '\n'), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// Copies the checksums of binaries that haven't changed from locked, the
+// previous lockfile, if there is one.
+func carryBinaryChecksums(m *manifest.Manifest, locked *manifest.Manifest) {
+	if locked == nil {
+		return
+	}
+	for name, binary := range m.Binaries {
+		previous, ok := locked.Binaries[name]
+		if ok && binary.Checksums == nil && previous.Repository == binary.Repository &&
+			previous.Tag == binary.Tag && previous.Asset == binary.Asset {
+			binary.Checksums = previous.Checksums
+		}
+	}
+}
+
+// Installs the manifest's binaries into the workspace's bin directory. Each
+// release asset must match the checksum recorded for it in the manifest or,
+// if the binary hasn't changed, in locked, the previous lockfile. The
+// checksums of assets that had none are added to the manifest. Returns
+// whether any were added.
+func installBinaries(m *manifest.Manifest, locked *manifest.Manifest) bool {
+	if len(m.Binaries) == 0 {
+		return false
+	}
+	names := []string{}
+	for name := range m.Binaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	carryBinaryChecksums(m, locked)
+	installed := loadInstalledBinaries()
+	added := false
+	for _, name := range names {
+		binary := m.Binaries[name]
+		asset := vcs.ReleaseAssetName(binary)
+		checksum := binary.Checksums[asset]
+		dest := binaryPath(name)
+		stamp := vcs.ReleaseAssetUrl(binary) + "#" + checksum
+		if _, err := os.Stat(dest); err == nil && checksum != "" && installed[name] == stamp {
+			continue
+		}
+
+		fmt.Fprintf(os.Stdout, "downloading %s %s -> %s\n", colorize(os.Stdout, COLOR_BOLD, name), binary.Tag, dest)
+		actual, err := vcs.DownloadReleaseAsset(name, binary, dest, checksum)
+		if err != nil {
+			panic(err)
+		}
+		if *noRun {
+			continue
+		}
+		if checksum == "" {
+			if binary.Checksums == nil {
+				binary.Checksums = make(map[string]string)
+			}
+			binary.Checksums[asset] = actual
+			added = true
+		}
+		installed[name] = vcs.ReleaseAssetUrl(binary) + "#" + actual
+		writeInstalledBinaries(installed)
+	}
+	return added
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Runs go install in every package, parallel at a time, so their compiled
+// packages are in the workspace's pkg directory, or the build cache on newer
+// versions of Go, before the project is first built.
+func buildDeps(packages []*manifest.Package, parallel int) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	start := time.Now()
+	var failedMutex sync.Mutex
+	var failed []string
+	var wait sync.WaitGroup
+	slots := make(chan bool, parallel)
+	for _, packageInfo := range packages {
+		git := GitRepositoryFromPackage(packageInfo)
+		slots <- true
+		wait.Add(1)
+		go func(name, dir string) {
+			defer wait.Done()
+			ok := runGoTool(name, dir, "install", "./...")
+			<-slots
+			if !ok {
+				failedMutex.Lock()
+				failed = append(failed, name)
+				failedMutex.Unlock()
+			}
+		}(packageInfo.Name, git.RepoPath)
+	}
+	wait.Wait()
+
+	if len(failed) > 0 {
+		panic(errors.New(fmt.Sprintf("go install failed in %d packages: %v", len(failed), failed)))
+	}
+	fmt.Fprintf(os.Stdout, "built %d packages in %s\n", len(packages), time.Since(start).Round(time.Millisecond))
+}
+
+// Prebuilds every installed package, so the first build of the project only
+// compiles the project.
+func buildDepsCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("build-deps", flag.ExitOnError)
+	parallel := flags.Int("parallel", runtime.NumCPU(), "number of packages to build at once")
+	flags.Parse(args)
+	buildDeps(installedPackages(root), *parallel)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Lists the packages in a bundle archive, with the revisions they were
+// bundled at.
+const BUNDLE_INDEX_FILE string = "deliver-bundle.json"
+
+var unsafeBundleChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Returns where a package's git bundle is stored inside a bundle archive.
+func bundleFileName(packageName string) string {
+	return path.Join("bundles", unsafeBundleChars.ReplaceAllString(packageName, "_")+".bundle")
+}
+
+// Returns where the files of a package without git history are stored inside
+// a bundle archive.
+func bundleSourceDir(packageName string) string {
+	return path.Join("sources", packageName)
+}
+
+// Adds the file at filePath to the archive under name.
+func addFileToTar(tw *tar.Writer, filePath, name string) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		panic(err)
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(filePath); err != nil {
+			panic(err)
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		panic(err)
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		panic(err)
+	}
+
+	if info.Mode().IsRegular() {
+		f, err := os.Open(filePath)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Adds every file under dir to the archive under prefix.
+func addDirToTar(tw *tar.Writer, dir, prefix string) {
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			panic(err)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			panic(err)
+		}
+		if rel == "." {
+			return nil
+		}
+		addFileToTar(tw, p, path.Join(prefix, filepath.ToSlash(rel)))
+		return nil
+	})
+}
+
+// Writes an archive containing the lockfile and every installed package, as a
+// git bundle or, for packages without history, their files.
+func createBundle(root *resolve.Node, archivePath string) {
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+
+	tmpDir, err := ioutil.TempDir("", "deliver-bundle-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	out, err := os.Create(archivePath)
+	if err != nil {
+		panic(err)
+	}
+	defer out.Close()
+	tw := tar.NewWriter(out)
+
+	index := &manifest.Manifest{
+		Repository:   lockManifest.Repository,
+		Repositories: lockManifest.Repositories,
+		Packages:     make(map[string]*manifest.Package),
+	}
+	for _, packageInfo := range root.Packages() {
+		git := GitRepositoryFromPackage(packageInfo)
+		bundled := *packageInfo
+
+		if revision, ok := vcs.TarballRevision(git.RepoPath); ok {
+			fmt.Fprintf(os.Stdout, "bundling %s (source)\n", packageInfo.Name)
+			bundled.Revision = revision
+			addDirToTar(tw, git.RepoPath, bundleSourceDir(packageInfo.Name))
+		} else if git.IsCloned() {
+			fmt.Fprintf(os.Stdout, "bundling %s\n", packageInfo.Name)
+			if bundled.Revision, err = git.CurrentRevision(); err != nil {
+				panic(err)
+			}
+			bundleFile := path.Join(tmpDir, path.Base(bundleFileName(packageInfo.Name)))
+			if _, err := vcs.RunInDirectory(git.RepoPath, "git", "bundle", "create", bundleFile, "HEAD", "--all"); err != nil {
+				panic(err)
+			}
+			addFileToTar(tw, bundleFile, bundleFileName(packageInfo.Name))
+		} else {
+			panic(errors.New(fmt.Sprintf("%s is not installed; run deliver install before bundling", packageInfo.Name)))
+		}
+
+		index.Packages[packageInfo.Name] = &bundled
+	}
+
+	indexFile := path.Join(tmpDir, BUNDLE_INDEX_FILE)
+	writeManifest(index, indexFile)
+	addFileToTar(tw, indexFile, BUNDLE_INDEX_FILE)
+	addFileToTar(tw, manifest.LOCK_FILE, manifest.LOCK_FILE)
+
+	if err := tw.Close(); err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stdout, "wrote %d packages to %s\n", len(index.Packages), archivePath)
+}
+
+// Installs every package in a bundle archive into the workspace without
+// contacting any remote. Writes the bundled lockfile if there is none.
+func restoreBundle(archivePath string) {
+	in, err := os.Open(archivePath)
+	if err != nil {
+		panic(err)
+	}
+	defer in.Close()
+
+	// Unpacked inside the workspace, so packages can be moved into place
+	// rather than copied across filesystems.
+	workspacePath := getWorkspacePath()
+	if err := os.MkdirAll(workspacePath, 0755); err != nil {
+		panic(err)
+	}
+	tmpDir, err := ioutil.TempDir(workspacePath, ".deliver-bundle-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := vcs.ExtractTar(tar.NewReader(in), tmpDir, 0); err != nil {
+		panic(err)
+	}
+	index := loadManifest(path.Join(tmpDir, BUNDLE_INDEX_FILE))
+
+	for _, packageInfo := range index.Packages {
+		git := GitRepositoryFromPackage(packageInfo)
+		fmt.Fprintf(os.Stdout, "restoring %s -> %s\n", packageInfo.Name, git.RepoPath)
+
+		sourceDir := path.Join(tmpDir, bundleSourceDir(packageInfo.Name))
+		if _, err := os.Stat(sourceDir); err == nil {
+			if err := os.RemoveAll(git.RepoPath); err != nil {
+				panic(err)
+			}
+			if err := os.MkdirAll(path.Dir(git.RepoPath), 0755); err != nil {
+				panic(err)
+			}
+			if err := os.Rename(sourceDir, git.RepoPath); err != nil {
+				panic(err)
+			}
+			continue
+		}
+
+		bundleFile := path.Join(tmpDir, bundleFileName(packageInfo.Name))
+		if !git.IsCloned() {
+			if _, err := vcs.ExecuteCommand("mkdir", "-p", git.RepoPath); err != nil {
+				panic(err)
+			}
+			if _, err := vcs.ExecuteCommand("git", "clone", "--no-checkout", bundleFile, git.RepoPath); err != nil {
+				panic(err)
+			}
+			// Later fetches should go to the real source, not the bundle.
+			if _, err := vcs.RunInDirectory(git.RepoPath, "git", "remote", "set-url", "origin", packageInfo.Source); err != nil {
+				panic(err)
+			}
+		} else {
+			if _, err := vcs.RunInDirectory(git.RepoPath, "git", "fetch", bundleFile, "HEAD"); err != nil {
+				panic(err)
+			}
+		}
+		if err := git.CheckoutRevision(packageInfo.Revision); err != nil {
+			panic(err)
+		}
+	}
+
+	if _, err := os.Stat(manifest.LOCK_FILE); os.IsNotExist(err) {
+		data, err := ioutil.ReadFile(path.Join(tmpDir, manifest.LOCK_FILE))
+		if err != nil {
+			panic(err)
+		}
+		if err := ioutil.WriteFile(manifest.LOCK_FILE, data, 0644); err != nil {
+			panic(err)
+		}
+	}
+	if index.HasRepository() {
+		createWorkspaceSymlinks(index)
+	}
+}
+
+func bundleCommand(root *resolve.Node, args []string) {
+	if len(args) != 2 || (args[0] != "create" && args[0] != "restore") {
+		panic(errors.New("usage: deliver bundle create|restore <archive.tar>"))
+	}
+	if args[0] == "create" {
+		createBundle(root, args[1])
+	} else {
+		restoreBundle(args[1])
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Returns the content hash of an installed package's revision.
+func packageHash(packageInfo *manifest.Package) (string, error) {
+	git := GitRepositoryFromPackage(packageInfo)
+	if _, ok := vcs.TarballRevision(git.RepoPath); ok {
+		return vcs.DirContentHash(git.RepoPath)
+	}
+	return git.ContentHash(packageInfo.Revision)
+}
+
+// Returns the locked packages that are installed at their locked revision,
+// in name order.
+func installedAtRevision(m *manifest.Manifest) []*manifest.Package {
+	names := []string{}
+	for name := range m.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	packages := []*manifest.Package{}
+	for _, name := range names {
+		packageInfo := m.Packages[name]
+		if !packageInfo.HasRevision() {
+			continue
+		}
+		if revision, ok := getInstalledRevision(GitRepositoryFromPackage(packageInfo)); ok && revision == packageInfo.Revision {
+			packages = append(packages, packageInfo)
+		}
+	}
+	return packages
+}
+
+// Records the content hash of every installed package in the lockfile that
+// doesn't have one yet, and checks the new hashes against -checksum_db.
+func recordHashes(lockManifest *manifest.Manifest) {
+	for _, packageInfo := range installedAtRevision(lockManifest) {
+		if packageInfo.Hash != "" {
+			continue
+		}
+		hash, err := packageHash(packageInfo)
+		if err != nil {
+			warnf("could not hash %s: %v", packageInfo.Name, err)
+			continue
+		}
+		checkChecksumDatabase(packageInfo, hash)
+		packageInfo.Hash = hash
+	}
+}
+
+// Checks the installed packages against the hashes in the lockfile and, if
+// it is set, -checksum_db, so a source that changed what a revision contains
+// is caught.
+func verifyHashes(lockManifest *manifest.Manifest) {
+	for _, packageInfo := range installedAtRevision(lockManifest) {
+		hash, err := packageHash(packageInfo)
+		if err != nil {
+			panic(errors.New(fmt.Sprintf("could not hash %s: %v", packageInfo.Name, err)))
+		}
+		if packageInfo.Hash != "" && hash != packageInfo.Hash {
+			panic(errors.New(fmt.Sprintf("%s at %s has hash %s, but %s recorded %s. Its source may have been tampered with.",
+				packageInfo.Name, packageInfo.Revision, hash, manifest.LOCK_FILE, packageInfo.Hash)))
+		}
+		checkChecksumDatabase(packageInfo, hash)
+	}
+}
+
+// Fails if -checksum_db is set and doesn't have hash for the package's
+// revision.
+func checkChecksumDatabase(packageInfo *manifest.Package, hash string) {
+	if *checksumDb == "" {
+		return
+	}
+	recorded, ok, err := vcs.LookupChecksum(*checksumDb, packageInfo.Name, packageInfo.Revision)
+	if err != nil {
+		panic(errors.New(fmt.Sprintf("could not verify %s with the checksum database: %v", packageInfo.Name, err)))
+	}
+	if !ok {
+		panic(errors.New(fmt.Sprintf("%s at %s is not in the checksum database %s", packageInfo.Name, packageInfo.Revision, *checksumDb)))
+	}
+	if recorded != hash {
+		panic(errors.New(fmt.Sprintf("%s at %s has hash %s, but the checksum database %s recorded %s. Its source may have been tampered with.",
+			packageInfo.Name, packageInfo.Revision, hash, *checksumDb, recorded)))
+	}
+}
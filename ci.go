@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Where the generated pipelines keep deliver's workspaces and cache.
+const (
+	CI_DOCKER_ROOT         = "/deliver"
+	CI_GITHUB_ACTIONS_ROOT = "$RUNNER_TEMP/deliver"
+)
+
+const dockerfileTemplate = `# syntax=docker/dockerfile:1
+# Generated by deliver ci-init --docker.
+
+# Installs the locked dependencies. Only packages.json and packages.lock are
+# copied in, so Docker reuses this stage's layers until the lockfile changes.
+FROM golang:1 AS deps
+ARG DELIVER_VERSION=latest
+RUN GO111MODULE=on go install github.com/brettshollenberger/deliver@${DELIVER_VERSION}
+%sWORKDIR /src
+COPY %s %s ./
+RUN deliver -root=%s -deliver_workspace -strict_lock install
+
+FROM deps AS build
+COPY . .
+RUN GOPATH="$(deliver -root=%s -deliver_workspace path)" go build ./...
+`
+
+const githubActionsTemplate = `# Generated by deliver ci-init --github-actions.
+name: build
+on: [push, pull_request]
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - uses: actions/setup-go@v5
+        with:
+          go-version: stable
+      - name: Install deliver
+        run: |
+          GO111MODULE=on go install github.com/brettshollenberger/deliver@latest
+          echo "$(go env GOPATH)/bin" >> "$GITHUB_PATH"
+      - name: Hash the locked dependencies
+        id: digest
+        run: echo "digest=$(deliver digest)" >> "$GITHUB_OUTPUT"
+      # The workspace is restored whenever the lockfile's digest is unchanged,
+      # so install has nothing to download.
+      - uses: actions/cache@v4
+        with:
+          path: ${{ runner.temp }}/deliver
+          key: deliver-${{ runner.os }}-${{ steps.digest.outputs.digest }}
+      - name: Install dependencies
+        run: deliver -root=%s -deliver_workspace -strict_lock install
+      - name: Build
+        run: %sGOPATH="$(deliver -root=%s -deliver_workspace path)" go build ./...
+`
+
+// Prints a Docker build stage or a GitHub Actions workflow that installs the
+// locked dependencies into a workspace, cached until the lockfile changes.
+func ciInitCommand(args []string) {
+	flags := flag.NewFlagSet("ci-init", flag.ExitOnError)
+	docker := flags.Bool("docker", false, "print a Dockerfile")
+	githubActions := flags.Bool("github-actions", false, "print a GitHub Actions workflow")
+	flags.Parse(args)
+	if *docker == *githubActions {
+		panic(errors.New("Usage: deliver ci-init -docker|-github-actions"))
+	}
+	if _, err := os.Stat(manifest.LOCK_FILE); err != nil {
+		warnf("there is no %s yet; run deliver update and commit it before using the pipeline", manifest.LOCK_FILE)
+	}
+
+	// Build in GOPATH mode, as deliver installs the packages.
+	gopathMode := false
+	if _, err := os.Stat("go.mod"); os.IsNotExist(err) {
+		gopathMode = true
+	}
+
+	if *docker {
+		env := ""
+		if gopathMode {
+			env = "ENV GO111MODULE=off\n"
+		}
+		fmt.Fprintf(os.Stdout, dockerfileTemplate, env, manifest.PACKAGE_FILE, manifest.LOCK_FILE, CI_DOCKER_ROOT, CI_DOCKER_ROOT)
+		return
+	}
+	env := ""
+	if gopathMode {
+		env = "GO111MODULE=off "
+	}
+	fmt.Fprintf(os.Stdout, githubActionsTemplate, CI_GITHUB_ACTIONS_ROOT, env, CI_GITHUB_ACTIONS_ROOT)
+}
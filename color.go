@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI color codes for terminal output.
+const (
+	COLOR_RED    = "31"
+	COLOR_GREEN  = "32"
+	COLOR_YELLOW = "33"
+	COLOR_CYAN   = "36"
+	COLOR_BOLD   = "1"
+)
+
+// Whether output to stdout and stderr is colored. Set by main.
+var colorStdout, colorStderr bool
+
+// Colors are used only on terminals, and never when -no_color or the
+// NO_COLOR environment variable (https://no-color.org) is set.
+func setupColors() {
+	_, noColorEnv := os.LookupEnv("NO_COLOR")
+	enabled := !*noColor && !noColorEnv && os.Getenv("TERM") != "dumb"
+	colorStdout = enabled && isTerminal(os.Stdout)
+	colorStderr = enabled && isTerminal(os.Stderr)
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Wraps text in a color if output to f is colored.
+func colorize(f *os.File, color string, text string) string {
+	if (f == os.Stdout && colorStdout) || (f == os.Stderr && colorStderr) {
+		return "\x1b[" + color + "m" + text + "\x1b[0m"
+	}
+	return text
+}
+
+// Prints a warning to stderr.
+func warnf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s %s\n", colorize(os.Stderr, COLOR_YELLOW, "warning:"), fmt.Sprintf(format, args...))
+}
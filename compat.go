@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// The Go 1.x release that added each standard library package that was added
+// in Go 1.16 or later.
+var stdlibSince = map[string]int{
+	"embed":            16,
+	"io/fs":            16,
+	"testing/fstest":   16,
+	"net/netip":        18,
+	"debug/buildinfo":  18,
+	"crypto/ecdh":      20,
+	"cmp":              21,
+	"log/slog":         21,
+	"maps":             21,
+	"slices":           21,
+	"go/version":       22,
+	"math/rand/v2":     22,
+	"iter":             23,
+	"structs":          23,
+	"unique":           23,
+	"crypto/hkdf":      24,
+	"crypto/mlkem":     24,
+	"crypto/pbkdf2":    24,
+	"crypto/sha3":      24,
+	"weak":             24,
+	"testing/synctest": 25,
+}
+
+// The Go 1.x release that added commonly used functions and types of older
+// standard library packages, by import path and name.
+var apiSince = map[string]int{
+	"io.ReadAll":                     16,
+	"os.ReadFile":                    16,
+	"os.WriteFile":                   16,
+	"os.ReadDir":                     16,
+	"os.MkdirTemp":                   16,
+	"os.CreateTemp":                  16,
+	"bytes.Cut":                      18,
+	"strings.Cut":                    18,
+	"sync/atomic.Bool":               19,
+	"sync/atomic.Int32":              19,
+	"sync/atomic.Int64":              19,
+	"sync/atomic.Pointer":            19,
+	"errors.Join":                    20,
+	"strings.CutPrefix":              20,
+	"strings.CutSuffix":              20,
+	"context.WithCancelCause":        20,
+	"net/http.NewResponseController": 20,
+	"time.DateOnly":                  20,
+	"context.AfterFunc":              21,
+	"context.WithoutCancel":          21,
+	"sync.OnceFunc":                  21,
+	"sync.OnceValue":                 21,
+	"reflect.TypeFor":                22,
+	"os.CopyFS":                      23,
+	"os.OpenRoot":                    24,
+	"strings.Lines":                  24,
+	"strings.SplitSeq":               24,
+}
+
+// How many releases past the one checked against build constraints are
+// tried, to tell which release a directory needs.
+const MAX_GO_RELEASES_AHEAD = 20
+
+// Returns the minor version of a Go 1.x version such as go1.21.3, 1.21 or
+// go1.22rc1.
+func goMinor(version string) (int, bool) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "go")
+	if !strings.HasPrefix(version, "1.") {
+		return 0, false
+	}
+	digits := strings.TrimPrefix(version, "1.")
+	end := 0
+	for end < len(digits) && digits[end] >= '0' && digits[end] <= '9' {
+		end++
+	}
+	minor, err := strconv.Atoi(digits[:end])
+	return minor, err == nil
+}
+
+// Returns the name a package is referred to by when it is imported without
+// one: the last element of its import path, or the one before a major version
+// suffix, as in math/rand/v2.
+func importName(importPath string) string {
+	elements := strings.Split(importPath, "/")
+	name := elements[len(elements)-1]
+	if len(elements) > 1 && len(name) > 1 && name[0] == 'v' {
+		if _, err := strconv.Atoi(name[1:]); err == nil {
+			name = elements[len(elements)-2]
+		}
+	}
+	return name
+}
+
+// Returns the version of the go tool on the PATH, such as go1.21.3.
+func localGoVersion() string {
+	out, err := exec.Command("go", "env", "GOVERSION").Output()
+	if err == nil && strings.TrimSpace(string(out)) != "" {
+		return strings.TrimSpace(string(out))
+	}
+	// Older versions of go env don't know GOVERSION.
+	out, err = exec.Command("go", "version").Output()
+	if fields := strings.Fields(string(out)); err == nil && len(fields) > 2 {
+		return fields[2]
+	}
+	panic(errors.New("could not run go to find its version; is it on the PATH?"))
+}
+
+// Returns a build context that satisfies the build constraints of Go 1.minor
+// and earlier.
+func goReleaseContext(minor int) build.Context {
+	context := build.Default
+	context.ReleaseTags = nil
+	for i := 1; i <= minor; i++ {
+		context.ReleaseTags = append(context.ReleaseTags, fmt.Sprintf("go1.%d", i))
+	}
+	return context
+}
+
+// Something a package uses that needs a newer release of Go.
+type compatProblem struct {
+	Minor  int
+	Reason string
+}
+
+// Returns what in the package in dir needs a release of Go after Go 1.minor:
+// a newer go directive in go.mod, build constraints that leave a directory
+// without files, and newer standard library packages and functions.
+func checkCompat(dir string, minor int) []*compatProblem {
+	problems := []*compatProblem{}
+	seen := map[string]bool{}
+	// Each reason is reported once, with the first file it was found in.
+	add := func(needs int, reason, where string) {
+		if needs > minor && !seen[reason] {
+			seen[reason] = true
+			if where != "" {
+				reason += " (" + where + ")"
+			}
+			problems = append(problems, &compatProblem{needs, reason})
+		}
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "go" {
+				if needs, ok := goMinor(fields[1]); ok {
+					add(needs, "go.mod asks for go "+fields[1], "")
+				}
+			}
+		}
+	}
+
+	context := goReleaseContext(minor)
+	fset := token.NewFileSet()
+	filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if file != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata") {
+			return filepath.SkipDir
+		}
+		rel, _ := filepath.Rel(dir, file)
+		pkg, err := context.ImportDir(file, 0)
+		if _, ok := err.(*build.NoGoError); ok {
+			// Finds the first release the directory has files for, if any.
+			for needs := minor + 1; needs <= minor+MAX_GO_RELEASES_AHEAD; needs++ {
+				newer := goReleaseContext(needs)
+				if future, err := newer.ImportDir(file, 0); err == nil && len(future.GoFiles) > 0 {
+					add(needs, fmt.Sprintf("build constraints leave %s without files", rel), "")
+					break
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+		for _, goFile := range pkg.GoFiles {
+			f, err := parser.ParseFile(fset, filepath.Join(file, goFile), nil, 0)
+			if err != nil {
+				continue
+			}
+			where := filepath.ToSlash(filepath.Join(rel, goFile))
+			imported := map[string]string{}
+			for _, spec := range f.Imports {
+				importPath, _ := strconv.Unquote(spec.Path.Value)
+				if needs, ok := stdlibSince[importPath]; ok {
+					add(needs, "imports "+importPath, where)
+				}
+				alias := importName(importPath)
+				if spec.Name != nil {
+					alias = spec.Name.Name
+				}
+				imported[alias] = importPath
+			}
+			ast.Inspect(f, func(n ast.Node) bool {
+				selector, ok := n.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				if ident, ok := selector.X.(*ast.Ident); ok && imported[ident.Name] != "" {
+					api := imported[ident.Name] + "." + selector.Sel.Name
+					if needs, ok := apiSince[api]; ok {
+						add(needs, "uses "+api, where)
+					}
+				}
+				return true
+			})
+		}
+		return nil
+	})
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Minor > problems[j].Minor })
+	return problems
+}
+
+// Reports the installed packages whose locked revisions need a newer release
+// of Go than the local toolchain, or the one given with -go, so an upgrade
+// or a pin can be planned before the build fails with a confusing error.
+func compatCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("compat", flag.ExitOnError)
+	goVersion := flags.String("go", "", "the Go release to check against, such as 1.21, instead of the local toolchain's")
+	flags.Parse(args)
+	if *goVersion == "" {
+		*goVersion = localGoVersion()
+	}
+	minor, ok := goMinor(*goVersion)
+	if !ok {
+		panic(errors.New(fmt.Sprintf("can't tell the release of Go from %q", *goVersion)))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	incompatible := []string{}
+	for _, packageInfo := range installedPackages(root) {
+		problems := checkCompat(GitRepositoryFromPackage(packageInfo).RepoPath, minor)
+		if len(problems) == 0 {
+			continue
+		}
+		if len(incompatible) == 0 {
+			fmt.Fprintf(w, "PACKAGE\tREVISION\tNEEDS\tBECAUSE\n")
+		}
+		incompatible = append(incompatible, packageInfo.Name)
+		for i, problem := range problems {
+			if i == 0 {
+				fmt.Fprintf(w, "%s\t%s\tgo1.%d\t%s\n", packageInfo.Name, vcs.ShortRevision(packageInfo.Revision), problem.Minor, problem.Reason)
+			} else {
+				fmt.Fprintf(w, "\t\t\t%s\n", problem.Reason)
+			}
+		}
+	}
+	w.Flush()
+
+	if len(incompatible) > 0 {
+		panic(errors.New(fmt.Sprintf("%d packages may not build with go1.%d: %v", len(incompatible), minor, incompatible)))
+	}
+	fmt.Fprintf(os.Stdout, "every installed package should build with go1.%d\n", minor)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/workspace"
+)
+
+// Settings for a single project, next to its package file. They take
+// precedence over the global settings in the user's config directory.
+const PROJECT_CONFIG_FILE string = ".deliver.json"
+
+const CONFIG_USAGE string = "Usage: deliver config [-global] get <key> | set <key> <value> | unset <key> | list"
+
+// Settings, by name. A setting named after a flag, such as network or
+// reference_cache, is that flag's default. Any setting can be used as a
+// ${NAME} variable in the package file.
+type config map[string]string
+
+// The flags a project's settings may set the defaults of. Settings that hold
+// credentials, weaken checks, pass arguments to git or write files elsewhere
+// are only taken from the global config, so a cloned repository can't change
+// them.
+var projectFlags = map[string]bool{
+	"deliver_workspace": true,
+	"copy":              true,
+	"reference_cache":   true,
+	"worktrees":         true,
+	"local_branches":    true,
+	"no_vcs_metadata":   true,
+	"tarballs":          true,
+	"preflight":         true,
+	"max_depth":         true,
+	"no_recursive":      true,
+	"build_deps":        true,
+	"compile_check":     true,
+	"base_lock":         true,
+	"strict_lock":       true,
+	"env":               true,
+	"strict":            true,
+	"conflict_strategy": true,
+}
+
+func globalConfigFile() string {
+	dir, err := workspace.ConfigDir()
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// Reads a config file. A missing file is an empty config.
+func loadConfig(file string) config {
+	c := config{}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c
+	} else if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(manifest.StripComments(data), &c); err != nil {
+		panic(errors.New(fmt.Sprintf("%s: %v", file, err)))
+	}
+	return c
+}
+
+func (c config) writeToFile(file string) {
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(file, append(data, '\n'), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// Returns the settings file of the project the current directory is in, next
+// to its package file, or the one in the current directory outside projects.
+func projectConfigFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	if projectDir, ok := workspace.FindProject(dir); ok {
+		dir = projectDir
+	}
+	return filepath.Join(dir, PROJECT_CONFIG_FILE)
+}
+
+// Returns the global settings with the project's over them. Project settings
+// for flags outside projectFlags are ignored.
+func effectiveConfig() config {
+	c := loadConfig(globalConfigFile())
+	file := projectConfigFile()
+	for key, value := range loadConfig(file) {
+		if flag.Lookup(key) != nil && !projectFlags[key] {
+			warnf("%s: ignoring %s, which only the global config can set", file, key)
+			continue
+		}
+		c[key] = value
+	}
+	return c
+}
+
+// Uses the settings named after flags as the defaults of flags that weren't
+// given on the command line, and makes every setting available as a variable
+// in the package file.
+func applyConfig() {
+	c := effectiveConfig()
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for key, value := range c {
+		if flag.Lookup(key) == nil || given[key] {
+			continue
+		}
+		if err := flag.Set(key, value); err != nil {
+			panic(errors.New(fmt.Sprintf("invalid value %q for %s in the config: %v", value, key, err)))
+		}
+	}
+	manifest.LookupVariable = func(name string) (string, bool) {
+		value, ok := c[name]
+		return value, ok
+	}
+}
+
+// Reads and writes the global and project settings.
+func configCommand(args []string) {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	global := flags.Bool("global", false, "use the global config instead of the project's")
+	flags.Parse(args)
+	args = flags.Args()
+
+	file := projectConfigFile()
+	if *global {
+		file = globalConfigFile()
+	}
+	switch {
+	case len(args) == 0:
+		panic(errors.New(CONFIG_USAGE))
+
+	case args[0] == "get" && len(args) == 2:
+		c := loadConfig(file)
+		if !*global {
+			c = effectiveConfig()
+		}
+		value, ok := c[args[1]]
+		if !ok {
+			if f := flag.Lookup(args[1]); f != nil {
+				value = f.DefValue
+			} else {
+				fmt.Fprintf(os.Stderr, "%s is not set\n", args[1])
+				os.Exit(1)
+			}
+		}
+		fmt.Fprintln(os.Stdout, value)
+
+	case args[0] == "set" && len(args) == 3:
+		if f := flag.Lookup(args[1]); f != nil {
+			if !*global && !projectFlags[args[1]] {
+				panic(errors.New(fmt.Sprintf("%s can only be set in the global config; use deliver config set -global", args[1])))
+			}
+			// Check the value now rather than on the next run.
+			if err := f.Value.Set(args[2]); err != nil {
+				panic(errors.New(fmt.Sprintf("invalid value %q for %s: %v", args[2], args[1], err)))
+			}
+		}
+		c := loadConfig(file)
+		c[args[1]] = args[2]
+		c.writeToFile(file)
+
+	case args[0] == "unset" && len(args) == 2:
+		c := loadConfig(file)
+		delete(c, args[1])
+		c.writeToFile(file)
+
+	case args[0] == "list" && len(args) == 1:
+		c := loadConfig(file)
+		if !*global {
+			c = effectiveConfig()
+		}
+		keys := []string{}
+		for key := range c {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(os.Stdout, "%s=%s\n", key, c[key])
+		}
+
+	default:
+		panic(errors.New(CONFIG_USAGE))
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// How long git's cache helper keeps the credentials typed at a prompt, so a
+// run asks for each host only once.
+const CREDENTIAL_CACHE_SECONDS = 900
+
+// Whether deliver is git's credential helper for this run.
+var credentialHelperInstalled bool
+
+// Makes deliver git's credential helper for the git commands it runs, if the
+// user has none configured, so private repositories over HTTPS get a prompt
+// with hidden input and the option of remembering the token in the system
+// keychain. Older versions of git, which can't be configured through the
+// environment, fall back to their own prompt.
+func installCredentialHelper() {
+	if !*credentialPrompt || vcs.NetworkDenied {
+		return
+	}
+	if out, _ := exec.Command("git", "config", "--get-all", "credential.helper").Output(); strings.TrimSpace(string(out)) != "" {
+		return
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return
+	}
+
+	if runtime.GOOS != "windows" {
+		vcs.AddGitConfig("credential.helper", fmt.Sprintf("cache --timeout=%d", CREDENTIAL_CACHE_SECONDS))
+	}
+	vcs.AddGitConfig("credential.helper", "!"+shellQuote(executable)+" credential")
+	credentialHelperInstalled = true
+}
+
+// Reads a line from the terminal, without echoing it if hidden is set.
+func readTerminal(tty *os.File, reader *bufio.Reader, prompt string, hidden bool) (string, error) {
+	fmt.Fprint(tty, prompt)
+	if hidden {
+		stty := func(arg string) error {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = tty
+			return cmd.Run()
+		}
+		if err := stty("-echo"); err != nil {
+			return "", errors.New("could not hide the input")
+		}
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(tty)
+		}()
+	}
+	line, err := reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// Asks on the terminal for the username and token of the host, and offers to
+// remember them in the keychain. Returns false if there is no terminal or
+// nothing was entered.
+func promptCredential(c *vcs.Credential, keychain vcs.Keychain) bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+	reader := bufio.NewReader(tty)
+
+	if c.Username == "" {
+		if c.Username, err = readTerminal(tty, reader, fmt.Sprintf("Username for %s: ", c.Url()), false); err != nil || c.Username == "" {
+			return false
+		}
+	}
+	if c.Password, err = readTerminal(tty, reader, fmt.Sprintf("Token or password for %s@%s: ", c.Username, c.Host), true); err != nil || c.Password == "" {
+		return false
+	}
+	if keychain == nil {
+		return true
+	}
+	answer, _ := readTerminal(tty, reader, fmt.Sprintf("Remember it in %s? [y/N] ", keychain.Name()), false)
+	if answer := strings.ToLower(strings.TrimSpace(answer)); answer == "y" || answer == "yes" {
+		if err := keychain.Store(c); err != nil {
+			fmt.Fprintf(tty, "could not store the token in %s: %v\n", keychain.Name(), err)
+		}
+	}
+	return true
+}
+
+// Answers git's credential helper requests: get looks the host up in the
+// keychain and otherwise asks on the terminal, and erase forgets credentials
+// the host rejected. Stores are left to git's cache helper, since the
+// keychain is written when the user asks at the prompt.
+func credentialCommand(args []string) {
+	if len(args) != 1 {
+		panic(errors.New("usage: deliver credential get|store|erase, as a git credential helper"))
+	}
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		panic(err)
+	}
+	c := vcs.ParseCredential(string(input))
+	if c.Host == "" || (c.Protocol != "https" && c.Protocol != "http") {
+		return
+	}
+	keychain := vcs.SystemKeychain()
+
+	switch args[0] {
+	case "get":
+		if keychain != nil {
+			stored := &vcs.Credential{Protocol: c.Protocol, Host: c.Host}
+			if keychain.Get(stored) && (c.Username == "" || c.Username == stored.Username) {
+				fmt.Fprint(os.Stdout, vcs.FormatCredential(stored))
+				return
+			}
+		}
+		if os.Getenv("GIT_TERMINAL_PROMPT") == "0" {
+			return
+		}
+		if promptCredential(c, keychain) {
+			fmt.Fprint(os.Stdout, vcs.FormatCredential(c))
+		}
+	case "erase":
+		if keychain == nil {
+			return
+		}
+		stored := &vcs.Credential{Protocol: c.Protocol, Host: c.Host}
+		if keychain.Get(stored) && (c.Username == "" || c.Username == stored.Username) {
+			if err := keychain.Erase(stored); err != nil {
+				warnf("could not remove the rejected token for %s from %s: %v", c.Url(), keychain.Name(), err)
+			}
+		}
+	}
+}
@@ -1,317 +1,334 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
-)
+	"time"
 
-const (
-	PACKAGE_FILE   string = "packages.json"
-	LOCK_FILE      string = "packages.lock"
-	WORKSPACES_DIR string = "deliver_workspaces"
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+	"github.com/brettshollenberger/deliver/workspace"
 )
 
-var noRun *bool = flag.Bool("n", false, "print the commands but do not run them")
+var noRun *bool = flag.Bool("n", false, "print what install or update would change, or the commands other commands would run, without changing anything")
 var verbose *bool = flag.Bool("v", false, "print the commands while running them")
-var rootWorkspaceDir *string = flag.String("root", "", "where to create the deliver workspaces directory. If empty, uses home directory")
+var rootWorkspaceDir *string = flag.String("root", "", "where to create the deliver_workspaces and deliver_cache directories. If empty, uses the user's data and cache directories")
 var useDeliverWorkspace *bool = flag.Bool("deliver_workspace", false, "If true, use the project-specific Go workspace. If false, use $GOPATH")
+var installGopath *string = flag.String("install_gopath", "", "GOPATH entry to install new packages into. If empty, uses the first entry. Packages already installed in any entry are used where they are")
+var copyProject *bool = flag.Bool("copy", false, "If true, copy the project into the workspace instead of linking it, and install every package into the workspace, for build systems that don't follow symlinks")
+var useReferenceCache *bool = flag.Bool("reference_cache", false, "If true, share git objects between workspaces through a cache of bare repositories")
+var useWorktrees *bool = flag.Bool("worktrees", false, "If true, check packages out as git worktrees of a shared bare repository in the cache")
+var localBranches *bool = flag.Bool("local_branches", false, "If true, check out each package's locked revision on a local branch named after its branch, created or reset to the revision, instead of on a detached HEAD")
+var noVcsMetadata *bool = flag.Bool("no_vcs_metadata", false, "If true, remove the .git directories of installed packages, recording their revisions instead, to save space and rule out pushes from CI machines")
+var useTarballs *bool = flag.Bool("tarballs", false, "If true, install packages locked to a revision from GitHub, GitLab or Bitbucket source archives instead of cloning them")
+var archiveUser *string = flag.String("archive_user", "", "user name for downloading package archives. If empty, archive_password is sent as a bearer token")
+var archivePassword *string = flag.String("archive_password", "", "password or access token for downloading package archives. Best kept in the global config rather than given on the command line")
+var archiveHost *string = flag.String("archive_host", "", "host, with the port if it isn't the default, that archive_user and archive_password are sent to. Archives on other hosts are downloaded without them")
+var networkMode *string = flag.String("network", "allow", "allow or deny. If deny, never clone or fetch from remote repositories; only the cache, the workspace and bundles are used")
+var hostLimits *string = flag.String("host_limits", "", "limits on the git and download operations run against each host, as host=connections/interval separated by commas, e.g. -host_limits=github.com=4/250ms,*=8")
+var pinHosts *string = flag.String("pin_hosts", PIN_HOSTS_WARN, "what to do when a package host's SSH host key or TLS key differs from the one recorded in packages.lock: warn, fail, accept the new key, or off to not check. record warns too, and also lets install record the keys of new hosts, which only update does otherwise")
+var credentialPrompt *bool = flag.Bool("credential_prompt", true, "If true and git has no credential helper configured, ask for the credentials of private HTTPS repositories with hidden input, and offer to remember them in the system keychain")
+var protocols *string = flag.String("protocols", "", "how to fetch from each host, as host=protocol separated by commas, with * for other hosts: ssh, https, or ssh+https to use SSH where it works and HTTPS otherwise, e.g. -protocols=*=ssh+https. SSH and HTTPS URLs of the host are rewritten to the chosen protocol")
+var preflight *bool = flag.Bool("preflight", true, "If true, check that every package host can be reached before a full install or update, and fail early if one can't")
+var maxDepth *int = flag.Int("max_depth", 0, "how many levels of dependencies to download, with the packages in the project's manifest as level 1. If 0, there is no limit")
+var noRecursive *bool = flag.Bool("no_recursive", false, "If true, download only the packages in the project's manifest, not their dependencies. The same as -max_depth=1")
+var buildDepsAfterInstall *bool = flag.Bool("build_deps", false, "If true, run go install in every installed package after install or update, as deliver build-deps does")
+var compileCheckPackages *bool = flag.Bool("compile_check", false, "If true, run go build on every installed package and the project after install or update")
+var noColor *bool = flag.Bool("no_color", false, "If true, never color the output. Setting the NO_COLOR environment variable has the same effect")
+var profile *bool = flag.Bool("profile", false, "If true, print how long each package took to clone, fetch, check out and install dependencies")
+var events *string = flag.String("events", "", "stream newline-delimited JSON events to fd://N or a file, e.g. -events=fd://3")
+var cloneArgs *string = flag.String("clone_args", "", "extra arguments for every git clone, separated by spaces, e.g. -clone_args=--filter=tree:0")
+var fetchArgs *string = flag.String("fetch_args", "", "extra arguments for every git fetch, separated by spaces")
+var checksumDb *string = flag.String("checksum_db", "", "URL of a checksum database to verify the content hashes of locked packages with. If empty, hashes are only checked against the lockfile")
+var baseLock *string = flag.String("base_lock", "", "path or http(s) URL of the organization's baseline lockfile, whose pinned revisions packages in packages.json inherit unless they pin their own")
+var strictLock *bool = flag.Bool("strict_lock", false, "fail install if packages.lock is out of date with packages.json, instead of warning")
+var env *string = flag.String("env", "", "merge packages.<env>.json over packages.json, e.g. -env=staging")
+var strict *bool = flag.Bool("strict", false, "fail with a JSON conflict report if conflicting package versions are found")
+var conflictStrategy *string = flag.String("conflict_strategy", resolve.STRATEGY_HIGHEST, "how to choose between conflicting versions of a package: highest, for the highest release tag when every request is for a tag of the same major version; minimal, for minimal version selection as in Go modules; newest, for the revision with the newest commit date; or first, for the request nearest the project")
+var conflictsOut *string = flag.String("conflicts_out", "", "write the conflicts found by install, update or resolve to this file as a JSON array, for other tools to read")
 
-type Manifest struct {
-	Repository string `json:",omitempty"`
-	Packages   map[string]*Package
-}
-
-func (m *Manifest) writeToFile(fileName string) {
-	data, err := json.Marshal(*m)
+// Parses the package file, merging the overlay for -env over it, applying the
+// pins of the -base_lock baseline and expanding variables.
+func loadPackageFile() (*manifest.Manifest, error) {
+	m, err := manifest.Load(manifest.PACKAGE_FILE)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	var buf bytes.Buffer
-	if err := json.Indent(&buf, data, "", "\t"); err != nil {
-		panic(err)
-	}
-	ioutil.WriteFile(fileName, buf.Bytes(), 0644)
-}
-
-func (m *Manifest) hasRepository() bool {
-	return m.Repository != ""
-}
-
-// Packages defined in the manifest
-type Package struct {
-    Name     string `json:"-"`
-	Source   string
-	Branch   string `json:",omitempty"`
-	Revision string
-}
-
-func (p *Package) getBranch() string {
-	if p.Branch == "" {
-		return "master"
+	if *env != "" {
+		overlay, err := manifest.Load(manifest.OverlayFile(*env))
+		if err != nil {
+			return nil, err
+		}
+		m.Merge(overlay)
 	}
-	return p.Branch
-}
-
-func (p *Package) getRevision() string {
-	if !p.hasRevision() {
-		return "HEAD"
+	applyBaseline(m)
+	if err := m.ExpandVariables(); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %v", manifest.PACKAGE_FILE, err))
 	}
-	return p.Revision
-}
-
-func (p *Package) getRef() string {
-	return fmt.Sprintf("%s/%s", p.getBranch(), p.getRevision())
-}
-
-func (p *Package) hasRevision() bool {
-	return p.Revision != ""
-}
-
-func (p *Package) dump() {
-	fmt.Printf("%s %s/%s\n", p.Source, p.getBranch(), p.getRevision())
+	return m, nil
 }
 
-// Parses the manifest from into a Manifest struct.
-func NewManifestFromFile(manifestFile string) (manifest *Manifest) {
-	manifest = &Manifest{}
-	// Package manifest must exist.
-	fileBytes, err := ioutil.ReadFile(manifestFile)
-	if err != nil {
-		panic(err)
+// Parses a manifest, filling in the sources of packages that don't specify one.
+// The package file has the overlay for -env merged over it.
+func loadManifest(manifestFile string) *manifest.Manifest {
+	var m *manifest.Manifest
+	var err error
+	if manifestFile == manifest.PACKAGE_FILE {
+		m, err = loadPackageFile()
+	} else if m, err = manifest.Load(manifestFile); err == nil {
+		// Lockfiles keep the variables of the package file, so a mirror
+		// can be switched without updating them.
+		if err = m.ExpandVariables(); err != nil {
+			err = errors.New(fmt.Sprintf("%s: %v", manifestFile, err))
+		}
 	}
-	err = json.Unmarshal(fileBytes, manifest)
 	if err != nil {
 		panic(err)
 	}
-	for packageName, packageInfo := range manifest.Packages {
-		packageInfo.Name = packageName
-	}
-	return
-}
-
-// Encapsulates commands to run on a git repository.
-type GitRepository struct {
-	repoUrl  string
-	repoPath string
-}
-
-func (g *GitRepository) getCurrentRevision() string {
-	revisionString := runInDirectory(g.repoPath, func() (string, error) {
-		return executeCommand("git", "rev-parse", "HEAD")
-	})
-	// Strip newline character at the end
-	if len(revisionString) > 0 {
-		return revisionString[:len(revisionString)-1]
-	} else {
-		return "<REV>"
+	for packageName, packageInfo := range m.Packages {
+		if packageInfo.Source == "" {
+			source, err := vcs.ResolveSource(packageInfo.GetImportPath())
+			if err != nil {
+				panic(errors.New(fmt.Sprintf("%s: package %s has no Source, and none could be found: %v", manifestFile, packageName, err)))
+			}
+			packageInfo.Source = source
+		}
 	}
+	return m
 }
 
-func (g *GitRepository) checkoutRevision(revision string) {
-	runInDirectory(g.repoPath, func() (string, error) {
-		return executeCommand("git", "checkout", revision)
-	})
-}
-
-func (g *GitRepository) checkoutBranchTip(branch string) {
-	g.checkoutRevision(branch)
-	g.pullBranch(branch)
-}
-
-// Pulls the git repo from origin in the given repo path.
-func (g *GitRepository) pullBranch(branch string) {
-	runInDirectory(g.repoPath, func() (string, error) {
-		return executeCommand("git", "pull", "origin", branch)
-	})
+// Records what is only known once the packages are installed in a lockfile
+// about to be written: their content hashes and the keys of their hosts.
+func completeLockfile(lockManifest *manifest.Manifest) {
+	recordHashes(lockManifest)
+	recordHostKeys(lockManifest)
 }
 
-// Clones the git repo into the given directory.
-func (g *GitRepository) clone(destinationPath, branch string) {
-	_, err := executeCommand("git", "clone", "-b", branch, g.repoUrl, destinationPath)
-	if err != nil {
+func writeManifest(m *manifest.Manifest, manifestFile string) {
+	if err := m.WriteToFile(manifestFile); err != nil {
 		panic(err)
 	}
 }
 
-// Fetches the current repository.
-func (g *GitRepository) fetch() {
-	runInDirectory(g.repoPath, func() (string, error) {
-		return executeCommand("git", "fetch")
-	})
-}
-
-func (this *GitRepository) update(packageInfo *Package) {
-	if packageInfo.hasRevision() {
-		this.checkoutRevision(packageInfo.Revision)
-	} else {
-		this.checkoutBranchTip(packageInfo.getBranch())
-		packageInfo.Revision = this.getCurrentRevision()
+// Returns the workspace selected by the flags, where new packages are
+// installed.
+func getWorkspacePath() string {
+	if !*useDeliverWorkspace {
+		if *installGopath != "" {
+			return *installGopath
+		}
+		return workspace.GOPATH()
 	}
-}
-
-// Function signature used in runInDirectory().
-type CommandFunction func() (string, error)
 
-// Runs the given command function after changing the current directory to dir.
-// After the command function runs, change the directory back to the original
-// directory. Returns the output of the command function.
-func runInDirectory(dir string, command CommandFunction) string {
-	currentDir, err := os.Getwd()
+	dir, err := os.Getwd()
 	if err != nil {
 		panic(err)
 	}
+	return getWorkspacePathFor(dir)
+}
 
-	err = os.Chdir(dir)
-	if err != nil {
-		panic(err)
+// Returns the workspace selected by the flags for the project enclosing dir:
+// the nearest directory at or above it with a package file, which may be
+// nested in another project.
+func getWorkspacePathFor(dir string) string {
+	if !*useDeliverWorkspace {
+		return getWorkspacePath()
 	}
-
-	defer func() {
-		if err = os.Chdir(currentDir); err != nil {
+	if installAllManifests {
+		workspacePath, err := workspace.OpenNested(*rootWorkspaceDir, dir)
+		if err != nil {
 			panic(err)
 		}
-	}()
-
-	out, err := command()
+		return workspacePath
+	}
+	workspacePath, err := workspace.Find(dir, *rootWorkspaceDir)
 	if err != nil {
 		panic(err)
 	}
-
-	return out
+	return workspacePath
 }
 
-// Executes a shell command. Depending on the flags,
-// it may just print the command to run, or both print and
-// run the command.
-func executeCommand(args ...string) (out string, err error) {
-	var outBytes []byte
-	if *noRun || *verbose {
-		logArgs := make([]interface{}, len(args))
-		for i, arg := range args {
-			logArgs[i] = interface{}(arg)
+// Returns the GOPATH to build with: the project's workspace, or every $GOPATH
+// entry along with the one packages are installed into.
+func getBuildGOPATH() string {
+	if *useDeliverWorkspace {
+		return getWorkspacePath()
+	}
+	entries := workspace.GOPATHs()
+	for _, entry := range entries {
+		if entry == getWorkspacePath() {
+			return strings.Join(entries, string(filepath.ListSeparator))
 		}
-		fmt.Fprintln(os.Stdout, logArgs...)
 	}
+	return strings.Join(append([]string{getWorkspacePath()}, entries...), string(filepath.ListSeparator))
+}
 
-	if !*noRun {
-		cmd := exec.Command(args[0], args[1:]...)
-		outBytes, err = cmd.Output()
+// Returns where a package is installed. Outside a project workspace, a package
+// already installed in any $GOPATH entry is used where it is, unless -copy
+// asks for a self-contained workspace.
+func getPackageDir(packageName string) string {
+	if !*useDeliverWorkspace && !*copyProject {
+		if dir, ok := workspace.FindPackage(append([]string{getWorkspacePath()}, workspace.GOPATHs()...), packageName); ok {
+			return dir
+		}
 	}
-	return string(outBytes), err
+	return workspace.PackageDir(getWorkspacePath(), packageName)
 }
 
-func pathCompare(a string, b string) bool {
-	realA, err := filepath.EvalSymlinks(a)
-	if err != nil {
-		panic(err)
+// Fills in the project's import path from its git remote if the manifest
+// doesn't name it, so the workspace symlink needs no configuration.
+func detectRepository(m *manifest.Manifest) {
+	if m.HasRepository() {
+		return
 	}
-	realB, err := filepath.EvalSymlinks(b)
-	if err != nil {
-		panic(err)
+	if importPath, ok := vcs.OriginImportPath("."); ok {
+		fmt.Fprintf(os.Stdout, "using %s, from the origin remote, as the repository\n", importPath)
+		m.Repository = importPath
 	}
-	return realA == realB
 }
 
-func createWorkspaceSymlink(repositoryPath string) {
+// Returns the directory of the project that should be linked into the
+// workspace at importPath.
+func getLinkTarget(m *manifest.Manifest, importPath string) string {
 	currentDir, err := os.Getwd()
 	if err != nil {
 		panic(err)
 	}
-	linkPath := path.Join(getWorkspacePath(), "src", repositoryPath)
+	dir := m.Links()[importPath]
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(currentDir, dir)
+}
 
-	if pathCompare(linkPath, currentDir) {
-		fmt.Fprintln(os.Stdout, "skipping symlink...")
-		return
+// Links each of the project's import paths into the workspace, or copies
+// them with -copy.
+func createWorkspaceSymlinks(m *manifest.Manifest) {
+	for _, importPath := range m.LinkPaths() {
+		if *copyProject {
+			copied, err := workspace.CopyProject(getWorkspacePath(), importPath, getLinkTarget(m, importPath))
+			if err != nil {
+				panic(err)
+			}
+			if !copied {
+				fmt.Fprintf(os.Stdout, "copy of %s is up to date\n", importPath)
+			}
+			continue
+		}
+		created, err := workspace.CreateSymlink(getWorkspacePath(), importPath, getLinkTarget(m, importPath))
+		if err != nil {
+			panic(err)
+		}
+		if !created {
+			fmt.Fprintf(os.Stdout, "skipping symlink for %s...\n", importPath)
+		}
 	}
+}
 
-	linkDir := path.Join(linkPath, "..")
-	_, err = executeCommand("mkdir", "-p", linkDir)
-	if err != nil {
-		panic(err)
+// Gets or updates all packages specified in the given file.
+// Fetches packages recursively if one of the referenced packages
+// has a manifest.
+func downloadPackages(parent *resolve.Node, m *manifest.Manifest) {
+	for _, packageInfo := range m.Packages {
+		if installExcept[packageInfo.Name] {
+			fmt.Fprintf(os.Stdout, "skipping %s (-except)\n", packageInfo.Name)
+			continue
+		}
+		child := downloadPackage(packageInfo)
+		parent.AddChild(child)
 	}
+}
 
-	// Remove existing symlink
-	_, err = executeCommand("rm", "-f", linkPath)
-	if err != nil {
-		panic(err)
+func GitRepositoryFromPackage(packageInfo *manifest.Package) *vcs.GitRepository {
+	packageDir := getPackageDir(packageInfo.GetImportPath())
+	git := &vcs.GitRepository{
+		Name:        packageInfo.Name,
+		RepoUrl:     packageInfo.Source,
+		RepoPath:    packageDir,
+		CachePath:   workspace.CachePath(*rootWorkspaceDir, packageInfo.Source),
+		Reference:   *useReferenceCache,
+		CloneArgs:   append(strings.Fields(*cloneArgs), packageInfo.CloneArgs...),
+		FetchArgs:   append(strings.Fields(*fetchArgs), packageInfo.FetchArgs...),
+		Exclude:     excludePatterns(packageInfo),
+		LocalBranch: *localBranches,
 	}
+	if packageInfo.ForkImportPath != "" {
+		git.RewriteImports = map[string]string{packageInfo.ForkImportPath: packageInfo.GetImportPath()}
+	}
+	if *useWorktrees {
+		// Packages that were already cloned normally stay that way.
+		info, err := os.Stat(path.Join(packageDir, ".git"))
+		git.Worktree = err != nil || !info.IsDir()
+	}
+	return git
+}
 
-	_, err = executeCommand("ln", "-s", currentDir, linkPath)
+// Returns the revision checked out in the package's directory, or false if the
+// package is not installed.
+func getInstalledRevision(git *vcs.GitRepository) (string, bool) {
+	if revision, ok := vcs.TarballRevision(git.RepoPath); ok {
+		return revision, true
+	}
+	if !git.IsCloned() {
+		return "", false
+	}
+	revision, err := git.CurrentRevision()
 	if err != nil {
 		panic(err)
 	}
+	return revision, true
 }
 
-// Traverse the path up towards the root. If a directory has a packages.json file,
-// then workspace/ in the same directory is the workspace.
-// If we get to the root directory, return the env GOPATH.
-func getWorkspacePath() string {
-	if !*useDeliverWorkspace {
-		return strings.Split(os.Getenv("GOPATH"), ":")[0]
+// Installs packageInfo from a source archive if -tarballs is set. Returns
+// false if it has to be checked out with git instead.
+func downloadTarball(packageInfo *manifest.Package, dir string) bool {
+	if !*useTarballs {
+		return false
 	}
-
-	dir, err := os.Getwd()
+	ok, err := vcs.DownloadTarball(packageInfo, dir)
 	if err != nil {
-		panic(err)
+		warnf("%v, falling back to git", err)
 	}
+	return ok
+}
 
-	for {
-		possibleManifest := path.Join(dir, PACKAGE_FILE)
-		_, err := os.Stat(possibleManifest)
+// Brings the package directory to the package's revision with the first
+// fetcher that supports the package, falling back to the next if it fails.
+func checkoutPackage(git *vcs.GitRepository, packageInfo *manifest.Package) {
+	fetchers := vcs.FetchersFor(packageInfo, git.RepoPath)
+	if len(fetchers) == 0 {
+		panic(errors.New(fmt.Sprintf("%s can't be installed: no fetcher supports %s", packageInfo.Name, packageInfo.Source)))
+	}
+	for i, fetcher := range fetchers {
+		err := fetcher.Fetch(packageInfo, git.RepoPath)
 		if err == nil {
-			// packages.json exists. Crete workspace
-			var workspaceRoot string
-			if len(*rootWorkspaceDir) == 0 {
-				workspaceRoot = os.Getenv("HOME")
-			} else {
-				workspaceRoot = *rootWorkspaceDir
-			}
-			return path.Join(workspaceRoot, WORKSPACES_DIR, dir)
+			return
 		}
-
-		if os.IsNotExist(err) {
-			// packages.json does not exist.
-			if dir == "/" {
-				// If we're already at the root, return
-				// the GOPATH environment variable.
-				return os.Getenv("GOPATH")
-			} else {
-				// Check the parent directory.
-				dir = path.Join(dir, "..")
-			}
-		} else {
-			// some other error occured during os.Stat.
+		if i == len(fetchers)-1 {
 			panic(err)
 		}
+		warnf("%v, falling back to %s", err, fetchers[i+1].Name())
 	}
 }
 
-// Gets or updates all packages specified in the given file.
-// Fetches packages recursively if one of the referenced packages
-// has a manifest.
-func downloadPackages(parent *Node, manifest *Manifest) {
-	for _, packageInfo := range manifest.Packages {
-		child := downloadPackage(packageInfo)
-		parent.addChild(child)
-	}
-}
+// How deep in the dependency tree downloadPackage is: 1 while it downloads a
+// package in the project's manifest, 2 for their dependencies, and so on.
+var downloadDepth int
 
-func GitRepositoryFromPackage(packageInfo *Package) *GitRepository {
-	packageDir := path.Join(getWorkspacePath(), "src", packageInfo.Name)
-	git := &GitRepository{
-		repoUrl:  packageInfo.Source,
-		repoPath: packageDir,
+// Returns how many levels of dependencies to download, or 0 for all of them.
+func downloadDepthLimit() int {
+	if *noRecursive {
+		return 1
 	}
-	return git
+	return *maxDepth
 }
 
 // Installs the given package. If the package has a locked revision,
@@ -319,34 +336,36 @@ func GitRepositoryFromPackage(packageInfo *Package) *GitRepository {
 // by checking out the tip of the specified branch, and save the new revision to packageInfo.
 // If the package itself has dependencies specified in a lockfile, recursively download
 // them as well.
-func downloadPackage(packageInfo *Package) *Node {
+func downloadPackage(packageInfo *manifest.Package) *resolve.Node {
+	downloadDepth++
+	defer func() { downloadDepth-- }()
 	git := GitRepositoryFromPackage(packageInfo)
 
-	fmt.Fprintf(os.Stdout, "downloading %s -> %s\n", packageInfo.Name, git.repoPath)
-
-	// If package directory does not exist, create the directory.
-	if _, err := os.Stat(git.repoPath); os.IsNotExist(err) {
-		_, execErr := executeCommand("mkdir", "-p", git.repoPath)
-		if execErr != nil {
-			panic(execErr)
-		}
-	}
-
-	// Check if repository already exists in package directory.
-	gitInfoPath := path.Join(git.repoPath, ".git")
-	if _, err := os.Stat(gitInfoPath); os.IsNotExist(err) {
-		// Git repo does not exist. Clone it.
-		git.clone(git.repoPath, packageInfo.getBranch())
+	if installedByJournal(packageInfo) {
+		fmt.Fprintf(os.Stdout, "already installed %s at %s\n", colorize(os.Stdout, COLOR_BOLD, packageInfo.Name), packageInfo.Revision)
 	} else {
-		// Git repo exists. Pull latest.
-		git.fetch()
+		fmt.Fprintf(os.Stdout, "downloading %s -> %s\n", colorize(os.Stdout, COLOR_BOLD, packageInfo.Name), git.RepoPath)
+		emitEvent(&Event{Type: EVENT_PACKAGE_START, Package: packageInfo.Name, Source: packageInfo.Source, Path: git.RepoPath})
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					emitEvent(&Event{Type: EVENT_PACKAGE_FAIL, Package: packageInfo.Name, Source: packageInfo.Source, Error: fmt.Sprint(r)})
+					panic(r)
+				}
+			}()
+			vcs.ChooseProtocol(packageInfo.Source)
+			checkHostKey(packageInfo)
+			checkoutPackage(git, packageInfo)
+		}()
+		emitEvent(&Event{Type: EVENT_PACKAGE_FINISH, Package: packageInfo.Name, Source: packageInfo.Source, Ref: packageInfo.GetRef(), Path: git.RepoPath})
+		recordInJournal(packageInfo)
 	}
-	git.update(packageInfo)
 
-	node := NewNode(packageInfo)
+	node := resolve.NewNode(packageInfo)
 
 	// Check if package has its own dependencies.
-	packageManifestFile := path.Join(git.repoPath, LOCK_FILE)
+	packageManifestFile := path.Join(git.RepoPath, manifest.LOCK_FILE)
 	_, err := os.Stat(packageManifestFile)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -354,26 +373,203 @@ func downloadPackage(packageInfo *Package) *Node {
 		}
 	} else if err == nil {
 		// No error from stat() means the .lock file exists.
-		packageManifest := NewManifestFromFile(packageManifestFile)
+		packageManifest := loadManifest(packageManifestFile)
+		if limit := downloadDepthLimit(); limit > 0 && downloadDepth >= limit {
+			if len(packageManifest.Packages) > 0 {
+				warnf("not downloading the %d dependencies of %s: the depth limit of %d was reached", len(packageManifest.Packages), packageInfo.Name, limit)
+			}
+			return node
+		}
 
 		// Download dependencies in the manifest.
 		fmt.Fprintf(os.Stdout, "getting dependencies of %s...\n", packageInfo.Name)
+		start := time.Now()
 		downloadPackages(node, packageManifest)
+		recordTiming(packageInfo.Name, PHASE_DEPENDENCIES, start)
 		fmt.Fprintf(os.Stdout, "done with dependencies of %s\n", packageInfo.Name)
 	}
 
 	return node
 }
 
+// A package a new lockfile added, removed or moved. Before is nil for an
+// added package, and After for a removed one.
+type lockChange struct {
+	Name   string
+	Before *manifest.Package
+	After  *manifest.Package
+}
+
+// Returns the packages a new lockfile added, removed or moved, sorted by name.
+func lockChanges(previous, next *manifest.Manifest) []*lockChange {
+	var names []string
+	for name := range next.Packages {
+		names = append(names, name)
+	}
+	for name := range previous.Packages {
+		if _, ok := next.Packages[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []*lockChange{}
+	for _, name := range names {
+		before, after := previous.Packages[name], next.Packages[name]
+		if before == nil || after == nil || before.GetRef() != after.GetRef() || before.Source != after.Source {
+			changes = append(changes, &lockChange{Name: name, Before: before, After: after})
+		}
+	}
+	return changes
+}
+
+// Returns the package as the new lockfile has it, or as the old one did if it
+// was removed.
+func (c *lockChange) Package() *manifest.Package {
+	if c.After == nil {
+		return c.Before
+	}
+	return c.After
+}
+
+// Returns a line for a commit message or release notes, such as "Bump
+// example.com/a from 1a2b3c4d5e6f to 6f5e4d3c2b1a".
+func (c *lockChange) Summary() string {
+	switch {
+	case c.Before == nil:
+		return fmt.Sprintf("Add %s at %s", c.Name, vcs.ShortRevision(c.After.GetRevision()))
+	case c.After == nil:
+		return fmt.Sprintf("Remove %s", c.Name)
+	}
+	return fmt.Sprintf("Bump %s from %s to %s", c.Name, vcs.ShortRevision(c.Before.GetRevision()), vcs.ShortRevision(c.After.GetRevision()))
+}
+
+// Summarizes the packages added, updated and removed by a new lockfile.
+func printLockChanges(previous *manifest.Manifest, next *manifest.Manifest) {
+	reviewers := []string{}
+	for _, change := range lockChanges(previous, next) {
+		switch {
+		case change.Before == nil:
+			fmt.Fprintf(os.Stdout, "%s %s %s%s\n", colorize(os.Stdout, COLOR_GREEN, "added"), change.Name, change.After.GetRef(), ownersSuffix(change.After))
+		case change.After == nil:
+			fmt.Fprintf(os.Stdout, "%s %s%s\n", colorize(os.Stdout, COLOR_RED, "removed"), change.Name, ownersSuffix(change.Before))
+		default:
+			fmt.Fprintf(os.Stdout, "%s %s %s -> %s%s\n", colorize(os.Stdout, COLOR_CYAN, "updated"), change.Name, change.Before.GetRef(), change.After.GetRef(), ownersSuffix(change.After))
+		}
+		reviewers = append(reviewers, change.Package().GetOwners()...)
+	}
+	if reviewers = uniqueStrings(reviewers); len(reviewers) > 0 {
+		fmt.Fprintf(os.Stdout, "reviewers: %s\n", strings.Join(reviewers, " "))
+	}
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "Deliver is a package manager for Go\n\n")
 	fmt.Fprintf(os.Stderr, "Usage:\n\n  deliver [flags] [command] [arguments]\n\n")
 	fmt.Fprintf(os.Stderr, "The commands are:\n\n")
-	fmt.Fprintf(os.Stderr, "  install [package]\tInstalls all packages in packages.lock.\n"+
-		"                   \tIf a package name is provided, installs only a single package.\n")
-	fmt.Fprintf(os.Stderr, "  update [package] \tUpdates all packages in packages.json to the latest versions, and\n"+
+	fmt.Fprintf(os.Stderr, "  init              \tCreates packages.json, naming the project after its git remote.\n")
+	fmt.Fprintf(os.Stderr, "  config [-global] get|set|unset|list [key] [value]\n"+
+		"                   \tReads and writes the project's settings, or the user's with -global.\n")
+	fmt.Fprintf(os.Stderr, "  ci-init -docker|-github-actions\n"+
+		"                   \tPrints a Dockerfile or GitHub Actions workflow that installs the locked\n"+
+		"                   \tdependencies, cached until packages.lock changes.\n")
+	fmt.Fprintf(os.Stderr, "  envrc             \tWrites the workspace's GOPATH and bin directory to a section of .envrc,\n"+
+		"                   \tfor direnv.\n")
+	fmt.Fprintf(os.Stderr, "  ide setup         \tPoints gopls and VS Code's Go tools and debugger at the workspace, in\n"+
+		"                   \t.vscode/settings.json and .vscode/launch.json.\n")
+	fmt.Fprintf(os.Stderr, "  fmt [-check] [file]\n"+
+		"                   \tRewrites packages.json in canonical form, or checks that it is.\n")
+	fmt.Fprintf(os.Stderr, "  install [-sync] [-all-manifests|-each-manifest] [package]\n"+
+		"                   \tInstalls all packages in packages.lock.\n"+
+		"                   \tIf a package name is provided, installs only a single package.\n"+
+		"                   \tWith -all-manifests, the lockfiles of every project under the current\n"+
+		"                   \tdirectory are installed into one shared workspace, and with\n"+
+		"                   \t-each-manifest, each into a workspace of its own.\n"+
+		"                   \tWith -sync, packages added to packages.json since the last update are\n"+
+		"                   \tinstalled too, and added to packages.lock.\n")
+	fmt.Fprintf(os.Stderr, "  update [-patch|-minor|-major] [-with_deps] [-commit|-push|-pr] [package]\n"+
+		"                   \tUpdates all packages in packages.json to the latest versions, and\n"+
 		"                   \tsaves the versions to packages.lock.\n"+
-		"                   \tIf a package name is provided, updates only a single package.\n")
+		"                   \tIf a package name is provided, updates only a single package.\n"+
+		"                   \tWith -patch, -minor or -major, packages on release tags only move to\n"+
+		"                   \tnewer tags of the same minor or major version, or to any newer tag.\n"+
+		"                   \tWith -with_deps, the package's dependencies are updated and locked too.\n"+
+		"                   \tWith -commit, -push or -pr, the changes are committed to a new branch,\n"+
+		"                   \twhich is pushed and opened as a GitHub or GitLab pull request.\n")
+	fmt.Fprintf(os.Stderr, "  repair [package]  \tClones broken packages again, fixes their remotes, and checks out their\n"+
+		"                   \tlocked revisions. Use -n to only list the problems.\n")
+	fmt.Fprintf(os.Stderr, "  undo              \tReturns packages.lock and the installed packages to how they were\n"+
+		"                   \tbefore the last update.\n")
+	fmt.Fprintf(os.Stderr, "  lock              \tRebuilds packages.lock from packages.json and the revisions checked\n"+
+		"                   \tout in the workspace, without fetching anything.\n")
+	fmt.Fprintf(os.Stderr, "  lock sign [-format ssh|minisign] [-key file]\n"+
+		"                   \tWrites a detached signature of packages.lock.\n")
+	fmt.Fprintf(os.Stderr, "  lock verify [-format ssh|minisign] -keys file\n"+
+		"                   \tFails unless packages.lock was signed by one of the given keys.\n")
+	fmt.Fprintf(os.Stderr, "  resolve [-json]   \tPrints conflicting package versions found in the installed lockfiles,\n"+
+		"                   \twithout downloading or modifying anything.\n")
+	fmt.Fprintf(os.Stderr, "  which <package>   \tPrints the path of a package in the workspace, and exits with an\n"+
+		"                   \terror if it is missing or not at the locked revision.\n")
+	fmt.Fprintf(os.Stderr, "  status            \tPrints the state of each package's checkout, telling the detached HEADs\n"+
+		"                   \tinstall leaves apart from moved, switched or changed checkouts.\n")
+	fmt.Fprintf(os.Stderr, "  path [dir]        \tPrints the workspace of the project enclosing a directory, or the\n"+
+		"                   \tcurrent one.\n")
+	fmt.Fprintf(os.Stderr, "  list              \tLists the packages in packages.lock with their versions and notes.\n")
+	fmt.Fprintf(os.Stderr, "  info <package>    \tPrints the source, revisions, last commit and dependents of a package.\n")
+	fmt.Fprintf(os.Stderr, "  explain <package> \tPrints why a package is locked at its revision: what requested it, the\n"+
+		"                   \tconstraints on it, and which requests conflict resolution overrode.\n")
+	fmt.Fprintf(os.Stderr, "  owners <package>  \tPrints the Owners who review changes to a package, or for a dependency\n"+
+		"                   \tof dependencies, the owners of the packages that pull it in.\n")
+	fmt.Fprintf(os.Stderr, "  digest [-installed] [-list]\n"+
+		"                   \tPrints a hash of the locked dependency set, or with -installed of what is\n"+
+		"                   \tinstalled, for CI cache keys and comparing machines.\n")
+	fmt.Fprintf(os.Stderr, "  export bazel [-macro name]\n"+
+		"                   \tPrints go_repository rules for the locked dependencies, for Bazel.\n")
+	fmt.Fprintf(os.Stderr, "  report [-format csv|html]\n"+
+		"                   \tPrints every locked package with its source, revision, last update,\n"+
+		"                   \tlicense and whether it is a direct or transitive dependency.\n")
+	fmt.Fprintf(os.Stderr, "  size              \tPrints the disk usage of each installed package, largest first.\n")
+	fmt.Fprintf(os.Stderr, "  publish [-sign] vX.Y.Z\n"+
+		"                   \tTags a release with notes from the commits since the previous one, and\n"+
+		"                   \tpushes the tag.\n")
+	fmt.Fprintf(os.Stderr, "  check             \tReports locked packages that drifted from the -base_lock baseline.\n")
+	fmt.Fprintf(os.Stderr, "  outdated [-advisories] [-notify stdout-json|slack://...|url]\n"+
+		"                   \tLists locked packages with newer revisions or releases, or advisories,\n"+
+		"                   \tand sends the digest to a webhook, for cron jobs.\n")
+	fmt.Fprintf(os.Stderr, "  audit [-fix]      \tLists the advisories against the locked revisions, and with -fix moves\n"+
+		"                   \tthe vulnerable packages to the nearest revisions that fix them.\n")
+	fmt.Fprintf(os.Stderr, "  stale [-than 12m] \tLists packages locked to old revisions or whose upstream has gone quiet.\n")
+	fmt.Fprintf(os.Stderr, "  bundle create|restore <archive.tar>\n"+
+		"                   \tWrites every installed package and the lockfile to an archive, or\n"+
+		"                   \tinstalls the workspace from one without using the network.\n")
+	fmt.Fprintf(os.Stderr, "  version [-check]  \tPrints the version of deliver and how it was built, and with -check\n"+
+		"                   \twhether a newer release is available.\n")
+	fmt.Fprintf(os.Stderr, "  doctor            \tChecks git, the Go environment, the workspace and SSH access to\n"+
+		"                   \tpackage hosts, and suggests fixes for any problems.\n")
+	fmt.Fprintf(os.Stderr, "  serve [-socket path]\n"+
+		"                   \tAnswers JSON-RPC requests for conflicts, install status, package paths\n"+
+		"                   \tand the dependency graph on a unix socket.\n")
+	fmt.Fprintf(os.Stderr, "  watch [-interval 2s]\n"+
+		"                   \tUpdates or installs packages whenever packages.json or a lockfile changes.\n")
+	fmt.Fprintf(os.Stderr, "  foreach [-parallel n] -- <command>\n"+
+		"                   \tRuns a command in the directory of every installed package.\n")
+	fmt.Fprintf(os.Stderr, "  build-deps [-parallel n]\n"+
+		"                   \tRuns go install in every installed package, several at once, to prebuild them.\n")
+	fmt.Fprintf(os.Stderr, "  compat [-go 1.x]   \tReports the installed packages that need a newer release of Go.\n")
+	fmt.Fprintf(os.Stderr, "  test-deps [-vet] [-- go flags]\n"+
+		"                   \tRuns go test (or go vet) in every installed package and reports failures.\n")
+	fmt.Fprintf(os.Stderr, "  plan [-o plan.json] install|update [package]\n"+
+		"                   \tPrints what install or update would change, and saves it to a plan file.\n")
+	fmt.Fprintf(os.Stderr, "  apply <plan.json> \tCarries out exactly the operations in a saved plan.\n")
+	fmt.Fprintf(os.Stderr, "  workspaces list|path|remove [project]\n"+
+		"                   \tLists the project workspaces with their sizes, prints the path of one,\n"+
+		"                   \tor removes one.\n")
+	fmt.Fprintf(os.Stderr, "  prefetch          \tFetches every locked package and its dependencies into the shared cache,\n"+
+		"                   \twithout touching the workspace.\n")
+	fmt.Fprintf(os.Stderr, "  credential get|store|erase\n"+
+		"                   \tThe git credential helper deliver sets up for its own git commands.\n")
+	fmt.Fprintf(os.Stderr, "  gc [-idle 90d]     \tRemoves the workspaces of projects that were moved or deleted, or that\n"+
+		"                   \thaven't been used for the given time. Use -n to only list them.\n")
 	fmt.Fprintf(os.Stderr, "The flags are:\n\n")
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "\n")
@@ -391,76 +587,508 @@ func main() {
 
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", r)
+			emitEvent(&Event{Type: EVENT_ERROR, Error: fmt.Sprint(r)})
+			fmt.Fprintf(os.Stderr, "%s\n", colorize(os.Stderr, COLOR_RED, fmt.Sprint(r)))
 			os.Exit(1)
 		}
 	}()
 
+	if args[0] != "config" {
+		// The config command should still work if the config is broken.
+		applyConfig()
+	}
+	registerFetchers()
+	setupColors()
+	resolve.HighlightChosen = func(text string) string {
+		return colorize(os.Stdout, COLOR_GREEN, text)
+	}
+
+	switch *conflictStrategy {
+	case resolve.STRATEGY_HIGHEST, resolve.STRATEGY_MINIMAL, resolve.STRATEGY_NEWEST, resolve.STRATEGY_FIRST:
+	default:
+		panic(errors.New(fmt.Sprintf("invalid -conflict_strategy value %q: must be %s, %s, %s or %s", *conflictStrategy,
+			resolve.STRATEGY_HIGHEST, resolve.STRATEGY_MINIMAL, resolve.STRATEGY_NEWEST, resolve.STRATEGY_FIRST)))
+	}
+	switch *pinHosts {
+	case PIN_HOSTS_WARN, PIN_HOSTS_RECORD, PIN_HOSTS_FAIL, PIN_HOSTS_ACCEPT, PIN_HOSTS_OFF:
+	default:
+		panic(errors.New(fmt.Sprintf("invalid -pin_hosts value %q: must be %s, %s, %s, %s or %s", *pinHosts,
+			PIN_HOSTS_WARN, PIN_HOSTS_RECORD, PIN_HOSTS_FAIL, PIN_HOSTS_ACCEPT, PIN_HOSTS_OFF)))
+	}
+	if *networkMode != "allow" && *networkMode != "deny" {
+		panic(errors.New(fmt.Sprintf("invalid -network value %q: must be allow or deny", *networkMode)))
+	}
+	openEventStream(*events)
+	vcs.DryRun = *noRun
+	vcs.Verbose = *verbose
+	vcs.NetworkDenied = *networkMode == "deny"
+	// Files such as the lockfile are loaded more than once.
+	warned := map[string]bool{}
+	manifest.Warn = func(err error) {
+		if !warned[err.Error()] {
+			warned[err.Error()] = true
+			warnf("%v", err)
+		}
+	}
+	vcs.ArchiveUser, vcs.ArchivePassword, vcs.ArchiveHost = *archiveUser, *archivePassword, *archiveHost
+	if *archiveHost == "" && (*archiveUser != "" || *archivePassword != "") {
+		warnf("archive credentials are set but archive_host isn't, so they aren't sent to any host")
+	}
+	limits, err := vcs.ParseHostLimits(*hostLimits)
+	if err != nil {
+		panic(err)
+	}
+	vcs.HostLimits = limits
+	preferences, err := vcs.ParseProtocolPreferences(*protocols)
+	if err != nil {
+		panic(err)
+	}
+	vcs.ProtocolPreferences = preferences
+	ignorePatterns = loadIgnoreFile(IGNORE_FILE)
+
+	if args[0] == "version" {
+		// Needs no project.
+		versionCommand(args[1:])
+		return
+	}
+	if args[0] == "credential" {
+		// Answers git, which runs deliver as its credential helper.
+		credentialCommand(args[1:])
+		return
+	}
+	installCredentialHelper()
+
+	if args[0] == "update" {
+		args = parseUpdateArgs(args)
+	}
+
+	if args[0] == "install" {
+		args = parseInstallArgs(args)
+	}
+
 	workspacePath := getWorkspacePath()
 	currentPath, _ := filepath.Abs(".")
 	packagePath := strings.TrimPrefix(currentPath, filepath.Join(workspacePath, "src"))
 
-	root := NewNode(&Package{Source: packagePath})
+	root := resolve.NewNode(&manifest.Package{Source: packagePath})
+
+	// Lockfile to write once the dependency tree has been checked.
+	var newLockManifest *manifest.Manifest
+	// Conflict resolutions recorded by a previous update.
+	var resolutions map[string]*manifest.Resolution
+	// Whether the whole tree was resolved, so the decisions should be recorded.
+	var recordResolutions bool
+
+	if args[0] == "install" && len(args) == 1 && !installAllManifests && !installEachManifest {
+		checkLockIsCurrent()
+	}
+
+	if *noRun && (args[0] == "install" || args[0] == "update") {
+		if updateWithDeps {
+			panic(errors.New("update -with_deps can't be planned, since dependencies aren't known until their new revisions are fetched"))
+		}
+		// Work out the changes from the workspace as it is, rather than
+		// pretending to run commands whose output later steps depend on.
+		// Planning only runs commands that change nothing.
+		vcs.DryRun = false
+		plan := computePlan(root, args)
+		plan.Print(os.Stdout)
+		if *strict && len(plan.Conflicts) > 0 {
+			panic(errors.New(fmt.Sprintf("%d conflicting package versions found (strict mode)", len(plan.Conflicts))))
+		}
+		return
+	}
 
 	switch args[0] {
+	case "init":
+		// Starts a new package file.
+		initCommand()
+		return
+
+	case "config":
+		// Reads and writes settings.
+		configCommand(args[1:])
+		return
+
+	case "fmt":
+		// Canonicalizes the package file.
+		fmtCommand(args[1:])
+		return
+
+	case "lock":
+		switch {
+		case len(args) > 1 && args[1] == "sign":
+			// Signs the lockfile.
+			lockSignCommand(args[2:])
+		case len(args) > 1 && args[1] == "verify":
+			// Checks who signed the lockfile.
+			lockVerifyCommand(args[2:])
+		default:
+			// Rebuilds the lockfile from the workspace.
+			lockCommand(root)
+		}
+		return
+
+	case "repair":
+		// Fixes broken checkouts.
+		repairCommand(root, args[1:])
+		return
+
+	case "undo":
+		// Returns to the state before the last update.
+		undoCommand()
+		return
+
+	case "ci-init":
+		// Generates CI pipelines.
+		ciInitCommand(args[1:])
+		return
+
+	case "envrc":
+		// Sets up direnv.
+		envrcCommand()
+		return
+
+	case "ide":
+		// Sets up editors.
+		ideCommand(args[1:])
+		return
+
 	case "path":
-		// Return the deliver gopath.
+		// Return the deliver gopath, of the project enclosing the given
+		// directory if there is one.
+		if len(args) > 2 {
+			panic(errors.New("usage: deliver path [dir]"))
+		}
+		if len(args) == 2 {
+			dir, err := filepath.Abs(args[1])
+			if err != nil {
+				panic(err)
+			}
+			workspacePath = getWorkspacePathFor(dir)
+		}
 		fmt.Fprintf(os.Stdout, "%s", workspacePath)
 		os.Exit(0)
 
+	case "resolve":
+		// Reports conflicts without touching the workspace.
+		resolveCommand(root, args[1:])
+		return
+
+	case "status":
+		// Reports checkouts that aren't as install left them.
+		statusCommand(root, args[1:])
+		return
+
+	case "which":
+		// Prints where a package lives in the workspace.
+		whichCommand(root, args[1:])
+		return
+
+	case "list":
+		// Lists the direct dependencies.
+		listCommand()
+		return
+
+	case "info":
+		// Describes a single package.
+		infoCommand(root, args[1:])
+		return
+
+	case "explain":
+		// Explains why a package is locked at its revision.
+		explainCommand(root, args[1:])
+		return
+
+	case "owners":
+		// Prints who reviews changes to a package.
+		ownersCommand(root, args[1:])
+		return
+
+	case "digest":
+		// Hashes the dependency set.
+		digestCommand(root, args[1:])
+		return
+
+	case "export":
+		// Exports the locked dependencies for other build systems.
+		exportCommand(root, args[1:])
+		return
+
+	case "report":
+		// Exports an inventory of the dependencies.
+		reportCommand(root, args[1:])
+		return
+
+	case "size":
+		// Reports disk usage of the workspace.
+		sizeCommand(root)
+		return
+
+	case "publish":
+		// Tags and pushes a release of the project.
+		publishCommand(args[1:])
+		return
+
+	case "check":
+		// Compares the lockfile with the organization's baseline.
+		checkCommand(args[1:])
+		return
+
+	case "outdated":
+		// Reports available updates and advisories.
+		outdatedCommand(args[1:])
+		return
+
+	case "audit":
+		// Reports, and optionally fixes, vulnerable packages.
+		auditCommand(args[1:])
+		return
+
+	case "stale":
+		// Flags old and possibly abandoned packages.
+		staleCommand(root, args[1:])
+		return
+
+	case "bundle":
+		// Moves the workspace to or from an offline archive.
+		bundleCommand(root, args[1:])
+		return
+
+	case "doctor":
+		// Diagnoses the environment.
+		doctorCommand()
+		return
+
+	case "foreach":
+		// Runs a command across the dependencies.
+		foreachCommand(root, args[1:])
+		return
+
+	case "build-deps":
+		// Prebuilds the dependencies.
+		buildDepsCommand(root, args[1:])
+		return
+
+	case "compat":
+		// Checks the dependencies against the local Go release.
+		compatCommand(root, args[1:])
+		return
+
+	case "test-deps":
+		// Checks the dependencies against the current Go version.
+		testDepsCommand(root, args[1:])
+		return
+
+	case "plan":
+		// Saves what install or update would do.
+		planCommand(root, args[1:])
+		return
+
+	case "apply":
+		// Carries out a saved plan.
+		applyCommand(args[1:])
+		return
+
+	case "workspaces":
+		// Inspects the project workspaces.
+		workspacesCommand(args[1:])
+		return
+
+	case "prefetch":
+		// Warms the shared cache.
+		prefetchCommand()
+		return
+
+	case "gc":
+		// Cleans up the workspaces directory.
+		gcCommand(args[1:])
+		return
+
+	case "serve":
+		// Answers queries from editors and build tools.
+		serveCommand(root, args[1:])
+		return
+
+	case "watch":
+		// Reconciles the workspace as the manifests change.
+		watchCommand(args[1:])
+		return
+
 	case "install":
 		// Downloads packages from the lockfile.
-		lockManifest := NewManifestFromFile(LOCK_FILE)
+		if installEachManifest {
+			// Each project is installed on its own.
+			installManifestsSeparately()
+			return
+		}
+		if installAllManifests {
+			// The journal follows a single lockfile, so isn't kept.
+			resolutions = installManifests(root)
+			break
+		}
+		lockManifest := loadManifest(manifest.LOCK_FILE)
+		detectRepository(lockManifest)
+		resolutions = lockManifest.Resolutions
+		startJournal()
 		if len(args) == 2 {
 			packageName := args[1]
 			packageInfo, ok := lockManifest.Packages[packageName]
 			if !ok {
-				panic(errors.New(fmt.Sprintf("Package %s not found in %s", packageName, LOCK_FILE)))
+				panic(errors.New(fmt.Sprintf("Package %s not found in %s", packageName, manifest.LOCK_FILE)))
 			}
 			root = downloadPackage(packageInfo)
+			verifyHashes(&manifest.Manifest{Packages: map[string]*manifest.Package{packageName: packageInfo}})
 		} else {
-			downloadPackages(root, lockManifest)
-			if lockManifest.hasRepository() {
-				createWorkspaceSymlink(lockManifest.Repository)
+			selected := selectPackages(lockManifest)
+			preflightHosts(selected)
+			var added []string
+			if installSync {
+				added = syncNewPackages(lockManifest)
+			}
+			downloadPackages(root, selected)
+			verifyHashes(selected)
+			if len(added) > 0 {
+				// The new packages now have revisions to lock.
+				newLockManifest = lockManifest
+			}
+			if endpoints := newHostKeys(); len(endpoints) > 0 {
+				if *pinHosts == PIN_HOSTS_RECORD || *pinHosts == PIN_HOSTS_ACCEPT {
+					warnf("recorded the keys of %s in %s", strings.Join(endpoints, ", "), manifest.LOCK_FILE)
+					if newLockManifest == nil {
+						// Only the keys change, not what was
+						// detected or inferred in this run.
+						newLockManifest = unchangedLockManifest()
+					}
+				} else {
+					warnf("the keys of %s aren't in %s; run with -pin_hosts=record to record them", strings.Join(endpoints, ", "), manifest.LOCK_FILE)
+				}
+			}
+			if installBinaries(lockManifest, nil) {
+				warnf("recorded the checksums of binaries for this platform in %s", manifest.LOCK_FILE)
+				newLockManifest = lockManifest
+			}
+			if lockManifest.HasRepository() {
+				createWorkspaceSymlinks(lockManifest)
 			}
 		}
 
 	case "update":
 		// Downloads packages from the package file and updates the lockfile.
-		manifest := NewManifestFromFile(PACKAGE_FILE)
+		startUpdatePR()
+		backupBeforeUpdate(root.Package.Source)
+		packageManifest := loadManifest(manifest.PACKAGE_FILE)
+		detectRepository(packageManifest)
+		applyUpdateScope(packageManifest)
 		if len(args) == 2 {
 			packageName := args[1]
-			packageInfo, ok := manifest.Packages[packageName]
+			packageInfo, ok := packageManifest.Packages[packageName]
 			if !ok {
 				panic(errors.New(fmt.Sprintf("Package not found: %s", packageName)))
 			}
-			downloadPackage(packageInfo)
+			node := downloadPackage(packageInfo)
 
 			// Replace a single package in the lockfile.
 			// This will create a new lockfile if one doesn't exist.
-			lockManifest := NewManifestFromFile(LOCK_FILE)
-			lockManifest.Packages[packageName] = packageInfo
-			lockManifest.writeToFile(LOCK_FILE)
+			newLockManifest = loadManifest(manifest.LOCK_FILE)
+			newLockManifest.Packages[packageName] = packageInfo
+			if updateWithDeps {
+				updateDependencies(node, newLockManifest, map[string]bool{})
+			}
 		} else {
-			downloadPackages(root, manifest)
-			if manifest.hasRepository() {
-				createWorkspaceSymlink(manifest.Repository)
+			preflightHosts(packageManifest)
+			downloadPackages(root, packageManifest)
+			if packageManifest.HasRepository() {
+				createWorkspaceSymlinks(packageManifest)
 			}
+			previous, _ := manifest.Load(manifest.LOCK_FILE)
+			installBinaries(packageManifest, previous)
 			// Replace the entire lockfile.
 			// This will create a new lockfile if one doesn't exist.
-			manifest.writeToFile(LOCK_FILE)
+			newLockManifest = packageManifest
+			recordResolutions = true
 		}
 	}
 
+	conflicts := findConflicts(root, resolutions)
+	writeConflictsOut(conflicts)
+	if *strict && len(conflicts) > 0 {
+		// Leave the lockfile untouched and fail with a report tools can parse.
+		if err := resolve.WriteConflictReport(os.Stdout, conflicts); err != nil {
+			panic(err)
+		}
+		panic(errors.New(fmt.Sprintf("%d conflicting package versions found (strict mode)", len(conflicts))))
+	}
+	// Leave the lockfile untouched if the packages break the policy.
+	enforcePolicy(root, conflicts)
+
+	if newLockManifest != nil {
+		if recordResolutions {
+			newLockManifest.Resolutions = resolve.ResolutionsFor(conflicts)
+		}
+		completeLockfile(newLockManifest)
+		if previous, err := manifest.Load(manifest.LOCK_FILE); err == nil {
+			printLockChanges(previous, newLockManifest)
+		}
+		writeManifest(newLockManifest, manifest.LOCK_FILE)
+		emitEvent(&Event{Type: EVENT_LOCKFILE_WRITTEN, Path: manifest.LOCK_FILE})
+	}
+
 	// Back up, and re-checkout all conflicted repos with the resolved versions.
-	resolved := ResolveConflicts(root)
+	for _, c := range conflicts {
+		c.Dump(os.Stdout)
+		emitEvent(&Event{Type: EVENT_CONFLICT, Source: c.Source, Conflict: c.Report()})
+	}
+	resolved := resolve.ResolveConflicts(conflicts)
 
 	if len(resolved) > 0 {
 		for _, packageInfo := range resolved {
-			fmt.Fprintf(os.Stdout, "resolving %s to %s\n", packageInfo.Name, packageInfo.getRef())
+			fmt.Fprintf(os.Stdout, "resolving %s to %s\n", colorize(os.Stdout, COLOR_BOLD, packageInfo.Name), packageInfo.GetRef())
 			git := GitRepositoryFromPackage(packageInfo)
-			git.update(packageInfo)
+			start := time.Now()
+			if downloadTarball(packageInfo, git.RepoPath) {
+				recordTiming(packageInfo.Name, PHASE_TARBALL, start)
+			} else if !git.IsCloned() {
+				// Packages without git metadata are installed again.
+				checkoutPackage(git, packageInfo)
+			} else {
+				if err := git.Update(packageInfo); err != nil {
+					panic(err)
+				}
+				recordTiming(packageInfo.Name, PHASE_CHECKOUT, start)
+			}
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", colorize(os.Stdout, COLOR_YELLOW, "Version conflicts were detected. If the build fails, you may want to see if that's a problem."))
+	}
+
+	if *noVcsMetadata {
+		packages := root.Packages()
+		if root.Package.Name != "" {
+			// A single package was installed.
+			packages = append(packages, root.Package)
+		}
+		removeVcsMetadata(packages)
+	}
+	finishJournal()
+	if args[0] == "update" {
+		finishUpdatePR()
+	}
+
+	if *profile {
+		printProfile(os.Stdout)
+	}
+
+	if *compileCheckPackages || *buildDepsAfterInstall {
+		packages := root.Packages()
+		if root.Parent == nil && root.Package.Name != "" {
+			// A single package was installed.
+			packages = append([]*manifest.Package{root.Package}, packages...)
+		}
+		if *buildDepsAfterInstall {
+			buildDeps(packages, runtime.NumCPU())
+		}
+		if *compileCheckPackages {
+			compileCheck(packages)
 		}
-		fmt.Fprintf(os.Stdout, "Version conflicts were detected. If the build fails, you may want to see if that's a problem.\n")
 	}
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Returns a line describing a locked binary, with every platform's checksum.
+func binaryDigestLine(name string, binary *manifest.Binary) string {
+	assets := []string{}
+	for asset, checksum := range binary.Checksums {
+		assets = append(assets, asset+"="+checksum)
+	}
+	sort.Strings(assets)
+	return fmt.Sprintf("binary %s %s %s %s %s", name, binary.Repository, binary.Tag, binary.Asset, strings.Join(assets, ","))
+}
+
+// Returns the lines describing the dependency set locked by the lockfile:
+// every package's source, revision and content hash, the conflict
+// resolutions, and the binaries. The dependencies of dependencies are
+// determined by their revisions, so they need not be listed.
+func lockedDigestLines(lockManifest *manifest.Manifest) []string {
+	lines := []string{}
+	for name, packageInfo := range lockManifest.Packages {
+		hash := packageInfo.Hash
+		if hash == "" {
+			hash = "-"
+		}
+		lines = append(lines, fmt.Sprintf("package %s %s %s %s", name, packageInfo.Source, packageInfo.GetRevision(), hash))
+	}
+	for source, resolution := range lockManifest.Resolutions {
+		lines = append(lines, fmt.Sprintf("resolution %s %s", source, resolution.Chosen.Ref))
+	}
+	for name, binary := range lockManifest.Binaries {
+		lines = append(lines, binaryDigestLine(name, binary))
+	}
+	return lines
+}
+
+// Returns the lines describing the dependency set installed in the
+// workspace: every package in the tree at the version conflict resolution
+// settles on, with the revision and content hash of what is checked out, and
+// the binaries.
+func installedDigestLines(root *resolve.Node, lockManifest *manifest.Manifest) []string {
+	loadPackages(root, lockManifest)
+	lines := []string{}
+	for _, packageInfo := range resolvedPackages(root, lockManifest.Resolutions) {
+		revision, ok := getInstalledRevision(GitRepositoryFromPackage(packageInfo))
+		if !ok {
+			panic(errors.New(fmt.Sprintf("%s is not installed; run deliver install first", packageInfo.Name)))
+		}
+		installed := *packageInfo
+		installed.Revision = revision
+		hash, err := packageHash(&installed)
+		if err != nil {
+			panic(errors.New(fmt.Sprintf("could not hash %s: %v", packageInfo.Name, err)))
+		}
+		lines = append(lines, fmt.Sprintf("package %s %s %s %s", packageInfo.Name, packageInfo.Source, revision, hash))
+	}
+	for name, stamp := range loadInstalledBinaries() {
+		if _, ok := lockManifest.Binaries[name]; ok {
+			lines = append(lines, fmt.Sprintf("binary %s %s", name, stamp))
+		}
+	}
+	return lines
+}
+
+// Prints a single hash of the dependency set, for use as a CI cache key or to
+// check that two machines have the same dependencies.
+func digestCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("digest", flag.ExitOnError)
+	installed := flags.Bool("installed", false, "hash what is installed in the workspace, including the dependencies of dependencies, instead of the lockfile")
+	list := flags.Bool("list", false, "print what is hashed, one line per package, instead of the hash")
+	flags.Parse(args)
+
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	var lines []string
+	if *installed {
+		lines = installedDigestLines(root, lockManifest)
+	} else {
+		lines = lockedDigestLines(lockManifest)
+	}
+	sort.Strings(lines)
+
+	if *list {
+		for _, line := range lines {
+			fmt.Fprintln(os.Stdout, line)
+		}
+		return
+	}
+	digest := sha256.New()
+	for _, line := range lines {
+		fmt.Fprintln(digest, line)
+	}
+	fmt.Fprintf(os.Stdout, "%x\n", digest.Sum(nil))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+	"github.com/brettshollenberger/deliver/workspace"
+)
+
+// Prints the outcome of each check and counts the failures.
+type doctorReport struct {
+	failures int
+}
+
+func (r *doctorReport) ok(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stdout, "[%s]   %s\n", colorize(os.Stdout, COLOR_GREEN, "ok"), fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) skip(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stdout, "[%s] %s\n", colorize(os.Stdout, COLOR_YELLOW, "skip"), fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) fail(fix string, format string, args ...interface{}) {
+	r.failures++
+	fmt.Fprintf(os.Stdout, "[%s] %s\n", colorize(os.Stdout, COLOR_RED, "FAIL"), fmt.Sprintf(format, args...))
+	fmt.Fprintf(os.Stdout, "       fix: %s\n", fix)
+}
+
+func (r *doctorReport) checkGit() {
+	if _, err := exec.LookPath("git"); err != nil {
+		r.fail("install git and make sure it is on your PATH", "git was not found")
+		return
+	}
+	out, err := vcs.ExecuteCommand("git", "--version")
+	if err != nil {
+		r.fail("check your git installation", "git --version failed: %v", err)
+		return
+	}
+	r.ok("%s", strings.TrimSpace(out))
+}
+
+func (r *doctorReport) checkEnvironment() {
+	if *useDeliverWorkspace {
+		if len(*rootWorkspaceDir) == 0 && os.Getenv("HOME") == "" {
+			r.fail("set HOME, or pass -root to choose where workspaces are created", "HOME is not set")
+		} else {
+			r.ok("workspaces are created under %s", workspace.WorkspacesDir(*rootWorkspaceDir))
+		}
+		return
+	}
+	if os.Getenv("GOPATH") == "" {
+		r.fail("export GOPATH=$HOME/go, or pass -deliver_workspace", "GOPATH is not set")
+		return
+	}
+	r.ok("GOPATH is %s", os.Getenv("GOPATH"))
+}
+
+// Checks that packages can be written into the workspace, by creating a file
+// in the closest directory of it that exists.
+func (r *doctorReport) checkWritable(workspacePath string) {
+	dir := workspacePath
+	for {
+		if _, err := os.Stat(dir); err == nil || dir == "/" || dir == "." {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+	f, err := ioutil.TempFile(dir, ".deliver-doctor-")
+	if err != nil {
+		r.fail("fix the permissions of "+dir+", or choose another location with -root", "cannot write to %s: %v", dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	r.ok("%s is writable", dir)
+}
+
+func (r *doctorReport) checkSymlinks(workspacePath string, m *manifest.Manifest) {
+	if m == nil || !m.HasRepository() {
+		r.skip("no Repository in the manifest, so no workspace symlink is needed")
+		return
+	}
+	for _, importPath := range m.LinkPaths() {
+		r.checkSymlink(workspacePath, importPath, getLinkTarget(m, importPath))
+	}
+}
+
+func (r *doctorReport) checkSymlink(workspacePath string, importPath string, currentDir string) {
+	linkPath := workspace.PackageDir(workspacePath, importPath)
+
+	info, err := os.Lstat(linkPath)
+	if os.IsNotExist(err) {
+		r.fail("run deliver install", "%s does not exist", linkPath)
+		return
+	} else if err != nil {
+		r.fail("check the permissions of "+path.Dir(linkPath), "cannot read %s: %v", linkPath, err)
+		return
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		if source, ok := workspace.CopySource(linkPath); ok {
+			if same, _ := workspace.PathCompare(source, currentDir); same {
+				r.ok("%s is a copy of the project", linkPath)
+			} else {
+				r.fail("move "+linkPath+" out of the way and run deliver install", "%s is a copy of %s instead of %s", linkPath, source, currentDir)
+			}
+		} else if same, _ := workspace.PathCompare(linkPath, currentDir); same {
+			r.ok("the project is already at %s", linkPath)
+		} else {
+			r.fail("move "+linkPath+" out of the way and run deliver install", "%s is not a symlink to the project", linkPath)
+		}
+		return
+	}
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		panic(err)
+	}
+	if same, err := workspace.PathCompare(linkPath, currentDir); err != nil || !same {
+		r.fail("run deliver install to recreate it", "%s points to %s instead of %s", linkPath, target, currentDir)
+		return
+	}
+	r.ok("%s -> %s", linkPath, target)
+}
+
+// Checks that every SSH host used by the manifest accepts our credentials.
+func (r *doctorReport) checkHosts(m *manifest.Manifest) {
+	if m == nil {
+		r.skip("no manifest, so no hosts to check")
+		return
+	}
+
+	hosts := make(map[string]string)
+	for _, packageInfo := range m.Packages {
+		location, ok := vcs.ParseSource(packageInfo.Source)
+		if !ok {
+			continue
+		}
+		if location.Scheme == "ssh" {
+			hosts[location.Host] = packageInfo.Source
+		} else {
+			r.skip("%s uses %s; credentials come from git's credential helpers", packageInfo.Name, location.Scheme)
+		}
+	}
+	if len(hosts) == 0 {
+		return
+	}
+
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		r.fail("start an agent with eval $(ssh-agent) and add your key with ssh-add", "no SSH agent is running (SSH_AUTH_SOCK is not set)")
+	} else if _, err := vcs.ExecuteCommand("ssh-add", "-l"); err != nil {
+		r.fail("add your key with ssh-add", "the SSH agent has no keys")
+	} else {
+		r.ok("the SSH agent has keys")
+	}
+
+	if vcs.NetworkDenied {
+		r.skip("not contacting hosts because network access is denied")
+		return
+	}
+
+	names := []string{}
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	for _, host := range names {
+		// Hosts such as GitHub exit with 1 after authenticating, since they
+		// don't provide a shell. 255 means ssh itself failed.
+		_, err := vcs.ExecuteCommand("ssh", "-T", "-o", "BatchMode=yes", "-o", "ConnectTimeout=10", "git@"+host)
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 255 {
+			r.fail("add your public key to "+host+", and check that it is in ~/.ssh/known_hosts", "cannot authenticate with %s over SSH", host)
+		} else if err != nil && !ok {
+			r.fail("install ssh", "cannot run ssh: %v", err)
+		} else {
+			r.ok("authenticated with %s", host)
+		}
+	}
+}
+
+// Checks the environment deliver runs in and suggests fixes for problems.
+func doctorCommand() {
+	var m *manifest.Manifest
+	for _, file := range []string{manifest.PACKAGE_FILE, manifest.LOCK_FILE} {
+		if _, err := os.Stat(file); err == nil {
+			m = loadManifest(file)
+			detectRepository(m)
+			break
+		}
+	}
+
+	report := &doctorReport{}
+	report.checkGit()
+	report.checkEnvironment()
+	workspacePath := getWorkspacePath()
+	report.checkWritable(workspacePath)
+	report.checkSymlinks(workspacePath, m)
+	report.checkHosts(m)
+
+	if report.failures > 0 {
+		fmt.Fprintf(os.Stdout, "\n%d problems found.\n", report.failures)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "\nNo problems found.\n")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const ENVRC_FILE string = ".envrc"
+
+// The lines around the part of .envrc that deliver envrc manages.
+const (
+	ENVRC_BEGIN = "# >>> deliver >>>"
+	ENVRC_END   = "# <<< deliver <<<"
+)
+
+// Quotes s for the shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Returns the managed section of .envrc: the workspace's GOPATH, and its bin
+// directory on PATH.
+func envrcSection() string {
+	lines := []string{
+		ENVRC_BEGIN,
+		"# Written by deliver envrc. Changes between these lines are overwritten.",
+		"export GOPATH=" + shellQuote(getBuildGOPATH()),
+	}
+	if _, err := os.Stat("go.mod"); os.IsNotExist(err) {
+		// Build in GOPATH mode, as deliver installed the packages.
+		lines = append(lines, "export GO111MODULE=off")
+	}
+	lines = append(lines, "PATH_add "+shellQuote(filepath.Join(getWorkspacePath(), "bin")), ENVRC_END)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Returns contents with its managed section replaced by section, or with
+// section added at the end if it has none.
+func replaceEnvrcSection(contents, section string) (string, error) {
+	begin := strings.Index(contents, ENVRC_BEGIN)
+	if begin < 0 {
+		if contents != "" && !strings.HasSuffix(contents, "\n") {
+			contents += "\n"
+		}
+		if contents != "" {
+			contents += "\n"
+		}
+		return contents + section, nil
+	}
+	end := strings.Index(contents[begin:], ENVRC_END)
+	if end < 0 {
+		return "", errors.New(fmt.Sprintf("%s has a %q line without a matching %q line", ENVRC_FILE, ENVRC_BEGIN, ENVRC_END))
+	}
+	end += begin + len(ENVRC_END)
+	if end < len(contents) && contents[end] == '\n' {
+		end++
+	}
+	return contents[:begin] + section + contents[end:], nil
+}
+
+// Writes the workspace's environment to a section of .envrc, so direnv sets
+// it up whenever the project is entered.
+func envrcCommand() {
+	data, err := ioutil.ReadFile(ENVRC_FILE)
+	if err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	contents, err := replaceEnvrcSection(string(data), envrcSection())
+	if err != nil {
+		panic(err)
+	}
+	if *noRun {
+		fmt.Fprint(os.Stdout, contents)
+		return
+	}
+	if contents == string(data) {
+		fmt.Fprintf(os.Stdout, "%s is up to date\n", ENVRC_FILE)
+		return
+	}
+	if err := ioutil.WriteFile(ENVRC_FILE, []byte(contents), 0644); err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stdout, "wrote %s; run direnv allow to use it\n", ENVRC_FILE)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Types of events written to the -events stream.
+const (
+	EVENT_PACKAGE_START    = "package_start"
+	EVENT_PACKAGE_FINISH   = "package_finish"
+	EVENT_PACKAGE_FAIL     = "package_fail"
+	EVENT_CONFLICT         = "conflict"
+	EVENT_LOCKFILE_WRITTEN = "lockfile_written"
+	EVENT_ERROR            = "error"
+)
+
+// A single line of the event stream. Only the fields relevant to the event's
+// type are set.
+type Event struct {
+	Time     time.Time
+	Type     string
+	Package  string                  `json:",omitempty"`
+	Source   string                  `json:",omitempty"`
+	Ref      string                  `json:",omitempty"`
+	Path     string                  `json:",omitempty"`
+	Error    string                  `json:",omitempty"`
+	Conflict *resolve.ConflictReport `json:",omitempty"`
+}
+
+// Where events are written, or nil if -events isn't set.
+var eventStream *json.Encoder
+var eventMutex sync.Mutex
+
+// Opens the event stream named by -events: fd://N for an inherited file
+// descriptor, or a file path.
+func openEventStream(target string) {
+	if target == "" {
+		return
+	}
+	var f *os.File
+	if strings.HasPrefix(target, "fd://") {
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd://"))
+		if err != nil {
+			panic(errors.New(fmt.Sprintf("invalid -events value %q: expected fd://N or a file path", target)))
+		}
+		f = os.NewFile(uintptr(fd), target)
+		if _, err := f.Stat(); err != nil {
+			panic(errors.New(fmt.Sprintf("cannot write events to %s: %v", target, err)))
+		}
+	} else {
+		var err error
+		if f, err = os.Create(target); err != nil {
+			panic(err)
+		}
+	}
+	eventStream = json.NewEncoder(f)
+}
+
+// Writes an event as a line of JSON, if the event stream is open.
+func emitEvent(event *Event) {
+	if eventStream == nil {
+		return
+	}
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	event.Time = time.Now()
+	if err := eventStream.Encode(event); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write event: %v\n", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Describes where a node's request came from: the project's lockfile, or the
+// lockfile of the package that requires it, with the packages that led there.
+func requestOrigin(root *resolve.Node, node *resolve.Node) string {
+	if node.Parent == root {
+		return manifest.LOCK_FILE
+	}
+	via := []string{}
+	for parent := node.Parent.Parent; parent != nil && parent != root; parent = parent.Parent {
+		via = append(via, parent.Package.Name)
+	}
+	origin := fmt.Sprintf("%s of %s at %s", manifest.LOCK_FILE, node.Parent.Package.Name, node.Parent.Package.GetRevision())
+	if len(via) > 0 {
+		origin += fmt.Sprintf(" (via %s)", strings.Join(via, ", "))
+	}
+	return origin
+}
+
+// Prints why a package is locked at its revision: every request for it, the
+// constraints on it from the package file and the policy, and how conflict
+// resolution chose between the requests.
+func explainCommand(root *resolve.Node, args []string) {
+	if len(args) != 1 {
+		panic(errors.New("usage: deliver explain <package>"))
+	}
+	name := args[0]
+
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+	nodes := root.FindAll(name)
+	if len(nodes) == 0 {
+		panic(errors.New(fmt.Sprintf("Package %s not found in %s or its dependencies", name, manifest.LOCK_FILE)))
+	}
+
+	var conflict *resolve.Conflicts
+	conflicts := findConflicts(root, lockManifest.Resolutions)
+	for _, c := range conflicts {
+		if c.Covers(nodes[0].Package) {
+			conflict = c
+		}
+	}
+	chosen := nodes[0]
+	if conflict != nil {
+		chosen = conflict.Chosen
+	}
+
+	fmt.Fprintf(os.Stdout, "%s is locked at %s (branch %s)\n", colorize(os.Stdout, COLOR_BOLD, name), chosen.Package.GetRevision(), chosen.Package.GetBranch())
+
+	fmt.Fprintf(os.Stdout, "\nrequested by:\n")
+	for _, node := range nodes {
+		marker := "   "
+		if node == chosen {
+			marker = "(*)"
+		}
+		fmt.Fprintf(os.Stdout, "  %s %s: %s\n", marker, requestOrigin(root, node), node.Package.GetRef())
+	}
+
+	fmt.Fprintf(os.Stdout, "\nconstraints:\n")
+	if packageFile, err := loadPackageFile(); err != nil {
+		fmt.Fprintf(os.Stdout, "  %s could not be read: %v\n", manifest.PACKAGE_FILE, err)
+	} else if packageInfo, ok := packageFile.Packages[name]; !ok {
+		fmt.Fprintf(os.Stdout, "  not in %s; the revision comes from the lockfile of the package that requires it\n", manifest.PACKAGE_FILE)
+	} else if packageInfo.HasRevision() {
+		fmt.Fprintf(os.Stdout, "  pinned to %s in %s\n", packageInfo.Revision, manifest.PACKAGE_FILE)
+	} else {
+		fmt.Fprintf(os.Stdout, "  follows branch %s in %s; update moves it to the branch's tip\n", packageInfo.GetBranch(), manifest.PACKAGE_FILE)
+	}
+	if p := loadPolicy(); p != nil {
+		if reason, ok := p.banReason(name); ok {
+			fmt.Fprintf(os.Stdout, "  banned by %s: %s\n", POLICY_FILE, reason)
+		}
+		if minimum, ok := p.MinimumRevisions[name]; ok {
+			fmt.Fprintf(os.Stdout, "  must be at or descend from %s, by %s\n", minimum, POLICY_FILE)
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "\nconflict resolution:\n")
+	if conflict == nil {
+		fmt.Fprintf(os.Stdout, "  no conflict: every request is for %s\n", chosen.Package.GetRef())
+		return
+	}
+	if resolution, ok := lockManifest.Resolutions[conflict.Key()]; ok && resolution.Chosen != nil && resolution.Chosen.Ref == chosen.Package.GetRef() {
+		fmt.Fprintf(os.Stdout, "  %s was chosen by the resolution recorded in %s\n", chosen.Package.GetRef(), manifest.LOCK_FILE)
+		if conflict.Reason != "" {
+			fmt.Fprintf(os.Stdout, "  when it was recorded, %s\n", conflict.Reason)
+		}
+	} else if conflict.Reason != "" {
+		fmt.Fprintf(os.Stdout, "  %s was chosen because %s\n", chosen.Package.GetRef(), conflict.Reason)
+	} else {
+		fmt.Fprintf(os.Stdout, "  %s was chosen as the request nearest the project\n", chosen.Package.GetRef())
+	}
+	for _, ref := range conflict.Refs() {
+		if ref == chosen.Package.GetRef() {
+			continue
+		}
+		for _, node := range conflict.Changesets[ref] {
+			fmt.Fprintf(os.Stdout, "  overrode %s, requested by %s\n", ref, requestOrigin(root, node))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+var bazelNameInvalidChars = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// Returns the repository name Gazelle gives an import path: the host's
+// components reversed, then the rest of the path, with everything but letters
+// and digits replaced by underscores. github.com/foo/bar is
+// com_github_foo_bar.
+func bazelRepositoryName(importPath string) string {
+	parts := strings.SplitN(importPath, "/", 2)
+	host := strings.Split(parts[0], ".")
+	for i, j := 0, len(host)-1; i < j; i, j = i+1, j-1 {
+		host[i], host[j] = host[j], host[i]
+	}
+	name := strings.Join(host, ".")
+	if len(parts) == 2 {
+		name += "/" + parts[1]
+	}
+	return strings.ToLower(bazelNameInvalidChars.ReplaceAllString(name, "_"))
+}
+
+// Prints a Starlark macro of go_repository rules for every locked package,
+// including the dependencies of dependencies that are installed, at the
+// version conflict resolution settles on.
+func exportBazel(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("export bazel", flag.ExitOnError)
+	macro := flags.String("macro", "deliver_dependencies", "name of the macro that declares the rules, to call from WORKSPACE")
+	flags.Parse(args)
+
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+	packages := resolvedPackages(root, lockManifest.Resolutions)
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+
+	fmt.Fprintf(os.Stdout, "# Generated by deliver export bazel from %s. Do not edit.\n", manifest.LOCK_FILE)
+	fmt.Fprintf(os.Stdout, "load(\"@bazel_gazelle//:deps.bzl\", \"go_repository\")\n\n")
+	fmt.Fprintf(os.Stdout, "def %s():\n", *macro)
+	rules := 0
+	for _, packageInfo := range packages {
+		if !packageInfo.HasRevision() {
+			warnf("%s is not locked to a revision, skipping it", packageInfo.Name)
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "    go_repository(\n")
+		fmt.Fprintf(os.Stdout, "        name = %q,\n", bazelRepositoryName(packageInfo.Name))
+		fmt.Fprintf(os.Stdout, "        importpath = %q,\n", packageInfo.Name)
+		fmt.Fprintf(os.Stdout, "        commit = %q,\n", packageInfo.Revision)
+		if packageInfo.Source != "" {
+			fmt.Fprintf(os.Stdout, "        remote = %q,\n", packageInfo.Source)
+			fmt.Fprintf(os.Stdout, "        vcs = \"git\",\n")
+		}
+		fmt.Fprintf(os.Stdout, "    )\n")
+		rules++
+	}
+	if rules == 0 {
+		fmt.Fprintf(os.Stdout, "    pass\n")
+	}
+}
+
+// Prints the locked dependencies in another build system's format.
+func exportCommand(root *resolve.Node, args []string) {
+	if len(args) == 0 || args[0] != "bazel" {
+		panic(errors.New("Usage: deliver export bazel [-macro name]"))
+	}
+	exportBazel(root, args[1:])
+}
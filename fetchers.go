@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Registers the fetchers packages can be installed with, most specific first.
+// Git comes last, since it supports every source.
+func registerFetchers() {
+	vcs.RegisterFetcher(&archiveFetcher{})
+	vcs.RegisterFetcher(&tarballFetcher{})
+	vcs.RegisterFetcher(&gitFetcher{})
+}
+
+// Installs packages with an Archive URL from versioned archives in a generic
+// repository, such as Artifactory or Nexus, or in a cloud bucket. Packages that aren't locked to a
+// revision yet are left to git.
+type archiveFetcher struct{}
+
+func (f *archiveFetcher) Name() string {
+	return "archive"
+}
+
+func (f *archiveFetcher) Supports(packageInfo *manifest.Package, dest string) bool {
+	// The hashes of archives cover the files as installed, so packages whose
+	// files are changed are left to git.
+	if packageInfo.Archive == "" || !packageInfo.HasRevision() || modifiesCheckout(packageInfo) {
+		return false
+	}
+	// Git checkouts stay git checkouts.
+	_, err := os.Stat(path.Join(dest, ".git"))
+	return err != nil
+}
+
+func (f *archiveFetcher) Fetch(packageInfo *manifest.Package, dest string) error {
+	start := time.Now()
+	if err := vcs.DownloadArchive(packageInfo, dest); err != nil {
+		return err
+	}
+	recordTiming(packageInfo.Name, PHASE_TARBALL, start)
+	return nil
+}
+
+// Installs packages locked to a full revision from their host's source
+// archives, with -tarballs.
+type tarballFetcher struct{}
+
+func (f *tarballFetcher) Name() string {
+	return "source archive"
+}
+
+func (f *tarballFetcher) Supports(packageInfo *manifest.Package, dest string) bool {
+	if !*useTarballs || !vcs.CanDownloadTarball(packageInfo) || modifiesCheckout(packageInfo) {
+		return false
+	}
+	// Git checkouts stay git checkouts.
+	_, err := os.Stat(path.Join(dest, ".git"))
+	return err != nil
+}
+
+func (f *tarballFetcher) Fetch(packageInfo *manifest.Package, dest string) error {
+	start := time.Now()
+	ok, err := vcs.DownloadTarball(packageInfo, dest)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(fmt.Sprintf("%s can't be downloaded as a source archive", packageInfo.Name))
+	}
+	recordTiming(packageInfo.Name, PHASE_TARBALL, start)
+	return nil
+}
+
+// Clones or fetches git repositories, and checks out the package's revision.
+type gitFetcher struct{}
+
+func (f *gitFetcher) Name() string {
+	return "git"
+}
+
+func (f *gitFetcher) Supports(packageInfo *manifest.Package, dest string) bool {
+	return true
+}
+
+func (f *gitFetcher) Fetch(packageInfo *manifest.Package, dest string) error {
+	git := GitRepositoryFromPackage(packageInfo)
+	git.RepoPath = dest
+
+	if git.IsBroken() {
+		warnf("%s has no commit checked out, probably because a clone was interrupted; cloning it again", git.RepoPath)
+		if err := os.RemoveAll(git.RepoPath); err != nil {
+			return err
+		}
+	}
+
+	// The package's source may have changed since it was cloned.
+	if git.IsCloned() {
+		if origin, err := git.OriginURL(); err == nil && origin != "" && origin != git.RepoUrl {
+			fmt.Fprintf(os.Stdout, "%s moved from %s to %s\n", packageInfo.Name, origin, git.RepoUrl)
+			if git.Worktree {
+				// The worktree belongs to the cache of the old source, and
+				// holds no history of its own.
+				if err := os.RemoveAll(git.RepoPath); err != nil {
+					return err
+				}
+			} else if err := git.SetOriginURL(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Packages installed from a tarball have no history to fetch into. With
+	// -no_vcs_metadata, they are only replaced to move to another revision.
+	if revision, ok := vcs.TarballRevision(git.RepoPath); ok {
+		if *noVcsMetadata && packageInfo.HasRevision() && revision == packageInfo.Revision {
+			return nil
+		}
+		if err := os.RemoveAll(git.RepoPath); err != nil {
+			return err
+		}
+	}
+
+	// If package directory does not exist, create the directory.
+	if _, err := os.Stat(git.RepoPath); os.IsNotExist(err) {
+		if _, err := vcs.ExecuteCommand("mkdir", "-p", git.RepoPath); err != nil {
+			return err
+		}
+	}
+
+	// Check if repository already exists in package directory.
+	start := time.Now()
+	if !git.IsCloned() {
+		// Git repo does not exist. Clone it.
+		if err := git.Clone(git.RepoPath, packageInfo.GetBranch()); err != nil {
+			return err
+		}
+		recordTiming(packageInfo.Name, PHASE_CLONE, start)
+	} else {
+		// Git repo exists. Fetch the locked revision, or the latest of
+		// everything.
+		fetch := git.Fetch
+		if packageInfo.HasRevision() {
+			fetch = func() error { return git.FetchRevision(packageInfo.Revision) }
+		}
+		if err := fetch(); err != nil {
+			return err
+		}
+		recordTiming(packageInfo.Name, PHASE_FETCH, start)
+	}
+
+	start = time.Now()
+	if err := git.Update(packageInfo); err != nil {
+		return err
+	}
+	recordTiming(packageInfo.Name, PHASE_CHECKOUT, start)
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Returns the manifest in its canonical form: sources normalized, and
+// branches, revisions and sources that are the same as their defaults left
+// out.
+func canonicalManifest(m *manifest.Manifest) *manifest.Manifest {
+	for name, packageInfo := range m.Packages {
+		packageInfo.Source = vcs.NormalizeSource(packageInfo.Source)
+		if inferred, ok := vcs.InferSource(name); ok && inferred == packageInfo.Source {
+			packageInfo.Source = ""
+		}
+		if packageInfo.Branch == "master" {
+			packageInfo.Branch = ""
+		}
+		if packageInfo.Revision == "HEAD" {
+			packageInfo.Revision = ""
+		}
+	}
+	return m
+}
+
+// Rewrites the package file in canonical form. With -check, only reports
+// whether it already is, for CI.
+func fmtCommand(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "exit with an error if the file isn't formatted, instead of rewriting it")
+	flags.Parse(args)
+	file := manifest.PACKAGE_FILE
+	if flags.NArg() > 0 {
+		file = flags.Arg(0)
+	}
+
+	original, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	m, err := manifest.Load(file)
+	if err != nil {
+		panic(err)
+	}
+	formatted, err := canonicalManifest(m).Format()
+	if err != nil {
+		panic(err)
+	}
+
+	if bytes.Equal(original, formatted) {
+		return
+	}
+	if *check {
+		fmt.Fprintf(os.Stderr, "%s is not formatted. Run deliver fmt to fix it.\n", file)
+		os.Exit(1)
+	}
+	if !bytes.Equal(original, manifest.StripComments(original)) {
+		warnf("comments in %s are not kept", file)
+	}
+	if err := ioutil.WriteFile(file, formatted, 0644); err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stdout, "formatted %s\n", file)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Loads the dependency tree and returns the packages that are installed in
+// the workspace, at their resolved versions.
+func installedPackages(root *resolve.Node) []*manifest.Package {
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+
+	var packages []*manifest.Package
+	for _, packageInfo := range resolvedPackages(root, lockManifest.Resolutions) {
+		if _, ok := getInstalledRevision(GitRepositoryFromPackage(packageInfo)); !ok {
+			warnf("%s is not installed, skipping", packageInfo.Name)
+			continue
+		}
+		packages = append(packages, packageInfo)
+	}
+	return packages
+}
+
+// Runs a command in the directory of every installed package. The package's
+// name, source and revision are passed in the environment.
+func foreachCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("foreach", flag.ExitOnError)
+	parallel := flags.Int("parallel", 1, "number of packages to run the command in at once")
+	flags.Parse(args)
+	command := flags.Args()
+	if len(command) == 0 {
+		panic(errors.New("Usage: deliver foreach [-parallel n] -- <command> [arguments]"))
+	}
+	if *parallel < 1 {
+		*parallel = 1
+	}
+
+	var outputMutex, failedMutex sync.Mutex
+	var failed []string
+	var wait sync.WaitGroup
+	slots := make(chan bool, *parallel)
+	for _, packageInfo := range installedPackages(root) {
+		git := GitRepositoryFromPackage(packageInfo)
+
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Dir = git.RepoPath
+		cmd.Env = append(os.Environ(),
+			"DELIVER_PACKAGE="+packageInfo.Name,
+			"DELIVER_SOURCE="+packageInfo.Source,
+			"DELIVER_REVISION="+packageInfo.Revision,
+			"DELIVER_PATH="+git.RepoPath)
+
+		// Output is prefixed with the package name when runs overlap.
+		stdout := vcs.NewPrefixWriter(os.Stdout, "["+packageInfo.Name+"] ", &outputMutex)
+		stderr := vcs.NewPrefixWriter(os.Stderr, "["+packageInfo.Name+"] ", &outputMutex)
+		if *parallel > 1 {
+			cmd.Stdout, cmd.Stderr = stdout, stderr
+		} else {
+			cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		}
+
+		slots <- true
+		wait.Add(1)
+		go func(name string) {
+			defer wait.Done()
+			if *parallel == 1 {
+				fmt.Fprintf(os.Stdout, "Entering %s\n", cmd.Dir)
+			}
+			err := cmd.Run()
+			stdout.Flush()
+			stderr.Flush()
+			<-slots
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+				failedMutex.Lock()
+				failed = append(failed, name)
+				failedMutex.Unlock()
+			}
+		}(packageInfo.Name)
+	}
+	wait.Wait()
+
+	if len(failed) > 0 {
+		panic(errors.New(fmt.Sprintf("command failed in %d packages: %v", len(failed), failed)))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brettshollenberger/deliver/workspace"
+)
+
+// Removes the workspaces of projects that were moved or deleted, and
+// optionally those that haven't been used for a while. With -n, only lists
+// them.
+func gcCommand(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	idle := flags.String("idle", "", "also remove workspaces no package was installed into for this long (e.g. 90d, 6m)")
+	flags.Parse(args)
+
+	now := time.Now()
+	var cutoff time.Time
+	if *idle != "" {
+		var err error
+		if cutoff, err = parseAge(*idle, now); err != nil {
+			panic(err)
+		}
+	}
+
+	workspaces, err := workspace.List(*rootWorkspaceDir)
+	if err != nil {
+		panic(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	removed := 0
+	for _, ws := range workspaces {
+		var reason string
+		if !ws.ProjectExists() {
+			reason = "project is gone"
+		} else if *idle != "" && ws.LastUsed.Before(cutoff) {
+			reason = fmt.Sprintf("unused for %s", formatAge(ws.LastUsed, now))
+		} else {
+			continue
+		}
+
+		removed++
+		if *noRun {
+			fmt.Fprintf(w, "would remove\t%s\t(%s)\n", ws.Path, reason)
+			continue
+		}
+		fmt.Fprintf(w, "removing\t%s\t(%s)\n", ws.Path, reason)
+		if err := ws.Remove(*rootWorkspaceDir); err != nil {
+			panic(err)
+		}
+	}
+	w.Flush()
+
+	if *noRun {
+		fmt.Fprintf(os.Stdout, "%d of %d workspaces would be removed.\n", removed, len(workspaces))
+	} else {
+		fmt.Fprintf(os.Stdout, "Removed %d of %d workspaces.\n", removed, len(workspaces))
+	}
+}
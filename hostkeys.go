@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+const (
+	PIN_HOSTS_OFF    = "off"
+	PIN_HOSTS_WARN   = "warn"
+	PIN_HOSTS_RECORD = "record"
+	PIN_HOSTS_FAIL   = "fail"
+	PIN_HOSTS_ACCEPT = "accept"
+)
+
+// The host keys recorded in the lockfile, by endpoint. Loaded on first use.
+var pinnedHostKeys map[string]string
+
+func loadPinnedHostKeys() {
+	if pinnedHostKeys != nil {
+		return
+	}
+	pinnedHostKeys = map[string]string{}
+	if locked, err := manifest.Load(manifest.LOCK_FILE); err == nil && locked.HostKeys != nil {
+		pinnedHostKeys = locked.HostKeys
+	}
+}
+
+// The keys the hosts fetched from in this run presented, by endpoint.
+var seenHostKeys = map[string]string{}
+
+// Endpoints whose keys didn't match the lockfile's and were accepted with
+// -pin_hosts=accept.
+var acceptedHostKeys = map[string]bool{}
+
+// Checks the key of the host a package is about to be fetched from against
+// the one recorded in the lockfile the first time it was fetched from, so a
+// host that is being impersonated is noticed even without strict known_hosts
+// management. The key is read over a connection of its own rather than the
+// one git fetches over, so it only shows what the host presented to deliver;
+// git's connection is still checked by known_hosts and certificate
+// validation alone. Each host is checked once per run. A changed key is reported,
+// fails the command with -pin_hosts=fail, and replaces the recorded one with
+// -pin_hosts=accept.
+func checkHostKey(packageInfo *manifest.Package) {
+	if *pinHosts == PIN_HOSTS_OFF || vcs.NetworkDenied {
+		return
+	}
+	endpoint, ok := vcs.HostEndpoint(vcs.PreferredSource(packageInfo.Source))
+	if !ok {
+		return
+	}
+	if _, ok := seenHostKeys[endpoint]; ok {
+		return
+	}
+	loadPinnedHostKeys()
+
+	key, err := vcs.HostFingerprint(endpoint)
+	if err != nil {
+		// Fetching will fail with a better message if the host is down.
+		warnf("could not read the key of %s: %v", endpoint, err)
+		seenHostKeys[endpoint] = ""
+		return
+	}
+	seenHostKeys[endpoint] = key
+	pinned, ok := pinnedHostKeys[endpoint]
+	if !ok || pinned == key {
+		return
+	}
+	message := fmt.Sprintf("the key of %s has changed since it was recorded in %s: it was %s and is now %s. Someone may be impersonating the host, or its key was replaced.",
+		endpoint, manifest.LOCK_FILE, pinned, key)
+	switch *pinHosts {
+	case PIN_HOSTS_FAIL:
+		panic(errors.New(message))
+	case PIN_HOSTS_ACCEPT:
+		warnf("%s Recording the new key.", message)
+		acceptedHostKeys[endpoint] = true
+	default:
+		warnf("%s If the new key is right, run again with -pin_hosts=accept to record it.", message)
+	}
+}
+
+// Returns the lockfile as it is on disk, for install to record host keys in
+// without the repository and sources it detected along the way.
+func unchangedLockManifest() *manifest.Manifest {
+	lockManifest, err := manifest.Load(manifest.LOCK_FILE)
+	if err != nil {
+		panic(err)
+	}
+	return lockManifest
+}
+
+// Returns the endpoints seen in this run whose keys aren't in the lockfile
+// yet, or were accepted, sorted.
+func newHostKeys() []string {
+	endpoints := []string{}
+	for endpoint, key := range seenHostKeys {
+		if _, ok := pinnedHostKeys[endpoint]; key != "" && (!ok || acceptedHostKeys[endpoint]) {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+// Sets the lockfile's host keys to the recorded ones, plus those of the hosts
+// first fetched from in this run and those accepted with -pin_hosts=accept.
+// Hosts no package is fetched from any more keep their keys, in case they
+// come back.
+func recordHostKeys(lockManifest *manifest.Manifest) {
+	loadPinnedHostKeys()
+	keys := map[string]string{}
+	for endpoint, key := range pinnedHostKeys {
+		keys[endpoint] = key
+	}
+	for _, endpoint := range newHostKeys() {
+		keys[endpoint] = seenHostKeys[endpoint]
+	}
+	if len(keys) > 0 {
+		lockManifest.HostKeys = keys
+	}
+}
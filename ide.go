@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+const (
+	VSCODE_SETTINGS_FILE = ".vscode/settings.json"
+	VSCODE_LAUNCH_FILE   = ".vscode/launch.json"
+)
+
+// Name of the debug configuration deliver ide setup adds to launch.json.
+const LAUNCH_CONFIGURATION_NAME string = "Launch package (deliver workspace)"
+
+// Returns the environment the go tool and gopls need to find the packages
+// in the workspace.
+func ideEnv() map[string]interface{} {
+	env := map[string]interface{}{"GOPATH": getBuildGOPATH()}
+	if _, err := os.Stat("go.mod"); os.IsNotExist(err) {
+		// Build in GOPATH mode, as deliver installed the packages.
+		env["GO111MODULE"] = "off"
+	}
+	return env
+}
+
+// Reads a JSON object from a VS Code config file, which may have comments.
+// A missing file is an empty object.
+func readJSONObject(file string) map[string]interface{} {
+	object := map[string]interface{}{}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return object
+	} else if err != nil {
+		panic(err)
+	}
+	if err := json.Unmarshal(manifest.StripComments(data), &object); err != nil {
+		panic(errors.New(fmt.Sprintf("%s: %v", file, err)))
+	}
+	return object
+}
+
+// Writes object to file, unless it is unchanged. Returns whether it wrote it.
+func writeJSONObject(file string, object map[string]interface{}) bool {
+	data, err := json.MarshalIndent(object, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	data = append(data, '\n')
+	if previous, err := ioutil.ReadFile(file); err == nil {
+		if string(previous) == string(data) {
+			return false
+		}
+		if string(manifest.StripComments(previous)) != string(previous) {
+			warnf("%s had comments, which are not kept", file)
+		}
+	}
+	if *noRun {
+		fmt.Fprintf(os.Stdout, "%s:\n%s", file, data)
+		return true
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		panic(err)
+	}
+	return true
+}
+
+// Points gopls, the Go extension's tools and its debugger at the workspace
+// in VS Code's settings, leaving the other settings alone.
+func ideSetupCommand() {
+	env := ideEnv()
+
+	settings := readJSONObject(VSCODE_SETTINGS_FILE)
+	settings["go.gopath"] = env["GOPATH"]
+	settings["go.toolsEnvVars"] = env
+	gopls, _ := settings["gopls"].(map[string]interface{})
+	if gopls == nil {
+		gopls = map[string]interface{}{}
+	}
+	gopls["build.env"] = env
+	settings["gopls"] = gopls
+	if writeJSONObject(VSCODE_SETTINGS_FILE, settings) {
+		fmt.Fprintf(os.Stdout, "wrote %s\n", VSCODE_SETTINGS_FILE)
+	}
+
+	launch := readJSONObject(VSCODE_LAUNCH_FILE)
+	if _, ok := launch["version"]; !ok {
+		launch["version"] = "0.2.0"
+	}
+	configurations, _ := launch["configurations"].([]interface{})
+	configuration := map[string]interface{}{
+		"name":    LAUNCH_CONFIGURATION_NAME,
+		"type":    "go",
+		"request": "launch",
+		"mode":    "auto",
+		"program": "${fileDirname}",
+		"env":     env,
+	}
+	replaced := false
+	for i, c := range configurations {
+		if existing, ok := c.(map[string]interface{}); ok && existing["name"] == LAUNCH_CONFIGURATION_NAME {
+			configurations[i] = configuration
+			replaced = true
+		}
+	}
+	if !replaced {
+		configurations = append(configurations, configuration)
+	}
+	launch["configurations"] = configurations
+	if writeJSONObject(VSCODE_LAUNCH_FILE, launch) {
+		fmt.Fprintf(os.Stdout, "wrote %s\n", VSCODE_LAUNCH_FILE)
+	}
+
+	// Other editors start gopls themselves.
+	data, err := json.Marshal(map[string]interface{}{"build.env": env})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stdout, "for other editors, add these gopls settings: %s\n", data)
+}
+
+// Sets up editors to use the workspace.
+func ideCommand(args []string) {
+	if len(args) != 1 || args[0] != "setup" {
+		panic(errors.New("Usage: deliver ide setup"))
+	}
+	ideSetupCommand()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Lists files to leave out of every dependency's checkout, one .gitignore
+// pattern per line.
+const IGNORE_FILE string = ".deliverignore"
+
+// The patterns in the project's IGNORE_FILE.
+var ignorePatterns []string
+
+// Returns the patterns in an ignore file, without blank lines and comments.
+// A missing file has none.
+func loadIgnoreFile(file string) []string {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	patterns := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+	return patterns
+}
+
+// Returns whether the package's checkout differs from the files of its
+// revision, because files are excluded or imports rewritten. Such packages are
+// only installed with git, whose hashes don't depend on the checkout.
+func modifiesCheckout(packageInfo *manifest.Package) bool {
+	return len(excludePatterns(packageInfo)) > 0 || packageInfo.ForkImportPath != ""
+}
+
+// Returns the patterns of files to leave out of the package's checkout: the
+// project's IGNORE_FILE followed by the package's own Exclude, so the latter
+// can bring back files with !.
+func excludePatterns(packageInfo *manifest.Package) []string {
+	patterns := append([]string{}, ignorePatterns...)
+	return append(patterns, packageInfo.Exclude...)
+}
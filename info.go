@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Prints everything deliver knows about a single package.
+func infoCommand(root *resolve.Node, args []string) {
+	if len(args) != 1 {
+		panic(errors.New("usage: deliver info <package>"))
+	}
+
+	packageInfo, _ := lookupPackage(root, args[0])
+	git := GitRepositoryFromPackage(packageInfo)
+
+	fmt.Fprintf(os.Stdout, "%s\n", packageInfo.Name)
+	fmt.Fprintf(os.Stdout, "  source:       %s\n", packageInfo.Source)
+	fmt.Fprintf(os.Stdout, "  branch:       %s\n", packageInfo.GetBranch())
+	fmt.Fprintf(os.Stdout, "  locked:       %s\n", packageInfo.GetRevision())
+	fmt.Fprintf(os.Stdout, "  path:         %s\n", git.RepoPath)
+	printPackageNotes(packageInfo, "  ")
+
+	if !git.IsCloned() {
+		fmt.Fprintf(os.Stdout, "  checked out:  (not installed)\n")
+	} else {
+		current, err := git.CurrentRevision()
+		if err != nil {
+			panic(err)
+		}
+		author, date, err := git.LastCommit()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stdout, "  checked out:  %s\n", current)
+		fmt.Fprintf(os.Stdout, "  last commit:  %s, %s\n", author, date)
+		if behind := git.CountBehind(packageInfo.GetBranch()); behind >= 0 {
+			fmt.Fprintf(os.Stdout, "  behind:       %d commits behind origin/%s (as of the last fetch)\n", behind, packageInfo.GetBranch())
+		}
+	}
+
+	requiredBy := []string{}
+	for _, node := range root.FindAll(packageInfo.Name) {
+		if node.Parent == root {
+			requiredBy = append(requiredBy, manifest.LOCK_FILE)
+		} else {
+			requiredBy = append(requiredBy, fmt.Sprintf("%s (%s)", node.Parent.Package.Name, node.Package.GetRef()))
+		}
+	}
+	fmt.Fprintf(os.Stdout, "  required by:  %s\n", strings.Join(requiredBy, ", "))
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Creates an empty package file for the project in the current directory,
+// naming the project after its git remote.
+func initCommand() {
+	if _, err := os.Stat(manifest.PACKAGE_FILE); err == nil {
+		panic(errors.New(fmt.Sprintf("%s already exists", manifest.PACKAGE_FILE)))
+	}
+
+	m := &manifest.Manifest{Packages: make(map[string]*manifest.Package)}
+	detectRepository(m)
+	if !m.HasRepository() {
+		warnf("couldn't work out the project's import path from its origin remote; add a Repository to %s to link it into the workspace", manifest.PACKAGE_FILE)
+	}
+	writeManifest(m, manifest.PACKAGE_FILE)
+	fmt.Fprintf(os.Stdout, "created %s\n", manifest.PACKAGE_FILE)
+}
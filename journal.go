@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Records the packages an install has finished, so an install that fails part
+// way resumes where it stopped instead of fetching everything again.
+const STATE_FILE string = ".deliver/state.json"
+
+type installState struct {
+	// SHA-256 of the lockfile being installed. The state left by an install of
+	// another lockfile is ignored.
+	Lockfile string
+	// The revision each finished package was installed at, by name.
+	Installed map[string]string
+}
+
+// The state of the install in progress, or nil if the command isn't an
+// install.
+var journal *installState
+
+func lockfileDigest() string {
+	data, err := ioutil.ReadFile(manifest.LOCK_FILE)
+	if err != nil {
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Starts recording the packages install finishes, picking up the state left by
+// an earlier install of the same lockfile that failed.
+func startJournal() {
+	journal = &installState{Lockfile: lockfileDigest(), Installed: map[string]string{}}
+	data, err := ioutil.ReadFile(STATE_FILE)
+	if err != nil {
+		return
+	}
+	previous := &installState{}
+	if err := json.Unmarshal(data, previous); err != nil || previous.Lockfile != journal.Lockfile {
+		return
+	}
+	if len(previous.Installed) > 0 {
+		journal.Installed = previous.Installed
+		fmt.Fprintf(os.Stdout, "resuming the last install: %d packages are already done\n", len(journal.Installed))
+	}
+}
+
+// Returns whether an earlier attempt at this install already installed the
+// package, and it is still at the revision it was installed at.
+func installedByJournal(packageInfo *manifest.Package) bool {
+	if journal == nil || !packageInfo.HasRevision() || journal.Installed[packageInfo.Name] != packageInfo.Revision {
+		return false
+	}
+	current, ok := getInstalledRevision(GitRepositoryFromPackage(packageInfo))
+	return ok && current == packageInfo.Revision
+}
+
+// Records that install finished a package.
+func recordInJournal(packageInfo *manifest.Package) {
+	if journal == nil || !packageInfo.HasRevision() || *noRun {
+		return
+	}
+	journal.Installed[packageInfo.Name] = packageInfo.Revision
+	data, err := json.MarshalIndent(journal, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(STATE_FILE), 0755); err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(STATE_FILE, append(data, '\n'), 0644); err != nil {
+		panic(err)
+	}
+}
+
+// Forgets the state once install has succeeded, so the next install checks
+// every package again.
+func finishJournal() {
+	if journal == nil {
+		return
+	}
+	journal = nil
+	if err := os.Remove(STATE_FILE); err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	// Only removed if nothing else was put there.
+	os.Remove(filepath.Dir(STATE_FILE))
+}
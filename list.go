@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Prints each direct dependency with its version and the notes recorded for
+// it. Reads the lockfile, or the package file if there is no lockfile yet.
+func listCommand() {
+	file := manifest.LOCK_FILE
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		file = manifest.PACKAGE_FILE
+	}
+	m := loadManifest(file)
+
+	names := []string{}
+	for name := range m.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		packageInfo := m.Packages[name]
+		fmt.Fprintf(os.Stdout, "%s %s\n", colorize(os.Stdout, COLOR_BOLD, name), packageInfo.GetRef())
+		printPackageNotes(packageInfo, "    ")
+	}
+}
+
+// Prints the description, owners and link of a package, if it has them.
+func printPackageNotes(packageInfo *manifest.Package, indent string) {
+	if packageInfo.Description != "" {
+		fmt.Fprintf(os.Stdout, "%sdescription:  %s\n", indent, packageInfo.Description)
+	}
+	if owners := packageInfo.GetOwners(); len(owners) > 0 {
+		fmt.Fprintf(os.Stdout, "%sowners:       %s\n", indent, strings.Join(owners, " "))
+	}
+	if packageInfo.Link != "" {
+		fmt.Fprintf(os.Stdout, "%slink:         %s\n", indent, packageInfo.Link)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Rebuilds the lockfile from the package file and the revisions checked out in
+// the workspace, without fetching or moving anything. Packages that aren't
+// installed keep the revision the old lockfile had for them, if any.
+func lockCommand(root *resolve.Node) {
+	// Only read-only git commands are run, so -n need only keep the lockfile
+	// from being written.
+	vcs.DryRun = false
+	packageManifest := loadManifest(manifest.PACKAGE_FILE)
+	detectRepository(packageManifest)
+	previous, err := manifest.Load(manifest.LOCK_FILE)
+	if err != nil && !os.IsNotExist(err) {
+		warnf("ignoring %s: %v", manifest.LOCK_FILE, err)
+	}
+	if err != nil {
+		previous = nil
+	}
+
+	names := []string{}
+	for name := range packageManifest.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		packageInfo := packageManifest.Packages[name]
+		current, installed := getInstalledRevision(GitRepositoryFromPackage(packageInfo))
+		var locked *manifest.Package
+		if previous != nil {
+			locked = previous.Packages[name]
+		}
+
+		switch {
+		case packageInfo.HasRevision():
+			if installed && current != packageInfo.Revision {
+				warnf("%s is checked out at %s, but %s pins it to %s", name, current, manifest.PACKAGE_FILE, packageInfo.Revision)
+			}
+		case installed:
+			packageInfo.Revision = current
+		case locked != nil && locked.Source == packageInfo.Source && locked.GetBranch() == packageInfo.GetBranch() && locked.HasRevision():
+			warnf("%s is not installed, so it stays at %s", name, locked.Revision)
+			packageInfo.Revision = locked.Revision
+			packageInfo.Hash = locked.Hash
+		default:
+			panic(errors.New(fmt.Sprintf("%s is not installed, so there is no revision to lock it to. Run deliver update %s.", name, name)))
+		}
+	}
+
+	loadPackages(root, packageManifest)
+	var resolutions map[string]*manifest.Resolution
+	if previous != nil {
+		resolutions = previous.Resolutions
+	}
+	conflicts := findConflicts(root, resolutions)
+	if previous != nil {
+		printLockChanges(previous, packageManifest)
+	}
+	packageManifest.Resolutions = resolve.ResolutionsFor(conflicts)
+	completeLockfile(packageManifest)
+	carryBinaryChecksums(packageManifest, previous)
+
+	if *noRun {
+		return
+	}
+	writeManifest(packageManifest, manifest.LOCK_FILE)
+	emitEvent(&Event{Type: EVENT_LOCKFILE_WRITTEN, Path: manifest.LOCK_FILE})
+	fmt.Fprintf(os.Stdout, "wrote %s\n", manifest.LOCK_FILE)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Returns why the lockfile no longer matches the package file: packages that
+// are missing from it, unless includeMissing is false, or locked from another
+// source or branch, and binaries locked at another release.
+func staleLockProblems(packageManifest, lockManifest *manifest.Manifest, includeMissing bool) []string {
+	names := []string{}
+	for name := range packageManifest.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := []string{}
+	for _, name := range names {
+		wanted := packageManifest.Packages[name]
+		locked, ok := lockManifest.Packages[name]
+		switch {
+		case !ok && !includeMissing:
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is in %s but not in %s", name, manifest.PACKAGE_FILE, manifest.LOCK_FILE))
+		case wanted.Source != "" && wanted.Source != locked.Source:
+			problems = append(problems, fmt.Sprintf("%s is locked from %s, but %s asks for %s", name, locked.Source, manifest.PACKAGE_FILE, wanted.Source))
+		case wanted.GetImportPath() != locked.GetImportPath():
+			problems = append(problems, fmt.Sprintf("%s is locked at import path %s, but %s asks for %s", name, locked.GetImportPath(), manifest.PACKAGE_FILE, wanted.GetImportPath()))
+		case wanted.GetBranch() != locked.GetBranch():
+			problems = append(problems, fmt.Sprintf("%s is locked on branch %s, but %s asks for %s", name, locked.GetBranch(), manifest.PACKAGE_FILE, wanted.GetBranch()))
+		case wanted.HasRevision() && wanted.Revision != locked.Revision:
+			problems = append(problems, fmt.Sprintf("%s is locked at %s, but %s pins it to %s", name, locked.Revision, manifest.PACKAGE_FILE, wanted.Revision))
+		}
+	}
+
+	names = names[:0]
+	for name := range packageManifest.Binaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		wanted := packageManifest.Binaries[name]
+		locked, ok := lockManifest.Binaries[name]
+		if !ok || wanted.Repository != locked.Repository || wanted.Tag != locked.Tag || wanted.Asset != locked.Asset {
+			problems = append(problems, fmt.Sprintf("binary %s is not locked at %s %s as %s asks", name, wanted.Repository, wanted.Tag, manifest.PACKAGE_FILE))
+		}
+	}
+	return problems
+}
+
+// Warns if the lockfile is out of date with the package file, or fails with
+// -strict_lock, so install doesn't quietly install an old set of packages.
+func checkLockIsCurrent() {
+	if _, err := os.Stat(manifest.PACKAGE_FILE); os.IsNotExist(err) {
+		return
+	}
+	packageManifest, err := loadPackageFile()
+	if err != nil {
+		panic(err)
+	}
+	lockManifest, err := manifest.Load(manifest.LOCK_FILE)
+	if err != nil || lockManifest.ExpandVariables() != nil {
+		// Install reports a missing lockfile or variable itself.
+		return
+	}
+
+	// install -sync adds the missing packages itself.
+	problems := staleLockProblems(packageManifest, lockManifest, !installSync)
+	if len(problems) == 0 {
+		return
+	}
+	for _, problem := range problems {
+		warnf("%s", problem)
+	}
+	if *strictLock {
+		panic(errors.New(fmt.Sprintf("%s is out of date with %s. Run deliver update to update it.", manifest.LOCK_FILE, manifest.PACKAGE_FILE)))
+	}
+	warnf("%s is out of date with %s; run deliver update to update it", manifest.LOCK_FILE, manifest.PACKAGE_FILE)
+}
+
+// Set from install's -sync flag.
+var installSync bool
+
+// Parses install's flags and returns the command line without them.
+func parseInstallArgs(args []string) []string {
+	flags := flag.NewFlagSet("install", flag.ExitOnError)
+	flags.BoolVar(&installSync, "sync", false, "also install packages added to packages.json since the last update, and add them to packages.lock")
+	only := flags.String("only", "", "install only these packages from packages.lock and their dependencies, separated by commas")
+	except := flags.String("except", "", "don't install these packages, separated by commas, wherever they are in the dependency tree")
+	flags.BoolVar(&installAllManifests, "all-manifests", false, "install the packages of every project with a packages.lock under the current directory into one shared workspace, as one dependency graph")
+	flags.BoolVar(&installEachManifest, "each-manifest", false, "install every project with a packages.lock under the current directory into a workspace of its own")
+	flags.Parse(args[1:])
+	if installSync && flags.NArg() > 0 {
+		panic(errors.New("install -sync can't be combined with a package name"))
+	}
+	installOnly, installExcept = splitNames(*only), splitNames(*except)
+	if (installOnly != nil || installExcept != nil) && (installSync || flags.NArg() > 0) {
+		panic(errors.New("install -only and -except can't be combined with -sync or a package name"))
+	}
+	if installAllManifests && (installSync || installOnly != nil || flags.NArg() > 0) {
+		panic(errors.New("install -all-manifests can't be combined with -sync, -only or a package name"))
+	}
+	if installAllManifests && *noRun {
+		panic(errors.New("install -all-manifests can't be planned with -n"))
+	}
+	if installEachManifest && (installAllManifests || installSync || installOnly != nil || flags.NArg() > 0) {
+		panic(errors.New("install -each-manifest can't be combined with -all-manifests, -sync, -only or a package name"))
+	}
+	return append([]string{args[0]}, flags.Args()...)
+}
+
+// Set from install's -all-manifests and -each-manifest flags.
+var installAllManifests, installEachManifest bool
+
+// Set from install's -only and -except flags. Nil if the flag wasn't given.
+var installOnly, installExcept map[string]bool
+
+// Returns the set of the names in a comma-separated list, or nil if it is
+// empty.
+func splitNames(list string) map[string]bool {
+	var names map[string]bool
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if names == nil {
+				names = make(map[string]bool)
+			}
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// Returns the lockfile with only the packages install should start from:
+// those named by -only, if it was given, less those named by -except.
+func selectPackages(lockManifest *manifest.Manifest) *manifest.Manifest {
+	if installOnly == nil && installExcept == nil {
+		return lockManifest
+	}
+	for name := range installOnly {
+		if _, ok := lockManifest.Packages[name]; !ok {
+			panic(errors.New(fmt.Sprintf("Package %s not found in %s", name, manifest.LOCK_FILE)))
+		}
+	}
+	selected := *lockManifest
+	selected.Packages = make(map[string]*manifest.Package)
+	for name, packageInfo := range lockManifest.Packages {
+		if (installOnly == nil || installOnly[name]) && !installExcept[name] {
+			selected.Packages[name] = packageInfo
+		}
+	}
+	return &selected
+}
+
+// Adds the packages in the package file that are missing from the lockfile to
+// it, unlocked, so they are installed at the tips of their branches. Returns
+// their names.
+func syncNewPackages(lockManifest *manifest.Manifest) []string {
+	if _, err := os.Stat(manifest.PACKAGE_FILE); os.IsNotExist(err) {
+		return nil
+	}
+	packageManifest := loadManifest(manifest.PACKAGE_FILE)
+	added := []string{}
+	for name, packageInfo := range packageManifest.Packages {
+		if _, ok := lockManifest.Packages[name]; !ok {
+			lockManifest.Packages[name] = packageInfo
+			// The version asked for directly is the one to use, not one
+			// chosen between dependencies before.
+			delete(lockManifest.Resolutions, packageInfo.Source)
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		fmt.Fprintf(os.Stdout, "adding %s from %s\n", name, manifest.PACKAGE_FILE)
+	}
+	return added
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Signatures over the lockfile are made in this namespace, so they can't be
+// passed off as signatures of anything else.
+const LOCK_SIGNATURE_NAMESPACE string = "deliver-lock"
+
+const (
+	SIGNATURE_SSH      = "ssh"
+	SIGNATURE_MINISIGN = "minisign"
+)
+
+// Returns the file a detached signature of the lockfile is kept in.
+func lockSignatureFile(format string) string {
+	if format == SIGNATURE_MINISIGN {
+		return manifest.LOCK_FILE + ".minisig"
+	}
+	return manifest.LOCK_FILE + ".sig"
+}
+
+func checkSignatureFormat(format string) {
+	if format != SIGNATURE_SSH && format != SIGNATURE_MINISIGN {
+		panic(errors.New(fmt.Sprintf("invalid -format %q: must be %s or %s", format, SIGNATURE_SSH, SIGNATURE_MINISIGN)))
+	}
+}
+
+// Runs a signing tool with stdin read from a file, if one is given. Returns
+// its output, and its output as the error if it fails.
+func runSigner(stdin string, args ...string) (string, error) {
+	if *verbose {
+		fmt.Fprintf(os.Stdout, "%s\n", strings.Join(args, " "))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	if stdin != "" {
+		f, err := os.Open(stdin)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		cmd.Stdin = f
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		if out.Len() > 0 {
+			return out.String(), errors.New(strings.TrimSpace(out.String()))
+		}
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Writes a detached signature of the lockfile with an SSH key or a minisign
+// key.
+func lockSignCommand(args []string) {
+	flags := flag.NewFlagSet("lock sign", flag.ExitOnError)
+	format := flags.String("format", SIGNATURE_SSH, "sign with an SSH key (ssh) or a minisign key (minisign)")
+	key := flags.String("key", "", "private key to sign with. Defaults to ~/.ssh/id_ed25519 for ssh and minisign's own default for minisign")
+	flags.Parse(args)
+	checkSignatureFormat(*format)
+	if _, err := os.Stat(manifest.LOCK_FILE); err != nil {
+		panic(errors.New(fmt.Sprintf("there is no %s to sign. Run deliver update first.", manifest.LOCK_FILE)))
+	}
+
+	signatureFile := lockSignatureFile(*format)
+	var command []string
+	if *format == SIGNATURE_SSH {
+		if *key == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				panic(err)
+			}
+			*key = filepath.Join(home, ".ssh", "id_ed25519")
+		}
+		command = []string{"ssh-keygen", "-Y", "sign", "-f", *key, "-n", LOCK_SIGNATURE_NAMESPACE, manifest.LOCK_FILE}
+	} else {
+		command = []string{"minisign", "-S", "-m", manifest.LOCK_FILE, "-x", signatureFile}
+		if *key != "" {
+			command = append(command, "-s", *key)
+		}
+	}
+	if *noRun {
+		fmt.Fprintf(os.Stdout, "%s\n", strings.Join(command, " "))
+		return
+	}
+
+	// ssh-keygen asks before replacing a signature.
+	if err := os.Remove(signatureFile); err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	if _, err := runSigner("", command...); err != nil {
+		panic(errors.New(fmt.Sprintf("could not sign %s: %v", manifest.LOCK_FILE, err)))
+	}
+	fmt.Fprintf(os.Stdout, "wrote %s\n", signatureFile)
+}
+
+// Checks the lockfile's detached signature against the keys of the
+// maintainers allowed to sign it, and fails unless one of them did.
+func lockVerifyCommand(args []string) {
+	flags := flag.NewFlagSet("lock verify", flag.ExitOnError)
+	format := flags.String("format", SIGNATURE_SSH, "check an SSH signature (ssh) or a minisign signature (minisign)")
+	keys := flags.String("keys", "", "the authorized maintainers' keys: an allowed_signers file for ssh (see ssh-keygen(1)), or a file of minisign public keys, one per line")
+	flags.Parse(args)
+	checkSignatureFormat(*format)
+	if *keys == "" {
+		panic(errors.New("lock verify needs -keys, the keys of the maintainers allowed to sign " + manifest.LOCK_FILE))
+	}
+	signatureFile := lockSignatureFile(*format)
+	if _, err := os.Stat(signatureFile); err != nil {
+		panic(errors.New(fmt.Sprintf("%s is not signed: %s is missing", manifest.LOCK_FILE, signatureFile)))
+	}
+
+	if *format == SIGNATURE_SSH {
+		out, err := runSigner("", "ssh-keygen", "-Y", "find-principals", "-s", signatureFile, "-f", *keys)
+		if err != nil {
+			panic(errors.New(fmt.Sprintf("%s was not signed by an authorized key in %s", manifest.LOCK_FILE, *keys)))
+		}
+		for _, principal := range strings.Fields(out) {
+			if _, err := runSigner(manifest.LOCK_FILE, "ssh-keygen", "-Y", "verify", "-f", *keys, "-I", principal,
+				"-n", LOCK_SIGNATURE_NAMESPACE, "-s", signatureFile); err == nil {
+				fmt.Fprintf(os.Stdout, "%s was signed by %s\n", manifest.LOCK_FILE, principal)
+				return
+			}
+		}
+		panic(errors.New(fmt.Sprintf("the signature in %s does not match %s; it was changed after it was signed", signatureFile, manifest.LOCK_FILE)))
+	}
+
+	data, err := ioutil.ReadFile(*keys)
+	if err != nil {
+		panic(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		publicKey := strings.TrimSpace(line)
+		if publicKey == "" || strings.HasPrefix(publicKey, "#") || strings.HasPrefix(publicKey, "untrusted comment:") {
+			continue
+		}
+		if _, err := runSigner("", "minisign", "-V", "-q", "-P", publicKey, "-m", manifest.LOCK_FILE, "-x", signatureFile); err == nil {
+			fmt.Fprintf(os.Stdout, "%s was signed by %s\n", manifest.LOCK_FILE, publicKey)
+			return
+		}
+	}
+	panic(errors.New(fmt.Sprintf("%s was not signed by an authorized key in %s, or was changed after it was signed", manifest.LOCK_FILE, *keys)))
+}
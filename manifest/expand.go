@@ -0,0 +1,118 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Matches ${NAME}, which is looked up in the environment and then with
+// LookupVariable, and ${env:NAME}, which only comes from the environment.
+var variablePattern = regexp.MustCompile(`\$\{(env:)?([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Looks up ${NAME} variables that aren't set in the environment. Set by main
+// to read deliver's config.
+var LookupVariable = func(name string) (string, bool) {
+	return "", false
+}
+
+// Returns whether value refers to a variable.
+func hasVariables(value string) bool {
+	return variablePattern.MatchString(value)
+}
+
+func expandVariables(value string) (string, error) {
+	var err error
+	expanded := variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		m := variablePattern.FindStringSubmatch(match)
+		if v, ok := os.LookupEnv(m[2]); ok {
+			return v
+		}
+		if m[1] == "" {
+			if v, ok := LookupVariable(m[2]); ok {
+				return v
+			}
+		}
+		if err == nil {
+			err = errors.New(fmt.Sprintf("%s is not set", match))
+		}
+		return match
+	})
+	return expanded, err
+}
+
+// A value with variables, and what they expanded to.
+type expansion struct {
+	raw, expanded string
+}
+
+// Returns value as written: with its variables, if it is still what they
+// expanded to.
+func (e expansion) restore(value string) string {
+	if e.raw != "" && value == e.expanded {
+		return e.raw
+	}
+	return value
+}
+
+// Writes the package with the variables in its Source, Branch and Archive
+// rather than their values, so values that come from the environment or
+// deliver's settings, such as mirrors or tokens, stay out of the files.
+func (p Package) MarshalJSON() ([]byte, error) {
+	type plain Package
+	written := plain(p)
+	written.Source = p.sourceVariables.restore(p.Source)
+	written.Branch = p.branchVariables.restore(p.Branch)
+	written.Archive = p.archiveVariables.restore(p.Archive)
+	return json.Marshal(written)
+}
+
+// Replaces variables in the package's source, branch and archive URL with
+// their values, and checks the results. The values are only used to fetch the
+// package; it is written with the variables.
+func (p *Package) ExpandVariables() error {
+	source, err := expandVariables(p.Source)
+	if err != nil {
+		return errors.New(fmt.Sprintf("package %s: Source %q: %v", p.Name, p.Source, err))
+	}
+	if source != p.Source && !validSource(source) {
+		return errors.New(fmt.Sprintf("package %s: Source %q expands to %q, which is not a valid source", p.Name, p.Source, source))
+	}
+	branch, err := expandVariables(p.Branch)
+	if err != nil {
+		return errors.New(fmt.Sprintf("package %s: Branch %q: %v", p.Name, p.Branch, err))
+	}
+	if branch != p.Branch && !validRef(branch) {
+		return errors.New(fmt.Sprintf("package %s: Branch %q expands to %q, which is not a valid branch", p.Name, p.Branch, branch))
+	}
+	archive, err := expandVariables(p.Archive)
+	if err != nil {
+		return errors.New(fmt.Sprintf("package %s: Archive %q: %v", p.Name, p.Archive, err))
+	}
+	if archive != p.Archive && !validArchive(archive) {
+		return errors.New(fmt.Sprintf("package %s: Archive %q expands to %q, which is not an http, https, s3 or gs URL", p.Name, p.Archive, archive))
+	}
+	if source != p.Source {
+		p.sourceVariables = expansion{raw: p.Source, expanded: source}
+	}
+	if branch != p.Branch {
+		p.branchVariables = expansion{raw: p.Branch, expanded: branch}
+	}
+	if archive != p.Archive {
+		p.archiveVariables = expansion{raw: p.Archive, expanded: archive}
+	}
+	p.Source, p.Branch, p.Archive = source, branch, archive
+	return nil
+}
+
+// Expands the variables of every package, as Package.ExpandVariables does.
+func (m *Manifest) ExpandVariables() error {
+	for _, packageInfo := range m.Packages {
+		if err := packageInfo.ExpandVariables(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
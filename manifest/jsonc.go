@@ -0,0 +1,57 @@
+package manifest
+
+// Returns data with // and /* */ comments and trailing commas in objects and
+// arrays replaced by spaces, so it can be read as strict JSON. Newlines are
+// kept, and nothing moves, so offsets in syntax errors still point at the
+// right place in the original.
+func StripComments(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	// Offset of the last comma that followed a value, or -1 if something
+	// other than a comment or space came after it.
+	lastComma := -1
+	// Whether the last thing outside of comments and space ended a value.
+	afterValue := false
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '"':
+			lastComma, afterValue = -1, true
+			for i++; i < len(out) && out[i] != '"'; i++ {
+				if out[i] == '\\' {
+					i++
+				}
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			out[i], out[i+1] = ' ', ' '
+			for i += 2; i < len(out) && !(out[i] == '*' && i+1 < len(out) && out[i+1] == '/'); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			if i < len(out) {
+				out[i], out[i+1] = ' ', ' '
+				i++
+			}
+		case c == ',':
+			if afterValue {
+				lastComma = i
+			}
+			afterValue = false
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				out[lastComma] = ' '
+			}
+			lastComma, afterValue = -1, true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			lastComma = -1
+			afterValue = c != '{' && c != '[' && c != ':'
+		}
+	}
+	return out
+}
@@ -0,0 +1,361 @@
+// Package manifest reads and writes deliver's packages.json manifests and
+// packages.lock lockfiles.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	PACKAGE_FILE string = "packages.json"
+	LOCK_FILE    string = "packages.lock"
+)
+
+// Versions of the package file and lockfile formats this release reads and
+// writes. They change when the meaning of a file would, so deliver version
+// can tell which files a build understands.
+const (
+	PACKAGE_FORMAT_VERSION int = 1
+	LOCK_FORMAT_VERSION    int = 1
+)
+
+type Manifest struct {
+	Repository string `json:",omitempty"`
+	// More import paths in the project, each mapped to its directory relative
+	// to the project root. Lets a repository with several Go import roots link
+	// all of them into the workspace.
+	Repositories map[string]string `json:",omitempty"`
+	Packages     map[string]*Package
+	// Prebuilt tools, such as protoc plugins, by the name they are installed
+	// under in the workspace's bin directory.
+	Binaries    map[string]*Binary     `json:",omitempty"`
+	Resolutions map[string]*Resolution `json:",omitempty"`
+	// Fingerprints of the keys presented by the hosts packages are fetched
+	// from, by endpoint, such as ssh://github.com, as first seen. Only
+	// lockfiles have them.
+	HostKeys map[string]string `json:",omitempty"`
+}
+
+// Writes the manifest as indented JSON.
+func (m *Manifest) WriteToFile(fileName string) error {
+	data, err := m.Format()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+// Returns the manifest as JSON indented with tabs, with keys in a fixed order
+// and a trailing newline.
+func (m *Manifest) Format() ([]byte, error) {
+	data, err := json.Marshal(*m)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "\t"); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// Returns the name of the overlay file for an environment, such as
+// packages.staging.json.
+func OverlayFile(env string) string {
+	return strings.TrimSuffix(PACKAGE_FILE, ".json") + "." + env + ".json"
+}
+
+// Merges an overlay over the manifest. Packages only in the overlay are added,
+// and the fields an overlay package sets replace those of the package in the
+// manifest. An overlay that changes a package's branch without pinning a
+// revision unpins it. Binaries in the overlay replace those in the manifest.
+func (m *Manifest) Merge(overlay *Manifest) {
+	if overlay.Repository != "" {
+		m.Repository = overlay.Repository
+	}
+	for importPath, dir := range overlay.Repositories {
+		if m.Repositories == nil {
+			m.Repositories = make(map[string]string)
+		}
+		m.Repositories[importPath] = dir
+	}
+	if m.Packages == nil {
+		m.Packages = make(map[string]*Package)
+	}
+	for name, override := range overlay.Packages {
+		packageInfo, ok := m.Packages[name]
+		if !ok {
+			m.Packages[name] = override
+			continue
+		}
+		if override.Branch != "" && override.Branch != packageInfo.Branch {
+			packageInfo.Branch = override.Branch
+			packageInfo.Revision = ""
+		}
+		if override.Submodules != nil {
+			packageInfo.Submodules = override.Submodules
+		}
+		if override.Owners != nil {
+			packageInfo.Owners = override.Owners
+		}
+		if override.CloneArgs != nil {
+			packageInfo.CloneArgs = override.CloneArgs
+		}
+		if override.FetchArgs != nil {
+			packageInfo.FetchArgs = override.FetchArgs
+		}
+		if override.Exclude != nil {
+			packageInfo.Exclude = override.Exclude
+		}
+		for _, field := range []struct{ to, from *string }{
+			{&packageInfo.Source, &override.Source},
+			{&packageInfo.Archive, &override.Archive},
+			{&packageInfo.ImportPath, &override.ImportPath},
+			{&packageInfo.ForkImportPath, &override.ForkImportPath},
+			{&packageInfo.Revision, &override.Revision},
+			{&packageInfo.Description, &override.Description},
+			{&packageInfo.Owner, &override.Owner},
+			{&packageInfo.Link, &override.Link},
+		} {
+			if *field.from != "" {
+				*field.to = *field.from
+			}
+		}
+	}
+	for name, override := range overlay.Binaries {
+		if m.Binaries == nil {
+			m.Binaries = make(map[string]*Binary)
+		}
+		m.Binaries[name] = override
+	}
+}
+
+func (m *Manifest) HasRepository() bool {
+	return m.Repository != "" || len(m.Repositories) > 0
+}
+
+// Returns every import path of the project, mapped to its directory relative
+// to the project root.
+func (m *Manifest) Links() map[string]string {
+	links := make(map[string]string)
+	for importPath, dir := range m.Repositories {
+		links[importPath] = dir
+	}
+	if m.Repository != "" {
+		links[m.Repository] = "."
+	}
+	return links
+}
+
+// Returns the project's import paths in a stable order.
+func (m *Manifest) LinkPaths() []string {
+	var importPaths []string
+	for importPath := range m.Links() {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+	return importPaths
+}
+
+// Packages defined in the manifest
+type Package struct {
+	Name     string `json:"-"`
+	Source   string `json:",omitempty"`
+	Branch   string `json:",omitempty"`
+	Revision string `json:",omitempty"`
+	// Notes for audits: why the package is needed, who looks after it, and
+	// where to read more. Carried into the lockfile unchanged.
+	Description string `json:",omitempty"`
+	Owner       string `json:",omitempty"`
+	Link        string `json:",omitempty"`
+	// Teams or people who review changes to the package, as in a CODEOWNERS
+	// file, such as @org/payments. Supersedes Owner; see GetOwners.
+	Owners []string `json:",omitempty"`
+	// Whether to check out the package's git submodules. If unset, they are
+	// checked out if the package has a .gitmodules file.
+	Submodules *bool `json:",omitempty"`
+	// Extra arguments for git clone and git fetch, for repositories that need
+	// them.
+	CloneArgs []string `json:",omitempty"`
+	FetchArgs []string `json:",omitempty"`
+	// Patterns, in the syntax of .gitignore, of files to leave out of the
+	// package's checkout, such as large testdata directories.
+	Exclude []string `json:",omitempty"`
+	// The import path to install the package under, if the package's name is
+	// a friendlier one, such as minion for github.com/edmodo/minion.
+	ImportPath string `json:",omitempty"`
+	// The import path a fork's code refers to itself by, if it isn't the
+	// package's import path. Imports of it in the checkout are rewritten to
+	// the import path, so the fork builds where it is installed.
+	ForkImportPath string `json:",omitempty"`
+	// URL of a .tar.gz of each revision in a generic repository such as
+	// Artifactory or Nexus or in an s3:// or gs:// bucket, with {revision} in
+	// place of the revision. Locked revisions are installed from there instead
+	// of from Source.
+	Archive string `json:",omitempty"`
+	// Content hash of the revision's files, recorded in the lockfile so a
+	// source that changes what a revision contains is caught.
+	Hash string `json:",omitempty"`
+
+	// Source, Branch and Archive as written, where ExpandVariables replaced
+	// variables in them.
+	sourceVariables, branchVariables, archiveVariables expansion
+}
+
+func (p *Package) GetBranch() string {
+	if p.Branch == "" {
+		return "master"
+	}
+	return p.Branch
+}
+
+// Returns the import path the package is installed under: its ImportPath, or
+// else its name.
+func (p *Package) GetImportPath() string {
+	if p.ImportPath == "" {
+		return p.Name
+	}
+	return p.ImportPath
+}
+
+// Returns who reviews changes to the package: its Owners, or else its Owner.
+func (p *Package) GetOwners() []string {
+	if len(p.Owners) == 0 && p.Owner != "" {
+		return []string{p.Owner}
+	}
+	return p.Owners
+}
+
+func (p *Package) GetRevision() string {
+	if !p.HasRevision() {
+		return "HEAD"
+	}
+	return p.Revision
+}
+
+func (p *Package) GetRef() string {
+	return fmt.Sprintf("%s/%s", p.GetBranch(), p.GetRevision())
+}
+
+func (p *Package) HasRevision() bool {
+	return p.Revision != ""
+}
+
+func (p *Package) Dump(w io.Writer) {
+	fmt.Fprintf(w, "%s %s/%s\n", p.Source, p.GetBranch(), p.GetRevision())
+}
+
+// A binary downloaded from a GitHub release.
+type Binary struct {
+	// The GitHub repository that publishes the release, as owner/repo.
+	Repository string
+	Tag        string
+	// The name of the release asset. {os} and {arch} are replaced with
+	// GOOS and GOARCH, {tag} with the tag and {version} with the tag without
+	// its leading v. The asset is either the binary itself or a .tar.gz,
+	// .tgz or .zip archive containing it.
+	Asset string
+	// SHA-256 checksums of the assets, by asset name, so every platform's
+	// can be recorded. Kept in the lockfile.
+	Checksums map[string]string `json:",omitempty"`
+}
+
+// One request for a package that was requested at conflicting versions, along
+// with the chain of packages that requested it (nearest first).
+type ConflictCandidate struct {
+	Ref         string
+	RequestedBy []string
+}
+
+// Records which request won when conflicting versions of a package were found,
+// so later installs pick the same one.
+type Resolution struct {
+	Chosen   *ConflictCandidate
+	Rejected []*ConflictCandidate
+	// Why Chosen was chosen, if not because it was the first request.
+	Reason string `json:",omitempty"`
+}
+
+// Reports problems in manifests that aren't errors, such as fields a lockfile
+// has that this release doesn't know. Set by main to print warnings.
+var Warn = func(err error) {}
+
+// Parses a manifest. Package names are filled in from the keys of Packages.
+// Comments and trailing commas are allowed, so packages.json can explain why
+// a package is pinned; manifests are always written as strict JSON.
+// Unknown fields, values of the wrong type and invalid values are reported as
+// a *ManifestError.
+func Parse(data []byte) (*Manifest, error) {
+	return parse(data, "", true)
+}
+
+// Parses a lockfile, such as a dependency's, which file names in warnings.
+// Unlike Parse, fields this release doesn't know are passed to Warn and
+// ignored, since a newer release of deliver may have written the lockfile.
+func ParseLockfile(data []byte, file string) (*Manifest, error) {
+	return parse(data, file, false)
+}
+
+func parse(data []byte, file string, strict bool) (*Manifest, error) {
+	data = StripComments(data)
+	manifest := &Manifest{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(manifest)
+	if err != nil && !strict && unknownFieldPattern.MatchString(err.Error()) {
+		if e, ok := decodeError(data, err).(*ManifestError); ok {
+			e.File = file
+			e.Msg += ", which a newer release of deliver may have added; it is ignored"
+			Warn(e)
+		}
+		manifest = &Manifest{}
+		decoder = json.NewDecoder(bytes.NewReader(data))
+		err = decoder.Decode(manifest)
+	}
+	if err != nil {
+		return nil, decodeError(data, err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errorAt(data, int(decoder.InputOffset()), "unexpected data after the end of the manifest")
+	}
+	if err := manifest.validate(data); err != nil {
+		return nil, err
+	}
+	for packageName, packageInfo := range manifest.Packages {
+		packageInfo.Name = packageName
+	}
+	return manifest, nil
+}
+
+// Returns whether file is a package file or an overlay of one, which are
+// written by hand and parsed strictly, rather than a lockfile.
+func isPackageFile(file string) bool {
+	base := path.Base(file)
+	return base == PACKAGE_FILE || (strings.HasPrefix(base, strings.TrimSuffix(PACKAGE_FILE, "json")) && strings.HasSuffix(base, ".json"))
+}
+
+// Parses the manifest file into a Manifest struct.
+func Load(manifestFile string) (*Manifest, error) {
+	fileBytes, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var m *Manifest
+	if isPackageFile(manifestFile) {
+		m, err = Parse(fileBytes)
+	} else {
+		m, err = ParseLockfile(fileBytes, manifestFile)
+	}
+	if e, ok := err.(*ManifestError); ok {
+		e.File = manifestFile
+	}
+	return m, err
+}
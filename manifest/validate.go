@@ -0,0 +1,203 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A problem in a manifest, and where in the file it was found.
+type ManifestError struct {
+	// Empty if the manifest wasn't read from a file.
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *ManifestError) Error() string {
+	file := e.File
+	if file == "" {
+		file = "manifest"
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", file, e.Line, e.Column, e.Msg)
+}
+
+var unknownFieldPattern = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+
+// Returns the error at offset in data.
+func errorAt(data []byte, offset int, format string, args ...interface{}) *ManifestError {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	return &ManifestError{Line: line, Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Returns the offset of the JSON key in data, searching from offset from, or
+// from if the key can't be found. Keys are matched without regard to case, as
+// encoding/json does.
+func findKey(data []byte, from int, key string) int {
+	quoted, _ := json.Marshal(key)
+	pattern := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(string(quoted)) + `\s*:`)
+	if loc := pattern.FindIndex(data[from:]); loc != nil {
+		return from + loc[0]
+	}
+	return from
+}
+
+// Converts an error from encoding/json into one that says where in data the
+// problem is.
+func decodeError(data []byte, err error) error {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		return errorAt(data, int(e.Offset), "%s", strings.TrimPrefix(e.Error(), "json: "))
+	case *json.UnmarshalTypeError:
+		// Map keys in the field path are escaped as in a JSON pointer.
+		field := strings.NewReplacer("~1", "/", "~0", "~").Replace(e.Field)
+		if field == "" {
+			field = "the manifest"
+		}
+		return errorAt(data, int(e.Offset), "%s must be %s, not %s", field, describeType(e.Type.String()), e.Value)
+	}
+	if m := unknownFieldPattern.FindStringSubmatch(err.Error()); m != nil {
+		return errorAt(data, findKey(data, 0, m[1]), "unknown field %q", m[1])
+	}
+	return err
+}
+
+func describeType(goType string) string {
+	switch {
+	case goType == "string":
+		return "a string"
+	case strings.HasPrefix(goType, "map["), strings.HasPrefix(goType, "manifest."), strings.HasPrefix(goType, "*manifest."):
+		return "an object"
+	case strings.HasPrefix(goType, "[]"):
+		return "an array"
+	}
+	return goType
+}
+
+// Returns whether source looks like something git can clone: a URL with a
+// host, an scp-like address such as git@host:path, or a local path.
+func validSource(source string) bool {
+	if strings.ContainsAny(source, " \t\n") {
+		return false
+	}
+	if strings.Contains(source, "://") {
+		u, err := url.Parse(source)
+		return err == nil && (u.Host != "" || u.Scheme == "file") && strings.Trim(u.Path, "/") != ""
+	}
+	return true
+}
+
+// Returns whether archive is a URL archives can be downloaded from: http,
+// https, or an S3 or Google Cloud Storage bucket.
+func validArchive(archive string) bool {
+	u, err := url.Parse(archive)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "s3", "gs":
+		return true
+	}
+	return false
+}
+
+// Returns whether name can be used as a branch or revision.
+func validRef(name string) bool {
+	return !strings.ContainsAny(name, " \t\n~^:?*[\\") && !strings.HasPrefix(name, "-") &&
+		!strings.Contains(name, "..") && !strings.HasSuffix(name, "/")
+}
+
+// Returns whether importPath can be a directory under a workspace's src.
+func validImportPath(importPath string) bool {
+	return importPath != "" && !strings.ContainsAny(importPath, " \t\n\\") && !strings.Contains(importPath, "://") &&
+		!path.IsAbs(importPath) && path.Clean(importPath) == importPath && importPath != ".." && !strings.HasPrefix(importPath, "../")
+}
+
+// Checks the values in a parsed manifest, using data to say where problems
+// are. Packages are checked in name order, so the first problem in the file is
+// not necessarily the one reported.
+func (m *Manifest) validate(data []byte) error {
+	for importPath, dir := range m.Repositories {
+		if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(path.Clean(dir), "../") {
+			return errorAt(data, findKey(data, 0, importPath), "repository %s must be a directory inside the project, not %q", importPath, dir)
+		}
+	}
+
+	names := []string{}
+	for name := range m.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	installedAs := map[string]string{}
+	for _, name := range names {
+		packageInfo := m.Packages[name]
+		at := findKey(data, 0, name)
+		if packageInfo == nil {
+			return errorAt(data, at, "package %s must be an object, not null", name)
+		}
+		if name == "" || strings.ContainsAny(name, " \t\n") || strings.Contains(name, "://") {
+			return errorAt(data, at, "%q is not a valid package name; use the import path, such as github.com/owner/repo", name)
+		}
+		if packageInfo.ImportPath != "" && !validImportPath(packageInfo.ImportPath) {
+			return errorAt(data, findKey(data, at, "ImportPath"), "package %s has an invalid ImportPath %q; use an import path such as github.com/owner/repo", name, packageInfo.ImportPath)
+		}
+		importPath := name
+		if packageInfo.ImportPath != "" {
+			importPath = packageInfo.ImportPath
+		}
+		if other, ok := installedAs[importPath]; ok {
+			return errorAt(data, at, "packages %s and %s are both installed at %s", other, name, importPath)
+		}
+		installedAs[importPath] = name
+		// Values with variables are checked once they are expanded.
+		if packageInfo.Source != "" && !hasVariables(packageInfo.Source) && !validSource(packageInfo.Source) {
+			return errorAt(data, findKey(data, at, "Source"), "package %s has an invalid Source %q; use a URL such as https://host/repo.git or git@host:repo.git", name, packageInfo.Source)
+		}
+		if packageInfo.Branch != "" && !hasVariables(packageInfo.Branch) && !validRef(packageInfo.Branch) {
+			return errorAt(data, findKey(data, at, "Branch"), "package %s has an invalid Branch %q", name, packageInfo.Branch)
+		}
+		if packageInfo.Archive != "" && !hasVariables(packageInfo.Archive) && !validArchive(packageInfo.Archive) {
+			return errorAt(data, findKey(data, at, "Archive"), "package %s has an invalid Archive %q; use an http, https, s3 or gs URL", name, packageInfo.Archive)
+		}
+		if packageInfo.Revision != "" && !validRef(packageInfo.Revision) {
+			return errorAt(data, findKey(data, at, "Revision"), "package %s has an invalid Revision %q", name, packageInfo.Revision)
+		}
+	}
+
+	names = names[:0]
+	for name := range m.Binaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	binariesAt := findKey(data, 0, "Binaries")
+	for _, name := range names {
+		binary := m.Binaries[name]
+		at := findKey(data, binariesAt, name)
+		if binary == nil {
+			return errorAt(data, at, "binary %s must be an object, not null", name)
+		}
+		if name == "" || strings.ContainsAny(name, "/\\ \t\n") {
+			return errorAt(data, at, "%q is not a valid binary name; use the file name to install it as", name)
+		}
+		if parts := strings.Split(binary.Repository, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return errorAt(data, findKey(data, at, "Repository"), "binary %s has an invalid Repository %q; use the GitHub repository that publishes it, as owner/repo", name, binary.Repository)
+		}
+		if binary.Tag == "" || !validRef(binary.Tag) {
+			return errorAt(data, findKey(data, at, "Tag"), "binary %s has an invalid Tag %q", name, binary.Tag)
+		}
+		if binary.Asset == "" || strings.Contains(binary.Asset, "/") {
+			return errorAt(data, findKey(data, at, "Asset"), "binary %s has an invalid Asset %q; use the file name of the release asset", name, binary.Asset)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Removes the git metadata of the installed packages, with -no_vcs_metadata.
+// They are left as if installed from archives, so later runs verify them by
+// the hash of their files. Packages whose files differ from their revision's,
+// because of exclusions, rewritten imports or submodules, keep it so their
+// hashes still match.
+func removeVcsMetadata(packages []*manifest.Package) {
+	kept := []string{}
+	removed := 0
+	for _, packageInfo := range packages {
+		git := GitRepositoryFromPackage(packageInfo)
+		if _, ok := vcs.TarballRevision(git.RepoPath); ok || !git.IsCloned() {
+			continue
+		}
+		if modifiesCheckout(packageInfo) || git.UsesSubmodules(packageInfo) {
+			kept = append(kept, packageInfo.Name)
+			continue
+		}
+		if err := git.RemoveMetadata(); err != nil {
+			warnf("could not remove the git metadata of %s: %v", packageInfo.Name, err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		fmt.Fprintf(os.Stdout, "removed the git metadata of %d packages\n", removed)
+	}
+	if len(kept) > 0 {
+		warnf("kept the git metadata of %s, whose files can't be verified without it", strings.Join(kept, ", "))
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Returns the directories at or below dir that have a package file, dir first
+// and the rest in path order. Hidden directories, vendor and testdata are
+// skipped.
+func findManifestDirs(dir string) []string {
+	dirs := []string{}
+	filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if file != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "vendor" || name == "testdata") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(file, manifest.PACKAGE_FILE)); err == nil {
+			dirs = append(dirs, file)
+		}
+		return nil
+	})
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i] == dir && dirs[j] != dir })
+	return dirs
+}
+
+// Installs the locked packages of every project under the current directory
+// into one workspace. Each project's packages are added to root under a node
+// for the project, so conflicts between projects are found like any others
+// and say which project asked for what. Returns the conflict resolutions
+// recorded in the lockfiles, the outermost project's first.
+func installManifests(root *resolve.Node) map[string]*manifest.Resolution {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	dirs := findManifestDirs(currentDir)
+	if len(dirs) == 0 {
+		panic(errors.New(fmt.Sprintf("no %s found under %s", manifest.PACKAGE_FILE, currentDir)))
+	}
+
+	lockManifests := make([]*manifest.Manifest, len(dirs))
+	combined := &manifest.Manifest{Packages: map[string]*manifest.Package{}}
+	for i, dir := range dirs {
+		lockFile := filepath.Join(dir, manifest.LOCK_FILE)
+		if _, err := os.Stat(lockFile); err != nil {
+			panic(errors.New(fmt.Sprintf("%s has no %s; run deliver update there first", dir, manifest.LOCK_FILE)))
+		}
+		lockManifests[i] = loadManifest(lockFile)
+		checkProjectLockIsCurrent(dir, lockManifests[i])
+		for name, packageInfo := range lockManifests[i].Packages {
+			combined.Packages[dir+" "+name] = packageInfo
+		}
+	}
+	preflightHosts(combined)
+
+	resolutions := map[string]*manifest.Resolution{}
+	for i, dir := range dirs {
+		lockManifest := lockManifests[i]
+		for key, resolution := range lockManifest.Resolutions {
+			if _, ok := resolutions[key]; !ok {
+				resolutions[key] = resolution
+			}
+		}
+		rel, _ := filepath.Rel(currentDir, dir)
+		fmt.Fprintf(os.Stdout, "installing the packages of %s\n", colorize(os.Stdout, COLOR_BOLD, rel))
+		project := resolve.NewNode(&manifest.Package{Source: dir})
+		selected := selectPackages(lockManifest)
+		downloadPackages(project, selected)
+		root.Adopt(project)
+		verifyHashes(selected)
+		installBinaries(lockManifest, nil)
+
+		if lockManifest.HasRepository() {
+			// Each project's directories are relative to it.
+			links := &manifest.Manifest{Repositories: map[string]string{}}
+			for importPath, linkDir := range lockManifest.Links() {
+				links.Repositories[importPath] = filepath.Join(dir, linkDir)
+			}
+			createWorkspaceSymlinks(links)
+		}
+	}
+	return resolutions
+}
+
+// Warns if a nested project's lockfile is out of date with its package file,
+// or fails with -strict_lock, as checkLockIsCurrent does for the project in
+// the current directory.
+func checkProjectLockIsCurrent(dir string, lockManifest *manifest.Manifest) {
+	packageFile := filepath.Join(dir, manifest.PACKAGE_FILE)
+	packageManifest, err := manifest.Load(packageFile)
+	if err != nil {
+		panic(err)
+	}
+	// The lockfile was loaded with its variables expanded.
+	if err := packageManifest.ExpandVariables(); err != nil {
+		panic(errors.New(fmt.Sprintf("%s: %v", packageFile, err)))
+	}
+	problems := staleLockProblems(packageManifest, lockManifest, true)
+	if len(problems) == 0 {
+		return
+	}
+	for _, problem := range problems {
+		warnf("%s: %s", dir, problem)
+	}
+	if *strictLock {
+		panic(errors.New(fmt.Sprintf("%s in %s is out of date with %s. Run deliver update there to update it.", manifest.LOCK_FILE, dir, manifest.PACKAGE_FILE)))
+	}
+	warnf("%s in %s is out of date with %s; run deliver update there to update it", manifest.LOCK_FILE, dir, manifest.PACKAGE_FILE)
+}
+
+// Installs every project under the current directory into a workspace of its
+// own, by running deliver install in the project's directory with the same
+// flags. The workspaces share the cache of bare repositories, which
+// -reference_cache is turned on for unless a flag or setting says otherwise.
+func installManifestsSeparately() {
+	if !*useDeliverWorkspace {
+		panic(errors.New("install -each-manifest needs -deliver_workspace, so each project has a workspace of its own"))
+	}
+	currentDir, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	dirs := findManifestDirs(currentDir)
+	if len(dirs) == 0 {
+		panic(errors.New(fmt.Sprintf("no %s found under %s", manifest.PACKAGE_FILE, currentDir)))
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
+	}
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	globalArgs := append([]string{}, os.Args[1:len(os.Args)-len(flag.Args())]...)
+	if !given["reference_cache"] && !*useWorktrees {
+		globalArgs = append(globalArgs, "-reference_cache")
+	}
+	installArgs := []string{"install"}
+	if len(installExcept) > 0 {
+		names := []string{}
+		for name := range installExcept {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		installArgs = append(installArgs, "-except", strings.Join(names, ","))
+	}
+
+	failed := []string{}
+	for _, dir := range dirs {
+		rel, _ := filepath.Rel(currentDir, dir)
+		fmt.Fprintf(os.Stdout, "installing %s into its own workspace\n", colorize(os.Stdout, COLOR_BOLD, rel))
+		cmd := exec.Command(executable, append(append([]string{}, globalArgs...), installArgs...)...)
+		cmd.Dir = dir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			warnf("installing %s failed: %v", rel, err)
+			failed = append(failed, rel)
+		}
+	}
+	if len(failed) > 0 {
+		panic(errors.New(fmt.Sprintf("%d of %d projects failed to install: %s", len(failed), len(dirs), strings.Join(failed, ", "))))
+	}
+}
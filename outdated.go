@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+const (
+	NOTIFY_STDOUT_JSON = "stdout-json"
+	NOTIFY_SLACK       = "slack://"
+)
+
+// A locked package with a newer revision or release available, or with
+// advisories against its locked revision.
+type outdatedPackage struct {
+	Package string
+	Branch  string
+	Locked  string
+	// The tip of the branch, if it has moved on.
+	Latest string `json:",omitempty"`
+	// The release tag of the locked revision, and the newest release, if the
+	// package is on a tag and a newer one exists.
+	LockedTag  string          `json:",omitempty"`
+	LatestTag  string          `json:",omitempty"`
+	Owners     []string        `json:",omitempty"`
+	Advisories []*vcs.Advisory `json:",omitempty"`
+}
+
+// What outdated found, as sent to -notify targets.
+type outdatedDigest struct {
+	Project  string
+	Time     time.Time
+	Packages []*outdatedPackage
+}
+
+// Checks a locked package for a newer revision on its branch, a newer release
+// tag, and advisories. Returns nil if it has none of them.
+func checkOutdated(packageInfo *manifest.Package, advisories bool) (*outdatedPackage, error) {
+	git := GitRepositoryFromPackage(packageInfo)
+	result := &outdatedPackage{Package: packageInfo.Name, Branch: packageInfo.GetBranch(), Locked: packageInfo.Revision, Owners: packageInfo.GetOwners()}
+
+	tip, err := git.RemoteRevision(packageInfo.GetBranch())
+	if err != nil {
+		return nil, err
+	}
+	if tip != packageInfo.Revision {
+		result.Latest = tip
+	}
+	tags, err := git.RemoteTags()
+	if err != nil {
+		return nil, err
+	}
+	lockedTag, current := newestTag(tags, func(tag string, v *vcs.Version) bool {
+		return tags[tag] == packageInfo.Revision
+	})
+	if current != nil {
+		latestTag, _ := newestTag(tags, func(tag string, v *vcs.Version) bool {
+			return !v.Less(current) && inScope(current, v)
+		})
+		if latestTag != lockedTag {
+			result.LockedTag, result.LatestTag = lockedTag, latestTag
+		}
+	}
+	if advisories {
+		if result.Advisories, err = vcs.AdvisoriesFor(packageInfo.Revision); err != nil {
+			// The updates are still worth reporting.
+			warnf("could not look up advisories for %s: %v", packageInfo.Name, err)
+		}
+	}
+
+	if result.Latest == "" && result.LatestTag == "" && len(result.Advisories) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// Returns a line describing the update available for a package, such as
+// "v1.2.0 -> v1.3.0" or "master 1a2b3c4d5e6f -> 6f5e4d3c2b1a".
+func (p *outdatedPackage) update() string {
+	switch {
+	case p.LatestTag != "":
+		return p.LockedTag + " -> " + p.LatestTag
+	case p.Latest != "":
+		return fmt.Sprintf("%s %s -> %s", p.Branch, vcs.ShortRevision(p.Locked), vcs.ShortRevision(p.Latest))
+	}
+	return "up to date"
+}
+
+// Formats the digest as a Slack message.
+func slackMessage(digest *outdatedDigest) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d dependencies of %s have updates or advisories*\n", len(digest.Packages), digest.Project)
+	for _, p := range digest.Packages {
+		fmt.Fprintf(&buf, "• `%s`: %s", p.Package, p.update())
+		if len(p.Owners) > 0 {
+			fmt.Fprintf(&buf, " (%s)", strings.Join(p.Owners, " "))
+		}
+		fmt.Fprintln(&buf)
+		for _, advisory := range p.Advisories {
+			fmt.Fprintf(&buf, "    :warning: <%s|%s> %s\n", advisory.Link, advisory.ID, advisory.Summary)
+		}
+	}
+	return buf.String()
+}
+
+// Posts a JSON payload to a webhook.
+func postWebhook(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New(fmt.Sprintf("%s: %s", url, resp.Status))
+	}
+	return nil
+}
+
+// Lists the locked packages that have newer revisions or releases, or
+// advisories against their locked revisions. With -notify, sends the digest
+// to stdout as JSON, to a Slack webhook or to another webhook instead, for a
+// cron job.
+func outdatedCommand(args []string) {
+	flags := flag.NewFlagSet("outdated", flag.ExitOnError)
+	notify := flags.String("notify", "", "where to send the digest: stdout-json, a Slack incoming webhook as slack://hooks.slack.com/services/..., or an http(s) URL to post the JSON digest to")
+	advisories := flags.Bool("advisories", false, "look up advisories against the locked revisions in the OSV database. Sends every locked revision to api.osv.dev")
+	flags.Parse(args)
+	notifyUrl := ""
+	switch {
+	case *notify == "" || *notify == NOTIFY_STDOUT_JSON:
+	case strings.HasPrefix(*notify, NOTIFY_SLACK):
+		notifyUrl = "https://" + strings.TrimPrefix(*notify, NOTIFY_SLACK)
+	case strings.HasPrefix(*notify, "https://") || strings.HasPrefix(*notify, "http://"):
+		notifyUrl = *notify
+	default:
+		panic(errors.New(fmt.Sprintf("invalid -notify %q: must be %s, slack://... or an http(s) URL", *notify, NOTIFY_STDOUT_JSON)))
+	}
+
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	digest := &outdatedDigest{Project: lockManifest.Repository, Time: time.Now().UTC(), Packages: []*outdatedPackage{}}
+	if digest.Project == "" {
+		dir, _ := os.Getwd()
+		digest.Project = filepath.Base(dir)
+	}
+	names := []string{}
+	for name := range lockManifest.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	failed := 0
+	for _, name := range names {
+		result, err := checkOutdated(lockManifest.Packages[name], *advisories)
+		if err != nil {
+			warnf("could not check %s: %v", name, err)
+			failed++
+			continue
+		}
+		if result != nil {
+			digest.Packages = append(digest.Packages, result)
+		}
+	}
+
+	switch {
+	case *notify == NOTIFY_STDOUT_JSON:
+		data, err := json.MarshalIndent(digest, "", "\t")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", data)
+	case notifyUrl != "":
+		if len(digest.Packages) == 0 {
+			// Stay quiet when there is nothing to do.
+			if failed == 0 {
+				fmt.Fprintf(os.Stdout, "all %d packages are up to date; nothing was sent\n", len(names))
+			}
+			break
+		}
+		var payload interface{} = digest
+		if strings.HasPrefix(*notify, NOTIFY_SLACK) {
+			payload = map[string]string{"text": slackMessage(digest)}
+		}
+		if err := postWebhook(notifyUrl, payload); err != nil {
+			panic(errors.New(fmt.Sprintf("could not send the digest: %v", err)))
+		}
+		fmt.Fprintf(os.Stdout, "sent %d packages with updates or advisories to %s\n", len(digest.Packages), strings.SplitN(*notify, "/", 4)[2])
+	case len(digest.Packages) == 0:
+		if failed > 0 {
+			break
+		}
+		fmt.Fprintf(os.Stdout, "all %d packages are up to date\n", len(names))
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "PACKAGE\tUPDATE\tADVISORIES\tOWNERS\n")
+		for _, p := range digest.Packages {
+			ids := []string{}
+			for _, advisory := range p.Advisories {
+				ids = append(ids, advisory.ID)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Package, p.update(), strings.Join(ids, " "), strings.Join(p.Owners, " "))
+		}
+		w.Flush()
+	}
+	if failed > 0 {
+		panic(errors.New(fmt.Sprintf("%d packages could not be checked", failed)))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Returns the strings sorted, without duplicates.
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// Returns " (owners: ...)" for a package with owners, to follow it in lists of
+// changes.
+func ownersSuffix(packageInfo *manifest.Package) string {
+	owners := packageInfo.GetOwners()
+	if len(owners) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (owners: %s)", strings.Join(owners, " "))
+}
+
+// Returns the direct dependencies of the project that pull node in, or node
+// itself if it is one.
+func directAncestors(root *resolve.Node, name string) []*resolve.Node {
+	ancestors := []*resolve.Node{}
+	seen := map[string]bool{}
+	for _, node := range root.FindAll(name) {
+		for node.Parent != nil && node.Parent != root {
+			node = node.Parent
+		}
+		if node.Parent == root && !seen[node.Package.Name] {
+			seen[node.Package.Name] = true
+			ancestors = append(ancestors, node)
+		}
+	}
+	return ancestors
+}
+
+// Prints who reviews changes to a package: its owners or, for a dependency
+// of dependencies, the owners of the direct dependencies that pull it in.
+func ownersCommand(root *resolve.Node, args []string) {
+	if len(args) != 1 {
+		panic(errors.New("usage: deliver owners <package>"))
+	}
+	name := args[0]
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	if packageInfo, ok := lockManifest.Packages[name]; ok {
+		owners := packageInfo.GetOwners()
+		if len(owners) == 0 {
+			fmt.Fprintf(os.Stdout, "%s has no owners; add Owners to its entry in %s\n", name, manifest.PACKAGE_FILE)
+			return
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", strings.Join(owners, " "))
+		return
+	}
+
+	lookupPackage(root, name)
+	owners := []string{}
+	through := []string{}
+	for _, ancestor := range directAncestors(root, name) {
+		// The lockfile's entry has the owners; the tree's may not.
+		if direct, ok := lockManifest.Packages[ancestor.Package.Name]; ok {
+			owners = append(owners, direct.GetOwners()...)
+		}
+		through = append(through, ancestor.Package.Name)
+	}
+	sort.Strings(through)
+	if owners = uniqueStrings(owners); len(owners) == 0 {
+		fmt.Fprintf(os.Stdout, "%s has no owners, and neither do %s, which pull it in\n", name, strings.Join(through, ", "))
+		return
+	}
+	fmt.Fprintf(os.Stdout, "%s (through %s)\n", strings.Join(owners, " "), strings.Join(through, ", "))
+}
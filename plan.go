@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+	"github.com/brettshollenberger/deliver/workspace"
+)
+
+// Kinds of operations in a plan.
+const (
+	ACTION_CLONE          = "clone"
+	ACTION_DOWNLOAD       = "download"
+	ACTION_FETCH          = "fetch"
+	ACTION_CHECKOUT       = "checkout"
+	ACTION_SYMLINK        = "symlink"
+	ACTION_WRITE_LOCKFILE = "write"
+)
+
+// A single change install or update would make to the workspace.
+type Operation struct {
+	Action  string
+	Package string `json:",omitempty"`
+	Source  string `json:",omitempty"`
+	Branch  string `json:",omitempty"`
+	Path    string
+	// Revision checked out now, if any.
+	From string `json:",omitempty"`
+	// Revision to check out, or the target of a symlink.
+	To string `json:",omitempty"`
+	// The package as it would be installed, with its import path, clone
+	// arguments and everything else that decides how it is checked out.
+	PackageInfo *manifest.Package `json:",omitempty"`
+}
+
+// Returns the package an operation installs, at the revision it checks out.
+func (op *Operation) packageInfo() *manifest.Package {
+	if op.PackageInfo == nil {
+		// Plans saved before the whole package was recorded.
+		return &manifest.Package{Name: op.Package, Source: op.Source, Branch: op.Branch, Revision: op.To}
+	}
+	packageInfo := *op.PackageInfo
+	packageInfo.Name = op.Package
+	packageInfo.Revision = op.To
+	return &packageInfo
+}
+
+// Everything install or update would do, worked out from the lockfiles, the
+// workspace and read-only queries of the remotes. Computing a plan changes
+// nothing.
+type Plan struct {
+	// Command line the plan was computed for, e.g. ["update", "example.com/a"].
+	Command    []string
+	Operations []*Operation
+	Conflicts  []*resolve.ConflictReport `json:",omitempty"`
+	// Lockfile to write, for update and install -sync.
+	Lockfile *manifest.Manifest `json:",omitempty"`
+	// Packages whose dependencies can't be known until they are downloaded.
+	Unknown []string `json:",omitempty"`
+}
+
+// Computes the plan for install or update, with an optional package name.
+func computePlan(root *resolve.Node, args []string) *Plan {
+	plan := &Plan{Command: args}
+	var manifestFile string
+	switch args[0] {
+	case "install":
+		manifestFile = manifest.LOCK_FILE
+	case "update":
+		manifestFile = manifest.PACKAGE_FILE
+	default:
+		panic(errors.New(fmt.Sprintf("cannot plan %s: only install and update can be planned", args[0])))
+	}
+	m := loadManifest(manifestFile)
+	detectRepository(m)
+	if args[0] == "update" {
+		applyUpdateScope(m)
+	}
+	var resolutions map[string]*manifest.Resolution
+	var synced []string
+	if args[0] == "install" {
+		resolutions = m.Resolutions
+		if installSync {
+			synced = syncNewPackages(m)
+		}
+		m = selectPackages(m)
+	}
+
+	if len(args) == 2 {
+		packageInfo, ok := m.Packages[args[1]]
+		if !ok {
+			panic(errors.New(fmt.Sprintf("Package %s not found in %s", args[1], manifestFile)))
+		}
+		root = plan.planPackage(packageInfo)
+	} else {
+		var names []string
+		for name := range m.Packages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !installExcept[name] {
+				root.AddChild(plan.planPackage(m.Packages[name]))
+			}
+		}
+	}
+
+	conflicts := findConflicts(root, resolutions)
+	for _, c := range conflicts {
+		plan.Conflicts = append(plan.Conflicts, c.Report())
+	}
+
+	packages := resolvedPackages(root, resolutions)
+	if root.Parent == nil && root.Package.Name != "" {
+		// A single package was planned.
+		packages = append([]*manifest.Package{root.Package}, packages...)
+	}
+	for _, packageInfo := range packages {
+		plan.planCheckout(packageInfo)
+	}
+
+	if len(args) == 1 {
+		for _, importPath := range m.LinkPaths() {
+			plan.planSymlink(importPath, getLinkTarget(m, importPath))
+		}
+	}
+
+	if len(synced) > 0 {
+		plan.Lockfile = &manifest.Manifest{Repository: m.Repository, Repositories: m.Repositories, Packages: map[string]*manifest.Package{}, Resolutions: m.Resolutions, Binaries: m.Binaries, HostKeys: m.HostKeys}
+		for _, child := range root.Children {
+			plan.Lockfile.Packages[child.Package.Name] = child.Package
+		}
+		plan.Operations = append(plan.Operations, &Operation{Action: ACTION_WRITE_LOCKFILE, Path: manifest.LOCK_FILE})
+	}
+
+	if args[0] == "update" {
+		if len(args) == 2 {
+			plan.Lockfile = loadManifest(manifest.LOCK_FILE)
+			plan.Lockfile.Packages[root.Package.Name] = root.Package
+		} else {
+			plan.Lockfile = &manifest.Manifest{Repository: m.Repository, Repositories: m.Repositories, Packages: map[string]*manifest.Package{}, Binaries: m.Binaries}
+			for _, child := range root.Children {
+				plan.Lockfile.Packages[child.Package.Name] = child.Package
+			}
+			plan.Lockfile.Resolutions = resolve.ResolutionsFor(conflicts)
+		}
+		plan.Operations = append(plan.Operations, &Operation{Action: ACTION_WRITE_LOCKFILE, Path: manifest.LOCK_FILE})
+	}
+	return plan
+}
+
+// Returns the tree for a package at the revision it would be installed at.
+// Branch tips are asked of the remote, and dependencies are read from the
+// package's lockfile at that revision if it is available locally.
+func (p *Plan) planPackage(packageInfo *manifest.Package) *resolve.Node {
+	planned := *packageInfo
+	git := GitRepositoryFromPackage(&planned)
+	if !planned.HasRevision() {
+		revision, err := git.RemoteRevision(planned.GetBranch())
+		if err != nil {
+			panic(err)
+		}
+		planned.Revision = revision
+	}
+	node := resolve.NewNode(&planned)
+
+	var lockfile string
+	found := false
+	if current, ok := vcs.TarballRevision(git.RepoPath); ok && current == planned.Revision {
+		data, err := ioutil.ReadFile(filepath.Join(git.RepoPath, manifest.LOCK_FILE))
+		lockfile, found = string(data), err == nil
+	} else {
+		var err error
+		if lockfile, found, err = git.ReadFileAt(planned.Revision, manifest.LOCK_FILE); err != nil {
+			p.Unknown = append(p.Unknown, planned.Name)
+			return node
+		}
+	}
+	if found {
+		m, err := manifest.ParseLockfile([]byte(lockfile), fmt.Sprintf("%s of %s", manifest.LOCK_FILE, planned.Name))
+		if err != nil {
+			panic(errors.New(fmt.Sprintf("%s of %s: %v", manifest.LOCK_FILE, planned.Name, err)))
+		}
+		var names []string
+		for name := range m.Packages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !installExcept[name] {
+				node.AddChild(p.planPackage(m.Packages[name]))
+			}
+		}
+	}
+	return node
+}
+
+// Adds the operations that bring a package to its planned revision.
+func (p *Plan) planCheckout(packageInfo *manifest.Package) {
+	git := GitRepositoryFromPackage(packageInfo)
+	op := &Operation{
+		Package:     packageInfo.Name,
+		Source:      packageInfo.Source,
+		Branch:      packageInfo.GetBranch(),
+		Path:        git.RepoPath,
+		To:          packageInfo.Revision,
+		PackageInfo: packageInfo,
+	}
+
+	current, installed := getInstalledRevision(git)
+	if installed && current == packageInfo.Revision {
+		return
+	}
+	op.From = current
+
+	_, isTarball := vcs.TarballRevision(git.RepoPath)
+	switch {
+	case *useTarballs && !git.IsCloned() && vcs.CanDownloadTarball(packageInfo):
+		op.Action = ACTION_DOWNLOAD
+	case !git.IsCloned() || isTarball:
+		op.Action = ACTION_CLONE
+	default:
+		if !git.HasCommit(packageInfo.Revision) {
+			fetch := *op
+			fetch.Action = ACTION_FETCH
+			p.Operations = append(p.Operations, &fetch)
+		}
+		op.Action = ACTION_CHECKOUT
+	}
+	p.Operations = append(p.Operations, op)
+}
+
+// Adds a symlink from the workspace to the project, if it is missing.
+func (p *Plan) planSymlink(importPath string, projectDir string) {
+	linkPath := workspace.PackageDir(getWorkspacePath(), importPath)
+	if same, err := workspace.PathCompare(linkPath, projectDir); err == nil && same {
+		return
+	}
+	p.Operations = append(p.Operations, &Operation{Action: ACTION_SYMLINK, Package: importPath, Path: linkPath, To: projectDir})
+}
+
+// Prints the plan for people.
+func (p *Plan) Print(w io.Writer) {
+	for _, op := range p.Operations {
+		switch op.Action {
+		case ACTION_CLONE, ACTION_DOWNLOAD:
+			fmt.Fprintf(w, "%-9s %s at %s/%s -> %s\n", op.Action, op.Package, op.Branch, op.To, op.Path)
+		case ACTION_FETCH:
+			fmt.Fprintf(w, "%-9s %s from %s\n", op.Action, op.Package, op.Source)
+		case ACTION_CHECKOUT:
+			fmt.Fprintf(w, "%-9s %s %s -> %s\n", op.Action, op.Package, op.From, op.To)
+		case ACTION_SYMLINK:
+			fmt.Fprintf(w, "%-9s %s -> %s\n", op.Action, op.Path, op.To)
+		case ACTION_WRITE_LOCKFILE:
+			fmt.Fprintf(w, "%-9s %s\n", op.Action, op.Path)
+			previous, err := manifest.Load(manifest.LOCK_FILE)
+			if err != nil {
+				previous = &manifest.Manifest{}
+			}
+			printLockChanges(previous, p.Lockfile)
+		}
+	}
+
+	for _, c := range p.Conflicts {
+		fmt.Fprintf(w, "conflict  %s: %s chosen from %d versions\n", c.Source, c.Chosen, len(c.Candidates))
+	}
+	for _, name := range p.Unknown {
+		warnf("the dependencies of %s aren't known until it is fetched, so they aren't part of this plan", name)
+	}
+	if len(p.Operations) == 0 {
+		fmt.Fprintf(w, "Nothing to do.\n")
+	} else {
+		fmt.Fprintf(w, "%d operations planned. Nothing was changed.\n", len(p.Operations))
+	}
+}
+
+// Writes the plan to a file for apply.
+func (p *Plan) WriteToFile(file string) error {
+	data, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// Reads a plan written by WriteToFile.
+func loadPlan(file string) *Plan {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+	plan := &Plan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		panic(errors.New(fmt.Sprintf("%s is not a valid plan: %v", file, err)))
+	}
+	if plan.Lockfile != nil {
+		for name, packageInfo := range plan.Lockfile.Packages {
+			packageInfo.Name = name
+		}
+		if err := plan.Lockfile.ExpandVariables(); err != nil {
+			panic(errors.New(fmt.Sprintf("%s: %v", file, err)))
+		}
+	}
+	for _, op := range plan.Operations {
+		// Saved with their variables, like the lockfile.
+		if op.PackageInfo != nil {
+			op.PackageInfo.Name = op.Package
+			if err := op.PackageInfo.ExpandVariables(); err != nil {
+				panic(errors.New(fmt.Sprintf("%s: %v", file, err)))
+			}
+		}
+	}
+	return plan
+}
+
+// Computes the plan for install or update, prints it and saves it for apply.
+func planCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	planFile := flags.String("o", "plan.json", "file to write the plan to")
+	flags.Parse(args)
+	command := flags.Args()
+	if len(command) < 1 || len(command) > 2 || (command[0] != "install" && command[0] != "update") {
+		panic(errors.New("Usage: deliver plan [-o plan.json] install|update [package]"))
+	}
+
+	plan := computePlan(root, command)
+	plan.Print(os.Stdout)
+	if err := plan.WriteToFile(*planFile); err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(os.Stdout, "Saved the plan to %s. Run `deliver apply %s` to carry it out.\n", *planFile, *planFile)
+}
+
+// Fails if a package was changed since the plan was made, since its
+// operations may no longer be right.
+func checkPlanIsCurrent(plan *Plan) {
+	for _, op := range plan.Operations {
+		if op.Package == "" || op.Action == ACTION_SYMLINK {
+			continue
+		}
+		git := GitRepositoryFromPackage(op.packageInfo())
+		current, _ := getInstalledRevision(git)
+		if op.Action == ACTION_CHECKOUT && current == op.To {
+			// Already applied, e.g. by an apply that failed part way.
+			continue
+		}
+		if current != op.From {
+			panic(errors.New(fmt.Sprintf("The plan is out of date: %s is at %q, but the plan expected %q. Run deliver plan again.", op.Package, current, op.From)))
+		}
+	}
+}
+
+// Carries out a saved plan: exactly its operations, in order.
+func applyCommand(args []string) {
+	if len(args) != 1 {
+		panic(errors.New("Usage: deliver apply <plan.json>"))
+	}
+	plan := loadPlan(args[0])
+	checkPlanIsCurrent(plan)
+
+	for _, op := range plan.Operations {
+		fmt.Fprintf(os.Stdout, "%-9s %s\n", op.Action, op.Path)
+		packageInfo := op.packageInfo()
+		git := GitRepositoryFromPackage(packageInfo)
+		var err error
+
+		switch op.Action {
+		case ACTION_DOWNLOAD:
+			var ok bool
+			if ok, err = vcs.DownloadTarball(packageInfo, op.Path); err == nil && !ok {
+				err = errors.New(fmt.Sprintf("%s can no longer be downloaded as a source archive", op.Package))
+			}
+		case ACTION_CLONE:
+			checkHostKey(packageInfo)
+			// Replace a package installed from a source archive, or a
+			// clone that was interrupted. Anything else is left alone.
+			if _, isTarball := vcs.TarballRevision(op.Path); isTarball || git.IsBroken() {
+				if err = os.RemoveAll(op.Path); err != nil {
+					break
+				}
+			} else if entries, _ := ioutil.ReadDir(op.Path); len(entries) > 0 {
+				err = errors.New(fmt.Sprintf("%s is in the way, and deliver didn't install it", op.Path))
+				break
+			}
+			if err = os.MkdirAll(op.Path, 0755); err != nil {
+				break
+			}
+			if err = git.Clone(op.Path, packageInfo.GetBranch()); err == nil {
+				err = git.Update(packageInfo)
+			}
+		case ACTION_FETCH:
+			checkHostKey(packageInfo)
+			err = git.Fetch()
+		case ACTION_CHECKOUT:
+			err = git.Update(packageInfo)
+		case ACTION_SYMLINK:
+			_, err = workspace.CreateSymlink(getWorkspacePath(), op.Package, op.To)
+		case ACTION_WRITE_LOCKFILE:
+			// The checksums of packages and binaries are only known
+			// now that they are installed.
+			previous, _ := manifest.Load(op.Path)
+			installBinaries(plan.Lockfile, previous)
+			completeLockfile(plan.Lockfile)
+			writeManifest(plan.Lockfile, op.Path)
+			emitEvent(&Event{Type: EVENT_LOCKFILE_WRITTEN, Path: op.Path})
+		default:
+			err = errors.New(fmt.Sprintf("unknown operation %q", op.Action))
+		}
+		if err != nil {
+			panic(errors.New(fmt.Sprintf("%s %s failed: %v", op.Action, op.Path, err)))
+		}
+	}
+	fmt.Fprintf(os.Stdout, "Applied %d operations.\n", len(plan.Operations))
+}
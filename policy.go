@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// The organization's rules for dependencies, committed next to the package
+// file.
+const POLICY_FILE string = "deliver-policy.json"
+
+type policy struct {
+	// Hosts sources may be on, such as github.com. If empty, any host is
+	// allowed.
+	AllowedHosts []string
+	// Packages that may not be used, each with the reason. A name ending in
+	// /... bans every package under it.
+	BannedPackages map[string]string
+	// Revisions that packages must be at or descend from, such as the first
+	// one with a security fix.
+	MinimumRevisions map[string]string
+	// SPDX identifiers of the licenses packages may have. If empty, any
+	// license is allowed. "unknown" allows packages whose license can't be
+	// identified.
+	AllowedLicenses []string
+}
+
+// Reads the policy file. Returns nil if there is none.
+func loadPolicy() *policy {
+	data, err := ioutil.ReadFile(POLICY_FILE)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+	p := &policy{}
+	decoder := json.NewDecoder(bytes.NewReader(manifest.StripComments(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(p); err != nil {
+		panic(errors.New(fmt.Sprintf("%s: %v", POLICY_FILE, err)))
+	}
+	return p
+}
+
+// Returns the reason a package is banned, if it is.
+func (p *policy) banReason(name string) (string, bool) {
+	for banned, reason := range p.BannedPackages {
+		if name == banned || strings.HasSuffix(banned, "/...") && strings.HasPrefix(name, strings.TrimSuffix(banned, "...")) {
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the ways a package breaks the policy.
+func (p *policy) violations(packageInfo *manifest.Package) []string {
+	problems := []string{}
+	if reason, ok := p.banReason(packageInfo.Name); ok {
+		problems = append(problems, fmt.Sprintf("is banned: %s", reason))
+	}
+	if len(p.AllowedHosts) > 0 {
+		location, ok := vcs.ParseSource(packageInfo.Source)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("source %s is not on an allowed host", packageInfo.Source))
+		} else if !contains(p.AllowedHosts, location.Host) {
+			problems = append(problems, fmt.Sprintf("source %s is on %s, which is not an allowed host", packageInfo.Source, location.Host))
+		}
+	}
+
+	git := GitRepositoryFromPackage(packageInfo)
+	_, fromArchive := vcs.TarballRevision(git.RepoPath)
+	if minimum, ok := p.MinimumRevisions[packageInfo.Name]; ok {
+		switch {
+		case fromArchive:
+			warnf("%s was installed from an archive, so it can't be checked against its minimum revision %s", packageInfo.Name, minimum)
+		case !packageInfo.HasRevision():
+			problems = append(problems, fmt.Sprintf("has no revision to check against the minimum revision %s", minimum))
+		default:
+			ok, err := git.IsAncestor(minimum, packageInfo.Revision)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("can't be checked against the minimum revision %s: %v", minimum, err))
+			} else if !ok {
+				problems = append(problems, fmt.Sprintf("is at %s, which does not include the minimum revision %s", packageInfo.Revision, minimum))
+			}
+		}
+	}
+
+	if len(p.AllowedLicenses) > 0 {
+		license := packageLicense(git, packageInfo, fromArchive)
+		if !contains(p.AllowedLicenses, license) {
+			problems = append(problems, fmt.Sprintf("has license %s, which is not allowed", license))
+		}
+	}
+	return problems
+}
+
+// Files a package's license may be in.
+var licenseFiles = []string{"LICENSE", "LICENSE.md", "LICENSE.txt", "LICENCE", "COPYING", "COPYING.md", "COPYING.txt"}
+
+// Returns the SPDX identifier of the license of the package's revision, or
+// "unknown".
+func packageLicense(git *vcs.GitRepository, packageInfo *manifest.Package, fromArchive bool) string {
+	for _, file := range licenseFiles {
+		var text string
+		if fromArchive || !packageInfo.HasRevision() {
+			data, err := ioutil.ReadFile(path.Join(git.RepoPath, file))
+			if err != nil {
+				continue
+			}
+			text = string(data)
+		} else {
+			var ok bool
+			var err error
+			if text, ok, err = git.ReadFileAt(packageInfo.Revision, file); err != nil || !ok {
+				continue
+			}
+		}
+		return identifyLicense(text)
+	}
+	return "unknown"
+}
+
+// Phrases that identify common licenses, most specific first.
+var licensePhrases = []struct {
+	id      string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"GNU AFFERO GENERAL PUBLIC LICENSE"}},
+	{"LGPL-3.0", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-2.1", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 2.1"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "2.0"}},
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+	{"ISC", []string{"Permission to use, copy, modify, and/or distribute this software for any"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"Unlicense", []string{"This is free and unencumbered software released into the public domain"}},
+}
+
+// Returns the SPDX identifier of the license in text, or "unknown".
+func identifyLicense(text string) string {
+	// Line breaks fall in different places in different copies.
+	text = strings.Join(strings.Fields(text), " ")
+	for _, license := range licensePhrases {
+		found := true
+		for _, phrase := range license.phrases {
+			if !strings.Contains(text, phrase) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return license.id
+		}
+	}
+	return "unknown"
+}
+
+// Checks the resolved set of packages against the policy file, if there is
+// one, and fails with a report of every violation.
+func enforcePolicy(root *resolve.Node, conflicts []*resolve.Conflicts) {
+	p := loadPolicy()
+	if p == nil {
+		return
+	}
+	packages := root.Packages()
+	if root.Parent == nil && root.Package.Name != "" {
+		// A single package was installed.
+		packages = append([]*manifest.Package{root.Package}, packages...)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name < packages[j].Name
+	})
+
+	count := 0
+	for _, packageInfo := range packages {
+		for _, c := range conflicts {
+			if c.Covers(packageInfo) {
+				packageInfo = c.Chosen.Package
+			}
+		}
+		problems := p.violations(packageInfo)
+		if len(problems) > 0 && count == 0 {
+			fmt.Fprintf(os.Stderr, "%s\n", colorize(os.Stderr, COLOR_RED, "Policy violations ("+POLICY_FILE+"):"))
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  %s %s\n", colorize(os.Stderr, COLOR_BOLD, packageInfo.Name), problem)
+		}
+		count += len(problems)
+	}
+	if count > 0 {
+		panic(errors.New(fmt.Sprintf("%d policy violations found", count)))
+	}
+}
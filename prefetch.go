@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+	"github.com/brettshollenberger/deliver/workspace"
+)
+
+// Brings the shared cache's mirror of every locked package up to date,
+// including the dependencies named in the lockfiles of locked revisions,
+// without touching the workspace. Meant for a nightly job, so that installs
+// during the day find everything in the cache.
+func prefetchCommand() {
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	if vcs.NetworkDenied {
+		panic(errors.New("prefetch needs the network, but -network=deny"))
+	}
+
+	queue := []*manifest.Package{}
+	for _, packageInfo := range lockManifest.Packages {
+		queue = append(queue, packageInfo)
+	}
+	seen := map[string]bool{}
+	fetched := map[string]bool{}
+	failed := 0
+	for len(queue) > 0 {
+		packageInfo := queue[0]
+		queue = queue[1:]
+		key := packageInfo.Source + "@" + packageInfo.GetRevision()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		cachePath := workspace.CachePath(*rootWorkspaceDir, packageInfo.Source)
+		if !fetched[cachePath] {
+			fetched[cachePath] = true
+			fmt.Fprintf(os.Stdout, "fetching %s -> %s\n", colorize(os.Stdout, COLOR_BOLD, packageInfo.Name), cachePath)
+			vcs.ChooseProtocol(packageInfo.Source)
+			if err := vcs.UpdateCache(packageInfo.Source, cachePath); err != nil {
+				warnf("could not fetch %s: %v", packageInfo.Name, err)
+				failed++
+				continue
+			}
+		}
+		if !packageInfo.HasRevision() || *noRun {
+			continue
+		}
+
+		// The mirror is read as a bare repository.
+		git := &vcs.GitRepository{Name: packageInfo.Name, RepoUrl: packageInfo.Source, RepoPath: cachePath, CachePath: cachePath}
+		data, ok, err := git.ReadFileAt(packageInfo.Revision, manifest.LOCK_FILE)
+		if err != nil {
+			warnf("%v", err)
+			failed++
+			continue
+		}
+		if !ok {
+			continue
+		}
+		dependencies, err := manifest.ParseLockfile([]byte(data), fmt.Sprintf("%s of %s at %s", manifest.LOCK_FILE, packageInfo.Name, packageInfo.Revision))
+		if err != nil {
+			warnf("%s of %s at %s: %v", manifest.LOCK_FILE, packageInfo.Name, packageInfo.Revision, err)
+			continue
+		}
+		for name, dependency := range dependencies.Packages {
+			if dependency.Source == "" {
+				if dependency.Source, err = vcs.ResolveSource(name); err != nil {
+					warnf("%s, a dependency of %s, has no Source, and none could be found: %v", name, packageInfo.Name, err)
+					continue
+				}
+			}
+			queue = append(queue, dependency)
+		}
+	}
+
+	if failed > 0 {
+		panic(errors.New(fmt.Sprintf("%d packages could not be prefetched", failed)))
+	}
+	fmt.Fprintf(os.Stdout, "prefetched %d repositories\n", len(fetched))
+}
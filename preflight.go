@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// How long the preflight waits for each host.
+const PREFLIGHT_TIMEOUT = 30 * time.Second
+
+// Checks, before a long install or update, that every host the manifest's
+// packages come from can be reached with the credentials at hand. One
+// package from each host is queried, all hosts at once. Fails with every
+// problem found, rather than partway through the install.
+func preflightHosts(m *manifest.Manifest) {
+	if !*preflight || vcs.NetworkDenied {
+		return
+	}
+
+	sources := map[string]string{}
+	for _, packageInfo := range m.Packages {
+		if packageInfo.Archive != "" && packageInfo.HasRevision() {
+			// Downloaded from the archive, not the source.
+			continue
+		}
+		location, ok := vcs.ParseSource(packageInfo.Source)
+		if !ok {
+			// Local repositories have no host to check.
+			continue
+		}
+		if existing, ok := sources[location.Host]; !ok || packageInfo.Source < existing {
+			sources[location.Host] = packageInfo.Source
+		}
+	}
+	if len(sources) == 0 {
+		return
+	}
+
+	hosts := len(sources)
+	var mutex sync.Mutex
+	var wait sync.WaitGroup
+	problems := []string{}
+
+	for _, source := range sources {
+		vcs.ChooseProtocol(source)
+	}
+	// Hosts that may ask for credentials are checked one at a time first, so
+	// their prompts don't overlap.
+	for host, source := range sources {
+		if location, _ := vcs.ParseSource(vcs.PreferredSource(source)); !credentialHelperInstalled || (location.Scheme != "https" && location.Scheme != "http") {
+			continue
+		}
+		if err := vcs.CheckRemote(source, PREFLIGHT_TIMEOUT, true); err != nil {
+			problems = append(problems, fmt.Sprintf("  %s (%s): %v", host, source, err))
+		}
+		delete(sources, host)
+	}
+	for host, source := range sources {
+		wait.Add(1)
+		go func(host, source string) {
+			defer wait.Done()
+			if err := vcs.CheckRemote(source, PREFLIGHT_TIMEOUT, false); err != nil {
+				mutex.Lock()
+				problems = append(problems, fmt.Sprintf("  %s (%s): %v", host, source, err))
+				mutex.Unlock()
+			}
+		}(host, source)
+	}
+	wait.Wait()
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		panic(errors.New(fmt.Sprintf("%d of %d package hosts can't be reached; check the network and your credentials, or pass -preflight=false to try anyway:\n%s",
+			len(problems), hosts, strings.Join(problems, "\n"))))
+	}
+	if *verbose {
+		fmt.Fprintf(os.Stdout, "all %d package hosts can be reached\n", hosts)
+	}
+}
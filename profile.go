@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Phases of installing a package that are timed for -profile.
+const (
+	PHASE_TARBALL      = "tarball"
+	PHASE_CLONE        = "clone"
+	PHASE_FETCH        = "fetch"
+	PHASE_CHECKOUT     = "checkout"
+	PHASE_DEPENDENCIES = "dependencies"
+)
+
+var profilePhases = []string{PHASE_TARBALL, PHASE_CLONE, PHASE_FETCH, PHASE_CHECKOUT, PHASE_DEPENDENCIES}
+
+// Time spent in each phase, by package name. A package installed more than
+// once (for example, while resolving a conflict) accumulates its times.
+var timings = map[string]map[string]time.Duration{}
+var timingsMutex sync.Mutex
+
+// Adds the time since start to a phase of a package.
+func recordTiming(packageName string, phase string, start time.Time) {
+	timingsMutex.Lock()
+	defer timingsMutex.Unlock()
+	if timings[packageName] == nil {
+		timings[packageName] = map[string]time.Duration{}
+	}
+	timings[packageName][phase] += time.Since(start)
+}
+
+// Time spent on the package itself, excluding its dependencies.
+func ownTime(phases map[string]time.Duration) time.Duration {
+	return phases[PHASE_TARBALL] + phases[PHASE_CLONE] + phases[PHASE_FETCH] + phases[PHASE_CHECKOUT]
+}
+
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "-"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// Prints the recorded timings, slowest package first. The dependencies column
+// includes the time spent installing the package's own dependencies.
+func printProfile(w io.Writer) {
+	timingsMutex.Lock()
+	defer timingsMutex.Unlock()
+
+	var names []string
+	var total time.Duration
+	for name, phases := range timings {
+		names = append(names, name)
+		total += ownTime(phases)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return ownTime(timings[names[i]]) > ownTime(timings[names[j]])
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "PACKAGE\t")
+	for _, phase := range profilePhases {
+		fmt.Fprintf(tw, "%s\t", phase)
+	}
+	fmt.Fprintf(tw, "total\t\n")
+	for _, name := range names {
+		fmt.Fprintf(tw, "%s\t", name)
+		for _, phase := range profilePhases {
+			fmt.Fprintf(tw, "%s\t", formatDuration(timings[name][phase]))
+		}
+		fmt.Fprintf(tw, "%s\t\n", formatDuration(ownTime(timings[name])))
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d packages, %s in total\n", len(names), formatDuration(total))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Returns the newest release tag reachable from HEAD, or "" if there is none.
+func previousReleaseTag() string {
+	tags := map[string]string{}
+	for _, tag := range strings.Fields(projectGit("tag", "--list", "--merged", "HEAD")) {
+		tags[tag] = tag
+	}
+	tag, _ := newestTag(tags, func(tag string, v *vcs.Version) bool { return true })
+	return tag
+}
+
+// Returns release notes for the commits since the previous tag: their
+// subjects, and the dependencies whose locked revisions changed.
+func releaseNotes(tag, previous string) string {
+	commits := "HEAD"
+	if previous != "" {
+		commits = previous + "..HEAD"
+	}
+	var notes bytes.Buffer
+	if previous == "" {
+		fmt.Fprintf(&notes, "%s\n", tag)
+	} else {
+		fmt.Fprintf(&notes, "%s (changes since %s)\n", tag, previous)
+	}
+	if log := projectGit("log", "--no-merges", "--format=- %s (%h)", commits); log != "" {
+		fmt.Fprintf(&notes, "\n%s\n", log)
+	}
+
+	next, err := manifest.Load(manifest.LOCK_FILE)
+	if err != nil || previous == "" {
+		return notes.String()
+	}
+	before := &manifest.Manifest{}
+	if data, err := vcs.ExecuteCommand("git", "show", previous+":"+manifest.LOCK_FILE); err == nil {
+		if parsed, err := manifest.ParseLockfile([]byte(data), previous+":"+manifest.LOCK_FILE); err == nil {
+			before = parsed
+		}
+	}
+	if changes := lockChanges(before, next); len(changes) > 0 {
+		fmt.Fprintf(&notes, "\nDependencies:\n")
+		for _, change := range changes {
+			fmt.Fprintf(&notes, "- %s\n", change.Summary())
+		}
+	}
+	return notes.String()
+}
+
+// Tags a release of the project: checks that the working tree is clean and
+// the lockfile current, then creates an annotated tag with release notes from
+// the commits since the previous release, and pushes it.
+func publishCommand(args []string) {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	sign := flags.Bool("sign", false, "sign the tag with git's configured signing key")
+	push := flags.Bool("push", true, "push the tag to -remote")
+	remote := flags.String("remote", "origin", "the remote to push the tag to")
+	notesFile := flags.String("notes", "", "also write the release notes to this file")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		panic(errors.New("usage: deliver publish [-sign] [-push=false] [-remote origin] [-notes file] vX.Y.Z"))
+	}
+	tag := flags.Arg(0)
+	v, ok := vcs.ParseVersion(tag)
+	if !ok || !strings.HasPrefix(tag, "v") {
+		panic(errors.New(fmt.Sprintf("%s is not a release version such as v1.2.3", tag)))
+	}
+
+	// The checks and release notes only read the repository, so -n need only
+	// keep the tag from being created and pushed.
+	vcs.DryRun = false
+	projectGit("rev-parse", "--git-dir")
+	if status := projectGit("status", "--porcelain"); status != "" {
+		panic(errors.New(fmt.Sprintf("the working tree has uncommitted changes; commit or stash them first:\n%s", status)))
+	}
+	if _, err := os.Stat(manifest.PACKAGE_FILE); err == nil {
+		packageManifest, err := loadPackageFile()
+		if err != nil {
+			panic(err)
+		}
+		lockManifest, err := manifest.Load(manifest.LOCK_FILE)
+		if err != nil {
+			panic(errors.New(fmt.Sprintf("a release needs a committed %s: %v", manifest.LOCK_FILE, err)))
+		}
+		if err := lockManifest.ExpandVariables(); err != nil {
+			panic(errors.New(fmt.Sprintf("%s: %v", manifest.LOCK_FILE, err)))
+		}
+		if problems := staleLockProblems(packageManifest, lockManifest, true); len(problems) > 0 {
+			panic(errors.New(fmt.Sprintf("%s is out of date; run deliver update and commit it first:\n%s", manifest.LOCK_FILE, strings.Join(problems, "\n"))))
+		}
+	}
+	if _, err := vcs.ExecuteCommand("git", "rev-parse", "--verify", "--quiet", "refs/tags/"+tag); err == nil {
+		panic(errors.New(fmt.Sprintf("tag %s already exists", tag)))
+	}
+	previous := previousReleaseTag()
+	if last, ok := vcs.ParseVersion(previous); ok && !last.Less(v) {
+		panic(errors.New(fmt.Sprintf("%s is not newer than the previous release, %s", tag, previous)))
+	}
+
+	notes := releaseNotes(tag, previous)
+	tagArgs := []string{"tag", "--annotate", "--cleanup=verbatim", "-m", notes, tag}
+	if *sign {
+		tagArgs[1] = "--sign"
+	}
+	if *noRun {
+		// Prints the commands instead of running them.
+		vcs.DryRun = true
+		projectGit(tagArgs...)
+		if *push {
+			projectGit("push", *remote, "refs/tags/"+tag)
+		}
+		return
+	}
+	projectGit(tagArgs...)
+	fmt.Fprintf(os.Stdout, "tagged %s\n\n%s", colorize(os.Stdout, COLOR_BOLD, tag), notes)
+	if *notesFile != "" {
+		if err := ioutil.WriteFile(*notesFile, []byte(notes), 0644); err != nil {
+			panic(err)
+		}
+	}
+	if !*push {
+		return
+	}
+	projectGit("push", *remote, "refs/tags/"+tag)
+	fmt.Fprintf(os.Stdout, "\npushed %s to %s\n", tag, *remote)
+}
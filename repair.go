@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Returns what is wrong with an installed package, and whether it has to be
+// cloned again to fix it. An empty problem means the package is fine.
+func diagnosePackage(git *vcs.GitRepository, packageInfo *manifest.Package) (problem string, reclone bool) {
+	if _, err := os.Stat(git.RepoPath); os.IsNotExist(err) {
+		return "not installed", true
+	}
+	if _, ok := vcs.TarballRevision(git.RepoPath); ok {
+		// Installed from a source archive, so there is no checkout to check.
+		return "", false
+	}
+	if !git.IsCloned() {
+		return "not a git checkout", true
+	}
+	if git.IsBroken() {
+		return "no commit checked out", true
+	}
+	if origin, err := git.OriginURL(); err != nil || origin != git.RepoUrl {
+		// Worktrees share the remote of the cache for their source.
+		return fmt.Sprintf("fetches from %s instead of %s", origin, git.RepoUrl), git.Worktree
+	}
+	current, err := git.CurrentRevision()
+	if err != nil {
+		return "cannot read the checked out revision", true
+	}
+	if packageInfo.HasRevision() && current != packageInfo.Revision {
+		return fmt.Sprintf("checked out at %s instead of %s", current, packageInfo.Revision), false
+	}
+	return "", false
+}
+
+// Finds packages in broken states and clones them again, or fixes their remote
+// and checks out their locked revisions.
+func repairCommand(root *resolve.Node, args []string) {
+	if len(args) > 1 {
+		panic(errors.New("Usage: deliver repair [package]"))
+	}
+	// Finding the problems only reads the checkouts, so -n need only keep
+	// them from being fixed.
+	vcs.DryRun = false
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+	packages := resolvedPackages(root, lockManifest.Resolutions)
+	if len(args) == 1 {
+		packageInfo, _ := lookupPackage(resolve.NewNode(root.Package), args[0])
+		packages = []*manifest.Package{packageInfo}
+	}
+
+	repaired := map[string]bool{}
+	// Installing the dependencies of a package can move packages that were
+	// already checked, so check again after any changes.
+	for pass := 0; pass < 3; pass++ {
+		changed := false
+		for _, packageInfo := range packages {
+			git := GitRepositoryFromPackage(packageInfo)
+			problem, reclone := diagnosePackage(git, packageInfo)
+			if problem == "" {
+				continue
+			}
+			repaired[packageInfo.Name] = true
+			fmt.Fprintf(os.Stdout, "%s: %s\n", colorize(os.Stdout, COLOR_BOLD, packageInfo.Name), problem)
+			if *noRun {
+				continue
+			}
+			changed = true
+
+			if reclone {
+				if err := os.RemoveAll(git.RepoPath); err != nil {
+					panic(err)
+				}
+				// Also installs the dependencies, which couldn't be read
+				// before.
+				downloadPackage(packageInfo)
+				continue
+			}
+			if origin, _ := git.OriginURL(); origin != git.RepoUrl {
+				if err := git.SetOriginURL(); err != nil {
+					panic(err)
+				}
+			}
+			checkoutPackage(git, packageInfo)
+			lockFile := path.Join(git.RepoPath, manifest.LOCK_FILE)
+			if _, err := os.Stat(lockFile); err == nil {
+				// The locked dependencies may have changed with the revision.
+				downloadPackages(resolve.NewNode(packageInfo), loadManifest(lockFile))
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	switch {
+	case len(repaired) == 0:
+		fmt.Fprintf(os.Stdout, "No problems found.\n")
+	case *noRun:
+		fmt.Fprintf(os.Stdout, "%d packages need repair. Nothing was changed.\n", len(repaired))
+	default:
+		fmt.Fprintf(os.Stdout, "Repaired %d packages.\n", len(repaired))
+	}
+}
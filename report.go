@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// A row of the dependency report.
+type reportEntry struct {
+	Package     string
+	Source      string
+	Revision    string
+	LastUpdated string
+	License     string
+	Dependency  string
+}
+
+var reportColumns = []string{"package", "source", "revision", "last updated", "license", "dependency"}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Dependencies of {{.Repository}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+th { background: #eee; }
+td.revision { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Dependencies of {{.Repository}}</h1>
+<table>
+<tr>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Entries}}<tr><td>{{.Package}}</td><td>{{.Source}}</td><td class="revision">{{.Revision}}</td><td>{{.LastUpdated}}</td><td>{{.License}}</td><td>{{.Dependency}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Returns a report entry for every package in the tree, at the version
+// conflict resolution settles on. The last updated date and the license are
+// left empty and "unknown" for packages that aren't installed.
+func reportEntries(root *resolve.Node, lockManifest *manifest.Manifest) []reportEntry {
+	loadPackages(root, lockManifest)
+	entries := []reportEntry{}
+	for _, packageInfo := range resolvedPackages(root, lockManifest.Resolutions) {
+		entry := reportEntry{
+			Package:    packageInfo.Name,
+			Source:     packageInfo.Source,
+			Revision:   packageInfo.GetRevision(),
+			License:    "unknown",
+			Dependency: "transitive",
+		}
+		if _, ok := lockManifest.Packages[packageInfo.Name]; ok {
+			entry.Dependency = "direct"
+		}
+		git := GitRepositoryFromPackage(packageInfo)
+		_, fromArchive := vcs.TarballRevision(git.RepoPath)
+		if fromArchive || git.IsCloned() {
+			entry.License = packageLicense(git, packageInfo, fromArchive)
+		}
+		if !fromArchive && git.IsCloned() {
+			if t, ok := git.CommitTime(packageInfo.GetRevision()); ok {
+				entry.LastUpdated = t.UTC().Format("2006-01-02")
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Package < entries[j].Package })
+	return entries
+}
+
+// Prints an inventory of the locked dependencies, direct and transitive, as
+// CSV or as an HTML table, for compliance and architecture reviews.
+func reportCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	format := flags.String("format", "csv", "csv or html")
+	flags.Parse(args)
+	if *format != "csv" && *format != "html" {
+		panic(errors.New(fmt.Sprintf("invalid -format %q: must be csv or html", *format)))
+	}
+
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	entries := reportEntries(root, lockManifest)
+
+	if *format == "html" {
+		repository := lockManifest.Repository
+		if repository == "" {
+			repository = "this project"
+		}
+		err := reportTemplate.Execute(os.Stdout, map[string]interface{}{
+			"Repository": repository,
+			"Columns":    reportColumns,
+			"Entries":    entries,
+		})
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write(reportColumns)
+	for _, entry := range entries {
+		w.Write([]string{entry.Package, entry.Source, entry.Revision, entry.LastUpdated, entry.License, entry.Dependency})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Builds the dependency tree for every package in the manifest from the
+// lockfiles already checked out in the workspace. Nothing is downloaded.
+func loadPackages(parent *resolve.Node, m *manifest.Manifest) {
+	for _, packageInfo := range m.Packages {
+		child := loadPackage(packageInfo)
+		parent.AddChild(child)
+	}
+}
+
+// Returns the tree for a single package. Packages missing from the workspace
+// are included without children, since their dependencies can't be known.
+func loadPackage(packageInfo *manifest.Package) *resolve.Node {
+	git := GitRepositoryFromPackage(packageInfo)
+	node := resolve.NewNode(packageInfo)
+
+	packageManifestFile := path.Join(git.RepoPath, manifest.LOCK_FILE)
+	_, err := os.Stat(packageManifestFile)
+	if err == nil {
+		loadPackages(node, loadManifest(packageManifestFile))
+	} else if os.IsNotExist(err) {
+		if _, err := os.Stat(git.RepoPath); os.IsNotExist(err) {
+			warnf("%s is not installed, skipping its dependencies", packageInfo.Name)
+		}
+	} else {
+		panic(err)
+	}
+
+	return node
+}
+
+// Finds the packages requested at conflicting versions in the loaded tree, and
+// chooses a version of each with the -conflict_strategy, unless resolutions
+// records the choice an earlier update made.
+func findConflicts(root *resolve.Node, resolutions map[string]*manifest.Resolution) []*resolve.Conflicts {
+	conflicts := resolve.FindConflicts(root)
+	tagsOf := func(packageInfo *manifest.Package) []string {
+		return GitRepositoryFromPackage(packageInfo).TagsAt(packageInfo.Revision)
+	}
+	switch *conflictStrategy {
+	case resolve.STRATEGY_HIGHEST:
+		resolve.ChooseHighest(conflicts, tagsOf)
+	case resolve.STRATEGY_MINIMAL:
+		resolve.ChooseMinimal(conflicts, tagsOf)
+	case resolve.STRATEGY_NEWEST:
+		resolve.ChooseNewest(conflicts, func(packageInfo *manifest.Package) (time.Time, bool) {
+			return GitRepositoryFromPackage(packageInfo).CommitTime(packageInfo.GetRevision())
+		})
+	}
+	resolve.ApplyResolutions(conflicts, resolutions)
+	return conflicts
+}
+
+// Returns every package in the loaded tree once, at the version that conflict
+// resolution settles on.
+func resolvedPackages(root *resolve.Node, resolutions map[string]*manifest.Resolution) []*manifest.Package {
+	conflicts := findConflicts(root, resolutions)
+	packages := root.Packages()
+	for i, packageInfo := range packages {
+		for _, c := range conflicts {
+			if c.Covers(packageInfo) {
+				packages[i] = c.Chosen.Package
+			}
+		}
+	}
+	return packages
+}
+
+// Looks up a package by name in the lockfile, falling back to the dependencies
+// of installed packages. Also returns the package's node in the loaded tree.
+func lookupPackage(root *resolve.Node, packageName string) (*manifest.Package, *resolve.Node) {
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+
+	node := root.Find(packageName)
+	if node == nil {
+		panic(errors.New(fmt.Sprintf("Package %s not found in %s or its dependencies", packageName, manifest.LOCK_FILE)))
+	}
+
+	// If the package was requested more than once, report the version that
+	// conflict resolution settles on.
+	conflicts := findConflicts(root, lockManifest.Resolutions)
+	for _, c := range conflicts {
+		if c.Covers(node.Package) {
+			node = c.Chosen
+		}
+	}
+	return node.Package, node
+}
+
+// Writes the conflicts to the file named by -conflicts_out, if there is one.
+// The file is written even if there are no conflicts, so it never describes
+// an earlier run.
+func writeConflictsOut(conflicts []*resolve.Conflicts) {
+	if *conflictsOut == "" {
+		return
+	}
+	f, err := os.Create(*conflictsOut)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if err := resolve.WriteConflictReport(f, conflicts); err != nil {
+		panic(err)
+	}
+}
+
+// Prints the conflicts in the dependency tree described by the lockfiles,
+// without downloading or modifying anything.
+func resolveCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("resolve", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "print the conflicts as a JSON array")
+	flags.Parse(args)
+
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+	conflicts := findConflicts(root, lockManifest.Resolutions)
+	writeConflictsOut(conflicts)
+
+	if *jsonOutput {
+		if err := resolve.WriteConflictReport(os.Stdout, conflicts); err != nil {
+			panic(err)
+		}
+	} else if len(conflicts) == 0 {
+		fmt.Fprintln(os.Stdout, "No conflicting versions found.")
+	} else {
+		for _, c := range conflicts {
+			c.Dump(os.Stdout)
+		}
+	}
+
+	if *strict && len(conflicts) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,239 @@
+package resolve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+type Conflicts struct {
+	Source string
+	// The import path the requests are for, if Source is installed under
+	// several, such as pkg and pkg.v2 side by side. Requests under different
+	// import paths don't conflict.
+	Name       string
+	Chosen     *Node
+	Changesets map[string][]*Node
+	// Why Chosen was chosen, if not because it was the first request.
+	Reason string
+}
+
+// Returns the key of the conflict's resolution in the lockfile: the source,
+// followed by " as " and the import path if there are several.
+func (c *Conflicts) Key() string {
+	if c.Name == "" {
+		return c.Source
+	}
+	return c.Source + " as " + c.Name
+}
+
+// Whether the conflict is over the package.
+func (c *Conflicts) Covers(packageInfo *manifest.Package) bool {
+	return packageInfo.Source == c.Source && (c.Name == "" || packageInfo.GetImportPath() == c.Name)
+}
+
+// Returns the refs requested for this source in a stable order.
+func (c *Conflicts) Refs() []string {
+	refs := make([]string, 0, len(c.Changesets))
+	for ref := range c.Changesets {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// Formats the chosen version in Dump, for example to color it. Returns its
+// argument unchanged by default.
+var HighlightChosen = func(text string) string { return text }
+
+// Prints a human-readable warning describing the conflict.
+func (c *Conflicts) Dump(w io.Writer) {
+	fmt.Fprintf(w, "Warning: conflicting versions found for %s (* was chosen):\n", c.Key())
+	if c.Reason != "" {
+		fmt.Fprintf(w, "  %s\n", c.Reason)
+	}
+	for _, ref := range c.Refs() {
+		for _, node := range c.Changesets[ref] {
+			line := "    " + node.Package.GetRef()
+			if node == c.Chosen {
+				line = HighlightChosen("(*) " + node.Package.GetRef())
+			}
+
+			fmt.Fprintf(w, "  %s\n", line)
+
+			indent := "        "
+			for _, source := range node.RequestChain() {
+				fmt.Fprintf(w, "%s... from %s\n", indent, source)
+				indent += "  "
+			}
+		}
+	}
+}
+
+// Machine-readable description of a single conflicting package.
+type ConflictReport struct {
+	Source     string
+	Name       string `json:",omitempty"`
+	Chosen     string
+	Reason     string `json:",omitempty"`
+	Candidates []*manifest.ConflictCandidate
+}
+
+func (c *Conflicts) Report() *ConflictReport {
+	report := &ConflictReport{
+		Source:     c.Source,
+		Name:       c.Name,
+		Chosen:     c.Chosen.Package.GetRef(),
+		Reason:     c.Reason,
+		Candidates: []*manifest.ConflictCandidate{},
+	}
+	for _, ref := range c.Refs() {
+		for _, node := range c.Changesets[ref] {
+			report.Candidates = append(report.Candidates, &manifest.ConflictCandidate{
+				Ref:         ref,
+				RequestedBy: node.RequestChain(),
+			})
+		}
+	}
+	return report
+}
+
+func (c *Conflicts) Resolution() *manifest.Resolution {
+	resolution := &manifest.Resolution{
+		Rejected: []*manifest.ConflictCandidate{},
+		Reason:   c.Reason,
+	}
+	for _, ref := range c.Refs() {
+		for _, node := range c.Changesets[ref] {
+			candidate := &manifest.ConflictCandidate{
+				Ref:         ref,
+				RequestedBy: node.RequestChain(),
+			}
+			if node == c.Chosen {
+				resolution.Chosen = candidate
+			} else {
+				resolution.Rejected = append(resolution.Rejected, candidate)
+			}
+		}
+	}
+	return resolution
+}
+
+// Returns the resolutions for the given conflicts, by their keys.
+func ResolutionsFor(conflicts []*Conflicts) map[string]*manifest.Resolution {
+	if len(conflicts) == 0 {
+		return nil
+	}
+	resolutions := make(map[string]*manifest.Resolution)
+	for _, c := range conflicts {
+		resolutions[c.Key()] = c.Resolution()
+	}
+	return resolutions
+}
+
+// Replaces the breadth-first choice with the one recorded in resolutions,
+// provided the recorded ref was requested again.
+func ApplyResolutions(conflicts []*Conflicts, resolutions map[string]*manifest.Resolution) {
+	for _, c := range conflicts {
+		resolution, ok := resolutions[c.Key()]
+		if !ok || resolution.Chosen == nil {
+			continue
+		}
+		if nodes, ok := c.Changesets[resolution.Chosen.Ref]; ok {
+			c.Chosen = nodes[0]
+			c.Reason = resolution.Reason
+		}
+	}
+}
+
+// Writes the given conflicts to w as an indented JSON array.
+func WriteConflictReport(w io.Writer, conflicts []*Conflicts) error {
+	reports := make([]*ConflictReport, len(conflicts))
+	for i, c := range conflicts {
+		reports[i] = c.Report()
+	}
+	data, err := json.MarshalIndent(reports, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// Finds every package that was requested at more than one ref, sorted by
+// source. The first node seen for each source is recorded as the chosen one.
+// A source installed under several import paths is checked under each
+// separately.
+func FindConflicts(root *Node) []*Conflicts {
+	names := make(map[string]map[string]bool)
+	for _, packageInfo := range root.Packages() {
+		if names[packageInfo.Source] == nil {
+			names[packageInfo.Source] = make(map[string]bool)
+		}
+		names[packageInfo.Source][packageInfo.GetImportPath()] = true
+	}
+
+	queue := root.Children[:]
+
+	check := make(map[string]*Conflicts)
+
+	// Process nodes in breadth-first order, so the first package in the
+	// dependency tree has highest priority.
+	for len(queue) > 0 {
+		// Pop an item from the front of the queue.
+		node := queue[0]
+		queue = queue[1:len(queue)]
+
+		pkg := node.Package
+		key, name := pkg.Source, ""
+		if len(names[pkg.Source]) > 1 {
+			name = pkg.GetImportPath()
+			key += " as " + name
+		}
+		if conflicts, ok := check[key]; ok {
+			// We already saw this package, so add it to the list of packages that
+			// requested this same changeset.
+			same, _ := conflicts.Changesets[pkg.GetRef()]
+			same = append(same, node)
+			conflicts.Changesets[pkg.GetRef()] = same
+		} else {
+			// This is the first time we've seen this package, so take it as canonical.
+			check[key] = &Conflicts{
+				Source: pkg.Source,
+				Name:   name,
+				Chosen: node,
+				Changesets: map[string][]*Node{
+					pkg.GetRef(): []*Node{node},
+				},
+			}
+		}
+
+		queue = append(queue, node.Children...)
+	}
+
+	keys := []string{}
+	for key, conflicts := range check {
+		if len(conflicts.Changesets) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	found := make([]*Conflicts, len(keys))
+	for i, key := range keys {
+		found[i] = check[key]
+	}
+	return found
+}
+
+// Returns the package chosen for each conflict.
+func ResolveConflicts(conflicts []*Conflicts) []*manifest.Package {
+	resolved := []*manifest.Package{}
+	for _, c := range conflicts {
+		resolved = append(resolved, c.Chosen.Package)
+	}
+	return resolved
+}
@@ -0,0 +1,185 @@
+package resolve
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Ways to choose between conflicting versions of a package.
+const (
+	// The request nearest the project, first in breadth-first order.
+	STRATEGY_FIRST = "first"
+	// The highest semantic version, if every request is for a release tag of
+	// the same major version.
+	STRATEGY_HIGHEST = "highest"
+	// Minimal version selection, as in Go modules: every request is for a
+	// release tag of the same major version and names the minimum version its
+	// requester needs, so the lowest version that satisfies them all is the
+	// highest requested. Requests made by versions of other packages that
+	// weren't selected don't count.
+	STRATEGY_MINIMAL = "minimal"
+	// The revision with the newest commit date, for requests that pin commits
+	// without release tags.
+	STRATEGY_NEWEST = "newest"
+)
+
+// Returns the tags that point at the revision of a requested package.
+type TagsFunc func(packageInfo *manifest.Package) []string
+
+// Returns the commit date of the revision of a requested package, or false if
+// it can't be read.
+type CommitTimeFunc func(packageInfo *manifest.Package) (time.Time, bool)
+
+// Returns the highest semantic version among tags, and the tag it is from.
+func taggedVersion(tags []string) (string, *vcs.Version) {
+	sort.Strings(tags)
+	var best *vcs.Version
+	var bestTag string
+	for _, tag := range tags {
+		if v, ok := vcs.ParseVersion(tag); ok && (best == nil || best.Less(v)) {
+			best, bestTag = v, tag
+		}
+	}
+	return bestTag, best
+}
+
+// Returns the names of the packages that made a request, nearest first, or
+// the project if it made it itself.
+func requestedBy(node *Node) string {
+	names := []string{}
+	for parent := node.Parent; parent != nil && parent.Package != nil && parent.Parent != nil; parent = parent.Parent {
+		names = append(names, parent.Package.Name)
+	}
+	if len(names) == 0 {
+		return "the project"
+	}
+	return strings.Join(names, " <- ")
+}
+
+// Chooses, for a conflict whose counted requests are all for release tags of
+// the same major version, the highest version requested. Returns the tag of
+// the chosen version, or false if some request isn't for a release tag or the
+// major versions differ.
+func chooseHighest(c *Conflicts, tagsOf TagsFunc, counts func(node *Node) bool) (string, bool) {
+	var chosen *Node
+	var chosenTag string
+	var chosenVersion *vcs.Version
+	for _, ref := range c.Refs() {
+		for _, node := range c.Changesets[ref] {
+			if !counts(node) {
+				continue
+			}
+			if !node.Package.HasRevision() {
+				return "", false
+			}
+			tag, v := taggedVersion(tagsOf(node.Package))
+			if v == nil || (chosenVersion != nil && v.Major != chosenVersion.Major) {
+				return "", false
+			}
+			if chosenVersion == nil || chosenVersion.Less(v) {
+				chosen, chosenTag, chosenVersion = node, tag, v
+			}
+		}
+	}
+	if chosen == nil {
+		return "", false
+	}
+	c.Chosen = chosen
+	return chosenTag, true
+}
+
+// Chooses the highest version for every conflict whose requests are all for
+// release tags of the same major version, and records the request that forced
+// the choice. Other conflicts keep the request nearest the project.
+func ChooseHighest(conflicts []*Conflicts, tagsOf TagsFunc) {
+	for _, c := range conflicts {
+		tag, ok := chooseHighest(c, tagsOf, func(node *Node) bool { return true })
+		if ok {
+			c.Reason = fmt.Sprintf("%s is the highest version requested, by %s", tag, requestedBy(c.Chosen))
+		}
+	}
+}
+
+// Chooses versions by minimal version selection for every conflict whose
+// requests are all for release tags of the same major version, and records
+// the request that set the minimum. Other conflicts keep the request nearest
+// the project.
+func ChooseMinimal(conflicts []*Conflicts, tagsOf TagsFunc) {
+	// Dropping the requests of versions that weren't selected can change
+	// other choices, so choose again until nothing changes. Each round can
+	// only settle more of the tree, so there are at most as many rounds as
+	// conflicts.
+	for round := 0; round <= len(conflicts); round++ {
+		rejected := map[*Node]bool{}
+		for _, c := range conflicts {
+			for _, nodes := range c.Changesets {
+				for _, node := range nodes {
+					rejected[node] = node.Package.GetRef() != c.Chosen.Package.GetRef()
+				}
+			}
+		}
+		counts := func(node *Node) bool {
+			for parent := node.Parent; parent != nil; parent = parent.Parent {
+				if rejected[parent] {
+					return false
+				}
+			}
+			return true
+		}
+
+		changed := false
+		for _, c := range conflicts {
+			previous := c.Chosen
+			tag, ok := chooseHighest(c, tagsOf, counts)
+			if !ok {
+				continue
+			}
+			c.Reason = fmt.Sprintf("%s is the minimal version that satisfies every request; it was requested by %s", tag, requestedBy(c.Chosen))
+			if c.Chosen.Package.GetRef() != previous.Package.GetRef() {
+				changed = true
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+}
+
+// Chooses, for every conflict whose requested revisions' commit dates can all
+// be read, the revision committed last, and records when. Other conflicts, and
+// ties, keep the request nearest the project.
+func ChooseNewest(conflicts []*Conflicts, commitTime CommitTimeFunc) {
+	for _, c := range conflicts {
+		newest, ok := commitTime(c.Chosen.Package)
+		if !ok {
+			continue
+		}
+		chosen := c.Chosen
+		for _, ref := range c.Refs() {
+			for _, node := range c.Changesets[ref] {
+				t, ok := commitTime(node.Package)
+				if !ok || !node.Package.HasRevision() {
+					chosen = nil
+					break
+				}
+				if t.After(newest) {
+					chosen, newest = node, t
+				}
+			}
+			if chosen == nil {
+				break
+			}
+		}
+		if chosen == nil {
+			continue
+		}
+		c.Chosen = chosen
+		c.Reason = fmt.Sprintf("%s is the newest commit requested, from %s, by %s",
+			vcs.ShortRevision(chosen.Package.Revision), newest.UTC().Format("2006-01-02 15:04"), requestedBy(chosen))
+	}
+}
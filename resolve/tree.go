@@ -0,0 +1,104 @@
+// Package resolve builds dependency trees and detects and resolves packages
+// that are requested at conflicting versions.
+package resolve
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+type Node struct {
+	Parent   *Node
+	Children []*Node
+	Package  *manifest.Package
+}
+
+func NewNode(packageInfo *manifest.Package) *Node {
+	return &Node{
+		Package: packageInfo,
+	}
+}
+
+func (this *Node) AddChild(child *Node) {
+	this.Children = append(this.Children, child)
+	child.Parent = this
+}
+
+// Adds the children of other to this node, leaving other as their parent, so
+// their request chains still name it.
+func (this *Node) Adopt(other *Node) {
+	this.Children = append(this.Children, other.Children...)
+}
+
+// Returns the sources of every package that led to this node, nearest first.
+func (this *Node) RequestChain() []string {
+	chain := []string{}
+	for parent := this.Parent; parent != nil && parent.Package != nil; parent = parent.Parent {
+		chain = append(chain, parent.Package.Source)
+	}
+	return chain
+}
+
+// Returns every node for the named package, in breadth-first order.
+func (this *Node) FindAll(packageName string) []*Node {
+	found := []*Node{}
+	queue := this.Children[:]
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if node.Package.Name == packageName {
+			found = append(found, node)
+		}
+		queue = append(queue, node.Children...)
+	}
+	return found
+}
+
+// Returns the first node in breadth-first order for the named package, or nil
+// if the package is not in the tree.
+func (this *Node) Find(packageName string) *Node {
+	if found := this.FindAll(packageName); len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+// Returns each package in the tree once, in breadth-first order.
+func (this *Node) Packages() []*manifest.Package {
+	seen := make(map[string]bool)
+	packages := []*manifest.Package{}
+	queue := this.Children[:]
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if !seen[node.Package.Name] {
+			seen[node.Package.Name] = true
+			packages = append(packages, node.Package)
+		}
+		queue = append(queue, node.Children...)
+	}
+	return packages
+}
+
+func (this *Node) dumpIndent(w io.Writer, indent int) {
+	for i := 0; i < indent; i++ {
+		fmt.Fprintf(w, " ")
+	}
+	this.Package.Dump(w)
+	for _, child := range this.Children {
+		child.dumpIndent(w, indent+2)
+	}
+}
+
+func (this *Node) Dump(w io.Writer) {
+	if this.Package == nil {
+		// If looking at all packages, there's a root placeholder node.
+		for _, child := range this.Children {
+			child.Dump(w)
+		}
+	} else {
+		this.dumpIndent(w, 0)
+	}
+}
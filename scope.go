@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// How far update may move packages that are on release tags.
+const (
+	SCOPE_PATCH = "patch"
+	SCOPE_MINOR = "minor"
+	SCOPE_MAJOR = "major"
+)
+
+// Set from update's -patch, -minor and -major flags. Empty means packages
+// follow their branches.
+var updateScope string
+
+// Set from update's -with_deps flag.
+var updateWithDeps bool
+
+// Parses update's flags and returns the command line without them.
+func parseUpdateArgs(args []string) []string {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	patch := flags.Bool(SCOPE_PATCH, false, "only move packages to newer tags of the same minor version")
+	minor := flags.Bool(SCOPE_MINOR, false, "only move packages to newer tags of the same major version")
+	major := flags.Bool(SCOPE_MAJOR, false, "move packages to their newest tags")
+	flags.BoolVar(&updateWithDeps, "with_deps", false, "also update every package the package depends on, and lock them")
+	flags.BoolVar(&updateCommit, "commit", false, "commit the changed packages.json and packages.lock to a new branch, with a message listing the revision bumps")
+	flags.BoolVar(&updatePush, "push", false, "push the branch to origin; implies -commit")
+	flags.BoolVar(&updatePR, "pr", false, "open a GitHub pull request or GitLab merge request for the branch, with the token in GITHUB_TOKEN or GITLAB_TOKEN; implies -push")
+	flags.StringVar(&updateBranch, "branch", "", "name of the branch to commit to. Defaults to deliver/update-<date>-<time>")
+	flags.Parse(args[1:])
+	if updateWithDeps && flags.NArg() != 1 {
+		panic(errors.New("-with_deps needs the name of the package to update"))
+	}
+
+	for scope, set := range map[string]bool{SCOPE_PATCH: *patch, SCOPE_MINOR: *minor, SCOPE_MAJOR: *major} {
+		if !set {
+			continue
+		}
+		if updateScope != "" {
+			panic(errors.New("only one of -patch, -minor and -major can be given"))
+		}
+		updateScope = scope
+	}
+	return append([]string{args[0]}, flags.Args()...)
+}
+
+// Whether update may move a package from version from to version to.
+func inScope(from, to *vcs.Version) bool {
+	if to.Prerelease != "" && from.Prerelease == "" {
+		return false
+	}
+	switch updateScope {
+	case SCOPE_PATCH:
+		return to.Major == from.Major && to.Minor == from.Minor
+	case SCOPE_MINOR:
+		return to.Major == from.Major
+	}
+	return true
+}
+
+// Returns the newest version among the accepted tags, and the tag it is from.
+func newestTag(tags map[string]string, accept func(tag string, v *vcs.Version) bool) (string, *vcs.Version) {
+	names := []string{}
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	// Of tags for the same version, such as 1.2.0 and v1.2.0, pick the same
+	// one every time.
+	sort.Strings(names)
+	var newest *vcs.Version
+	var newestName string
+	for _, tag := range names {
+		v, ok := vcs.ParseVersion(tag)
+		if ok && accept(tag, v) && (newest == nil || newest.Less(v)) {
+			newest, newestName = v, tag
+		}
+	}
+	return newestName, newest
+}
+
+// Pins each package in the package file to the newest release tag within the
+// update scope, starting from the tag of its locked revision. Packages that
+// the package file already pins, and packages that aren't locked yet, are
+// left alone. Packages whose locked revision isn't tagged stay at it.
+func applyUpdateScope(m *manifest.Manifest) {
+	if updateScope == "" {
+		return
+	}
+	lockManifest, err := manifest.Load(manifest.LOCK_FILE)
+	if err != nil {
+		lockManifest = &manifest.Manifest{}
+	}
+	for name, packageInfo := range m.Packages {
+		locked, ok := lockManifest.Packages[name]
+		if packageInfo.HasRevision() || !ok || !locked.HasRevision() {
+			continue
+		}
+		tags, err := GitRepositoryFromPackage(packageInfo).RemoteTags()
+		if err != nil {
+			panic(err)
+		}
+		currentTag, current := newestTag(tags, func(tag string, v *vcs.Version) bool {
+			return tags[tag] == locked.Revision
+		})
+		if current == nil {
+			warnf("%s is not at a release tag, so it stays at %s", name, locked.Revision)
+			packageInfo.Revision = locked.Revision
+			continue
+		}
+		tag, _ := newestTag(tags, func(tag string, v *vcs.Version) bool {
+			return !v.Less(current) && inScope(current, v)
+		})
+		if tag != currentTag {
+			fmt.Fprintf(os.Stdout, "%s: %s -> %s\n", name, currentTag, tag)
+		}
+		packageInfo.Revision = tags[tag]
+	}
+}
+
+// Updates the dependencies of an updated package to the tips of their
+// branches, and their dependencies in turn, recording each in lockManifest.
+// Entries in the project's lockfile take precedence over the lockfiles of
+// packages, so the new revisions are used everywhere.
+func updateDependencies(node *resolve.Node, lockManifest *manifest.Manifest, updated map[string]bool) {
+	updated[node.Package.Name] = true
+	for _, child := range node.Children {
+		if updated[child.Package.Name] {
+			continue
+		}
+		packageInfo := *child.Package
+		packageInfo.Revision = ""
+		updateDependencies(downloadPackage(&packageInfo), lockManifest, updated)
+		lockManifest.Packages[packageInfo.Name] = &packageInfo
+		// A recorded conflict resolution would pick another version.
+		delete(lockManifest.Resolutions, packageInfo.Source)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Operations served over JSON-RPC by `deliver serve`. Every call reads the
+// lockfiles and workspace afresh, so results reflect the current state.
+type DeliverService struct {
+	// Commands share process state such as the current directory, so calls
+	// are handled one at a time.
+	mutex sync.Mutex
+	// Source of the root node, naming the project.
+	projectSource string
+}
+
+type EmptyArgs struct{}
+
+type PackageArgs struct {
+	Name string
+}
+
+// Where a package is installed, and whether it matches the lockfile.
+type PackageStatus struct {
+	Name      string
+	Source    string
+	Path      string
+	Locked    string
+	Installed bool
+	Current   string `json:",omitempty"`
+	UpToDate  bool
+}
+
+// A package and the packages its lockfile depends on.
+type GraphNode struct {
+	Name         string
+	Source       string
+	Ref          string
+	Dependencies []*GraphNode
+}
+
+// Converts a panic raised by the command helpers into an RPC error.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = errors.New(fmt.Sprint(r))
+	}
+}
+
+// Loads the dependency tree from the lockfiles in the workspace.
+func (s *DeliverService) loadTree() (*resolve.Node, *manifest.Manifest) {
+	root := resolve.NewNode(&manifest.Package{Source: s.projectSource})
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+	return root, lockManifest
+}
+
+// Returns the conflicting versions in the dependency tree.
+func (s *DeliverService) Resolve(args *EmptyArgs, reply *[]*resolve.ConflictReport) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer recoverError(&err)
+
+	root, lockManifest := s.loadTree()
+	conflicts := findConflicts(root, lockManifest.Resolutions)
+
+	*reply = []*resolve.ConflictReport{}
+	for _, c := range conflicts {
+		*reply = append(*reply, c.Report())
+	}
+	return nil
+}
+
+// Returns the install status of every package in the dependency tree.
+func (s *DeliverService) Status(args *EmptyArgs, reply *[]*PackageStatus) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer recoverError(&err)
+
+	root, lockManifest := s.loadTree()
+	*reply = []*PackageStatus{}
+	for _, packageInfo := range resolvedPackages(root, lockManifest.Resolutions) {
+		git := GitRepositoryFromPackage(packageInfo)
+		status := &PackageStatus{
+			Name:   packageInfo.Name,
+			Source: packageInfo.Source,
+			Path:   git.RepoPath,
+			Locked: packageInfo.Revision,
+		}
+		status.Current, status.Installed = getInstalledRevision(git)
+		status.UpToDate = status.Installed && (!packageInfo.HasRevision() || status.Current == packageInfo.Revision)
+		*reply = append(*reply, status)
+	}
+	return nil
+}
+
+// Returns the directory of a package in the workspace. An empty name returns
+// the workspace itself.
+func (s *DeliverService) Path(args *PackageArgs, reply *string) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer recoverError(&err)
+
+	if args.Name == "" {
+		*reply = getWorkspacePath()
+		return nil
+	}
+	root := resolve.NewNode(&manifest.Package{Source: s.projectSource})
+	packageInfo, _ := lookupPackage(root, args.Name)
+	*reply = GitRepositoryFromPackage(packageInfo).RepoPath
+	return nil
+}
+
+func graphNode(node *resolve.Node) *GraphNode {
+	graph := &GraphNode{
+		Name:         node.Package.Name,
+		Source:       node.Package.Source,
+		Dependencies: []*GraphNode{},
+	}
+	if node.Parent != nil {
+		graph.Ref = node.Package.GetRef()
+	}
+	for _, child := range node.Children {
+		graph.Dependencies = append(graph.Dependencies, graphNode(child))
+	}
+	return graph
+}
+
+// Returns the whole dependency tree, rooted at the project.
+func (s *DeliverService) Graph(args *EmptyArgs, reply *GraphNode) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer recoverError(&err)
+
+	root, _ := s.loadTree()
+	*reply = *graphNode(root)
+	return nil
+}
+
+// Serves DeliverService over JSON-RPC on a unix socket until interrupted.
+func serveCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	socketPath := flags.String("socket", ".deliver.sock", "unix socket to listen on")
+	flags.Parse(args)
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Deliver", &DeliverService{projectSource: root.Package.Source}); err != nil {
+		panic(err)
+	}
+
+	// Remove a socket left behind by a server that didn't shut down cleanly.
+	if info, err := os.Lstat(*socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(*socketPath)
+	}
+	listener, err := net.Listen("unix", *socketPath)
+	if err != nil {
+		panic(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	absPath, _ := filepath.Abs(*socketPath)
+	fmt.Fprintf(os.Stdout, "serving JSON-RPC on %s\n", absPath)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return
+			}
+			panic(err)
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
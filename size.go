@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Disk usage of a single package checkout.
+type packageSize struct {
+	name     string
+	history  int64 // bytes under .git
+	worktree int64 // everything else
+}
+
+func (s *packageSize) total() int64 {
+	return s.history + s.worktree
+}
+
+// Returns the total size of the regular files under dir. Missing directories
+// have a size of zero.
+func directorySize(dir string, skip string) int64 {
+	var size int64
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			panic(err)
+		}
+		if info.IsDir() && p == skip {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// Formats a byte count using binary units.
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// Prints the disk usage of every installed package, largest first.
+func sizeCommand(root *resolve.Node) {
+	loadPackages(root, loadManifest(manifest.LOCK_FILE))
+
+	sizes := []*packageSize{}
+	var history, worktree int64
+	for _, packageInfo := range root.Packages() {
+		git := GitRepositoryFromPackage(packageInfo)
+		gitDir := path.Join(git.RepoPath, ".git")
+		size := &packageSize{
+			name:     packageInfo.Name,
+			history:  directorySize(gitDir, ""),
+			worktree: directorySize(git.RepoPath, gitDir),
+		}
+		history += size.history
+		worktree += size.worktree
+		sizes = append(sizes, size)
+	}
+
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].total() == sizes[j].total() {
+			return sizes[i].name < sizes[j].name
+		}
+		return sizes[i].total() > sizes[j].total()
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "TOTAL\tHISTORY\tWORKTREE\t\tPACKAGE\n")
+	for _, size := range sizes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t\t%s\n", formatSize(size.total()), formatSize(size.history), formatSize(size.worktree), size.name)
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t\t%s\n", formatSize(history+worktree), formatSize(history), formatSize(worktree), "(all packages)")
+	w.Flush()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Parses an age such as "30d", "6w", "12m" or "2y" and returns the time that
+// far before now.
+func parseAge(age string, now time.Time) (time.Time, error) {
+	if len(age) < 2 {
+		return now, errors.New(fmt.Sprintf("invalid age %q", age))
+	}
+	n, err := strconv.Atoi(age[:len(age)-1])
+	if err != nil || n < 0 {
+		return now, errors.New(fmt.Sprintf("invalid age %q", age))
+	}
+	switch age[len(age)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return now, errors.New(fmt.Sprintf("invalid age %q: unit must be one of d, w, m, y", age))
+}
+
+// Formats the time since t in days.
+func formatAge(t time.Time, now time.Time) string {
+	return fmt.Sprintf("%dd", int(now.Sub(t).Hours()/24))
+}
+
+// Lists packages whose locked revision is older than the threshold, or whose
+// upstream branch has had no commits since then.
+func staleCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("stale", flag.ExitOnError)
+	than := flags.String("than", "12m", "age after which a revision is stale (e.g. 90d, 12m, 2y)")
+	flags.Parse(args)
+
+	now := time.Now()
+	cutoff, err := parseAge(*than, now)
+	if err != nil {
+		panic(err)
+	}
+
+	loadPackages(root, loadManifest(manifest.LOCK_FILE))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "PACKAGE\tLOCKED\tUPSTREAM\tSTATUS\n")
+	found := 0
+	for _, packageInfo := range root.Packages() {
+		git := GitRepositoryFromPackage(packageInfo)
+		if !git.IsCloned() {
+			warnf("%s is not installed, skipping", packageInfo.Name)
+			continue
+		}
+
+		locked, lockedOk := git.CommitTime(packageInfo.GetRevision())
+		upstream, upstreamOk := git.CommitTime("origin/" + packageInfo.GetBranch())
+
+		status := []string{}
+		if lockedOk && locked.Before(cutoff) {
+			status = append(status, "outdated")
+		}
+		if upstreamOk && upstream.Before(cutoff) {
+			status = append(status, "upstream inactive")
+		}
+		if len(status) == 0 {
+			continue
+		}
+
+		lockedAge, upstreamAge := "?", "?"
+		if lockedOk {
+			lockedAge = formatAge(locked, now)
+		}
+		if upstreamOk {
+			upstreamAge = formatAge(upstream, now)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", packageInfo.Name, lockedAge, upstreamAge, strings.Join(status, ", "))
+		found++
+	}
+
+	if found == 0 {
+		fmt.Fprintf(os.Stdout, "No packages older than %s.\n", *than)
+		return
+	}
+	w.Flush()
+}
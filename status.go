@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Describes the state of an installed package's checkout, and whether it is
+// one install leaves packages in: at the locked revision, detached or on its
+// own branch, without changes.
+func checkoutState(git *vcs.GitRepository, packageInfo *manifest.Package) (state string, expected bool) {
+	if revision, ok := vcs.TarballRevision(git.RepoPath); ok {
+		if packageInfo.HasRevision() && revision != packageInfo.Revision {
+			return fmt.Sprintf("installed from an archive at %s, not the locked revision", vcs.ShortRevision(revision)), false
+		}
+		return "installed without git metadata, at the locked revision", true
+	}
+	if problem, _ := diagnosePackage(git, packageInfo); problem != "" {
+		return problem, false
+	}
+	branch, err := git.CurrentBranch()
+	if err != nil {
+		return "cannot read the checked out branch", false
+	}
+	modified, err := git.ModifiedFiles()
+	if err != nil {
+		return "cannot read the changes in the checkout", false
+	}
+	switch {
+	case len(modified) == 1:
+		return modified[0] + " is modified", false
+	case len(modified) > 1:
+		return fmt.Sprintf("%d files are modified, such as %s", len(modified), modified[0]), false
+	case branch == "":
+		return "detached at the locked revision, as installed", true
+	case branch == packageInfo.GetBranch():
+		return fmt.Sprintf("on branch %s at the locked revision", branch), true
+	}
+	return fmt.Sprintf("on branch %s, not %s or a detached HEAD", branch, packageInfo.GetBranch()), false
+}
+
+// Reports the state of every package in the dependency tree, telling the
+// detached HEADs install leaves behind apart from checkouts that were moved,
+// switched to another branch or changed.
+func statusCommand(root *resolve.Node, args []string) {
+	if len(args) != 0 {
+		panic(errors.New("usage: deliver status"))
+	}
+	// Only reads the checkouts.
+	vcs.DryRun = false
+	lockManifest := loadManifest(manifest.LOCK_FILE)
+	loadPackages(root, lockManifest)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "PACKAGE\tREVISION\tSTATE\n")
+	unexpected := []string{}
+	for _, packageInfo := range resolvedPackages(root, lockManifest.Resolutions) {
+		state, expected := checkoutState(GitRepositoryFromPackage(packageInfo), packageInfo)
+		if !expected {
+			state = colorize(os.Stdout, COLOR_YELLOW, state)
+			unexpected = append(unexpected, packageInfo.Name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", packageInfo.Name, vcs.ShortRevision(packageInfo.Revision), state)
+	}
+	w.Flush()
+
+	if len(unexpected) > 0 {
+		panic(errors.New(fmt.Sprintf("%d packages are not as install left them: %v. deliver repair fixes missing and moved checkouts", len(unexpected), unexpected)))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Keeps the reports of go tools run at once from interleaving.
+var goToolOutput sync.Mutex
+
+// Runs the go tool in dir with the workspace as GOPATH, and prints whether
+// it succeeded along with its output if it didn't.
+func runGoTool(name string, dir string, args ...string) bool {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPATH="+getBuildGOPATH())
+	// Packages without a go.mod are built in GOPATH mode, as deliver
+	// installed them, unless the user asked otherwise.
+	if _, err := os.Stat(path.Join(dir, "go.mod")); os.IsNotExist(err) && os.Getenv("GO111MODULE") == "" {
+		cmd.Env = append(cmd.Env, "GO111MODULE=off")
+	}
+
+	output, err := cmd.CombinedOutput()
+	goToolOutput.Lock()
+	defer goToolOutput.Unlock()
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", colorize(os.Stdout, COLOR_RED, "FAIL"), name)
+		for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+			fmt.Fprintf(os.Stdout, "    \t%s\n", line)
+		}
+		return false
+	}
+	fmt.Fprintf(os.Stdout, "%s  \t%s\n", colorize(os.Stdout, COLOR_GREEN, "ok"), name)
+	return true
+}
+
+// Builds each package and the project in the current directory, so a broken
+// revision is reported as soon as it is installed.
+func compileCheck(packages []*manifest.Package) {
+	var failed []string
+	for _, packageInfo := range packages {
+		git := GitRepositoryFromPackage(packageInfo)
+		if !runGoTool(packageInfo.Name, git.RepoPath, "build", "./...") {
+			failed = append(failed, packageInfo.Name)
+		}
+	}
+	if !runGoTool("(project)", ".", "build", "./...") {
+		failed = append(failed, "(project)")
+	}
+
+	if len(failed) > 0 {
+		panic(errors.New(fmt.Sprintf("go build failed in %d packages: %v", len(failed), failed)))
+	}
+}
+
+// Runs `go test` (or `go vet`) in every installed package with the workspace
+// as GOPATH, so dependencies broken by the current Go version show up before
+// they break the build. Arguments after the flags are passed to the go tool.
+func testDepsCommand(root *resolve.Node, args []string) {
+	flags := flag.NewFlagSet("test-deps", flag.ExitOnError)
+	vet := flags.Bool("vet", false, "run go vet instead of go test")
+	flags.Parse(args)
+
+	goCommand := "test"
+	if *vet {
+		goCommand = "vet"
+	}
+	goArgs := append(append([]string{goCommand}, flags.Args()...), "./...")
+
+	goVersion, _ := exec.Command("go", "version").Output()
+	fmt.Fprintf(os.Stdout, "%s", goVersion)
+
+	var failed []string
+	for _, packageInfo := range installedPackages(root) {
+		git := GitRepositoryFromPackage(packageInfo)
+		if !runGoTool(packageInfo.Name, git.RepoPath, goArgs...) {
+			failed = append(failed, packageInfo.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		panic(errors.New(fmt.Sprintf("go %s failed in %d dependencies: %v", goCommand, len(failed), failed)))
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Where update saves the state undo returns to, next to the package file.
+const UNDO_FILE string = ".deliver-undo.json"
+
+// The lockfile and the checked out packages from before an update.
+type updateBackup struct {
+	Time time.Time
+	// Whether there was a lockfile, and its contents.
+	HasLockfile bool
+	Lockfile    string `json:",omitempty"`
+	// Every installed package by name, at the revision it was checked out at.
+	Packages map[string]*manifest.Package
+}
+
+// Saves the lockfile and the revision of every installed package, so undo can
+// return to them after a bad update.
+func backupBeforeUpdate(projectSource string) {
+	backup := &updateBackup{Time: time.Now(), Packages: map[string]*manifest.Package{}}
+	data, err := ioutil.ReadFile(manifest.LOCK_FILE)
+	if err == nil {
+		backup.HasLockfile = true
+		backup.Lockfile = string(data)
+	} else if !os.IsNotExist(err) {
+		panic(err)
+	}
+
+	if lockManifest, err := manifest.Load(manifest.LOCK_FILE); err == nil {
+		root := resolve.NewNode(&manifest.Package{Source: projectSource})
+		for _, packageInfo := range lockManifest.Packages {
+			root.AddChild(loadPackageQuietly(packageInfo))
+		}
+		for _, packageInfo := range resolvedPackages(root, lockManifest.Resolutions) {
+			current, ok := getInstalledRevision(GitRepositoryFromPackage(packageInfo))
+			if !ok {
+				continue
+			}
+			saved := *packageInfo
+			saved.Revision = current
+			backup.Packages[saved.Name] = &saved
+		}
+	}
+
+	data, err = json.MarshalIndent(backup, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(UNDO_FILE, data, 0644); err != nil {
+		panic(err)
+	}
+}
+
+// Like loadPackage, but doesn't warn about packages that aren't installed.
+func loadPackageQuietly(packageInfo *manifest.Package) *resolve.Node {
+	node := resolve.NewNode(packageInfo)
+	lockFile := path.Join(GitRepositoryFromPackage(packageInfo).RepoPath, manifest.LOCK_FILE)
+	if m, err := manifest.Load(lockFile); err == nil {
+		for _, child := range m.Packages {
+			node.AddChild(loadPackageQuietly(child))
+		}
+	}
+	return node
+}
+
+// Returns the lockfile and the workspace to how they were before the last
+// update.
+func undoCommand() {
+	data, err := ioutil.ReadFile(UNDO_FILE)
+	if os.IsNotExist(err) {
+		panic(errors.New("Nothing to undo: no update has been made in this project since the last undo."))
+	} else if err != nil {
+		panic(err)
+	}
+	backup := &updateBackup{}
+	if err := json.Unmarshal(data, backup); err != nil {
+		panic(errors.New(fmt.Sprintf("%s: %v", UNDO_FILE, err)))
+	}
+
+	fmt.Fprintf(os.Stdout, "returning to the state before the update of %s\n", backup.Time.Format("2006-01-02 15:04:05"))
+	names := []string{}
+	for name, packageInfo := range backup.Packages {
+		packageInfo.Name = name
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		packageInfo := backup.Packages[name]
+		git := GitRepositoryFromPackage(packageInfo)
+		if current, ok := getInstalledRevision(git); ok && current == packageInfo.Revision {
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "checking out %s at %s\n", colorize(os.Stdout, COLOR_BOLD, packageInfo.Name), packageInfo.Revision)
+		checkoutPackage(git, packageInfo)
+	}
+
+	if *noRun {
+		return
+	}
+	if backup.HasLockfile {
+		if previous, err := manifest.Load(manifest.LOCK_FILE); err == nil {
+			if restored, err := manifest.ParseLockfile([]byte(backup.Lockfile), manifest.LOCK_FILE); err == nil {
+				printLockChanges(previous, restored)
+			}
+		}
+		if err := ioutil.WriteFile(manifest.LOCK_FILE, []byte(backup.Lockfile), 0644); err != nil {
+			panic(err)
+		}
+	} else if err := os.Remove(manifest.LOCK_FILE); err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	if err := os.Remove(UNDO_FILE); err != nil {
+		panic(err)
+	}
+}
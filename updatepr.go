@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Set from update's -commit, -push, -pr and -branch flags. -pr implies -push,
+// which implies -commit.
+var updateCommit, updatePush, updatePR bool
+var updateBranch string
+
+// The lockfile before the update, to describe the changes in the commit.
+var lockBeforeUpdate *manifest.Manifest
+
+// The remote update branches are pushed to.
+const UPDATE_REMOTE string = "origin"
+
+// Runs git in the project, failing with its output if it fails.
+func projectGit(args ...string) string {
+	out, err := vcs.ExecuteCommand(append([]string{"git"}, args...)...)
+	if err != nil {
+		panic(errors.New(fmt.Sprintf("git %s: %v", strings.Join(args, " "), err)))
+	}
+	return strings.TrimSpace(out)
+}
+
+// Returns the API token for the pull request provider, from the environment.
+func pullRequestToken(provider string) string {
+	variables := []string{"GITHUB_TOKEN", "GH_TOKEN"}
+	if provider == "gitlab" {
+		variables = []string{"GITLAB_TOKEN"}
+	}
+	for _, variable := range variables {
+		if token := os.Getenv(variable); token != "" {
+			return token
+		}
+	}
+	panic(errors.New(fmt.Sprintf("update -pr needs an API token in %s", strings.Join(variables, " or "))))
+}
+
+// Returns github or gitlab for the host the project is pushed to.
+func pullRequestProvider(location *vcs.SourceLocation) string {
+	switch {
+	case strings.Contains(location.Host, "github"):
+		return "github"
+	case strings.Contains(location.Host, "gitlab"):
+		return "gitlab"
+	}
+	panic(errors.New(fmt.Sprintf("update -pr can't open pull requests on %s: only GitHub and GitLab are supported", location.Host)))
+}
+
+// Checks, before a long update, that its changes can be committed and, with
+// -pr, that a pull request can be opened, and saves the lockfile to describe
+// the changes with.
+func startUpdatePR() {
+	if updatePR {
+		updatePush = true
+	}
+	if updatePush {
+		updateCommit = true
+	}
+	if !updateCommit {
+		return
+	}
+	projectGit("rev-parse", "--git-dir")
+	if updateBranch == "" {
+		updateBranch = "deliver/update-" + time.Now().Format("20060102-150405")
+	}
+	if _, err := vcs.ExecuteCommand("git", "rev-parse", "--verify", "--quiet", "refs/heads/"+updateBranch); err == nil {
+		panic(errors.New(fmt.Sprintf("branch %s already exists", updateBranch)))
+	}
+	if updatePR {
+		location, ok := vcs.ParseSource(projectGit("remote", "get-url", UPDATE_REMOTE))
+		if !ok {
+			panic(errors.New(fmt.Sprintf("update -pr can't tell where %s is hosted", UPDATE_REMOTE)))
+		}
+		pullRequestToken(pullRequestProvider(location))
+	}
+	lockBeforeUpdate, _ = manifest.Load(manifest.LOCK_FILE)
+	if lockBeforeUpdate == nil {
+		lockBeforeUpdate = &manifest.Manifest{}
+	}
+}
+
+// Commits the manifest and lockfile the update changed to a new branch and,
+// with -push and -pr, pushes it and opens a pull request for it.
+func finishUpdatePR() {
+	if !updateCommit {
+		return
+	}
+	files := []string{}
+	for _, file := range []string{manifest.PACKAGE_FILE, manifest.LOCK_FILE} {
+		if projectGit("status", "--porcelain", "--", file) != "" {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stdout, "%s is up to date; there is nothing to commit\n", manifest.LOCK_FILE)
+		return
+	}
+
+	next, err := manifest.Load(manifest.LOCK_FILE)
+	if err != nil {
+		panic(err)
+	}
+	changes := lockChanges(lockBeforeUpdate, next)
+	title := "Update dependencies"
+	if len(changes) == 1 {
+		title = changes[0].Summary()
+	}
+	lines := []string{}
+	reviewers := []string{}
+	for _, change := range changes {
+		lines = append(lines, "- "+change.Summary()+ownersSuffix(change.Package()))
+		reviewers = append(reviewers, change.Package().GetOwners()...)
+	}
+	body := strings.Join(lines, "\n")
+	if reviewers = uniqueStrings(reviewers); len(reviewers) > 0 {
+		body += "\n\nReviewers: " + strings.Join(reviewers, " ")
+	}
+
+	projectGit("checkout", "-b", updateBranch)
+	projectGit(append([]string{"add", "--"}, files...)...)
+	projectGit(append([]string{"commit", "-m", title + "\n\n" + body, "--"}, files...)...)
+	fmt.Fprintf(os.Stdout, "committed %s to branch %s\n", strings.Join(files, " and "), colorize(os.Stdout, COLOR_BOLD, updateBranch))
+	if !updatePush {
+		return
+	}
+
+	projectGit("push", "--set-upstream", UPDATE_REMOTE, updateBranch)
+	fmt.Fprintf(os.Stdout, "pushed %s to %s\n", updateBranch, UPDATE_REMOTE)
+	if !updatePR {
+		return
+	}
+	link, err := openPullRequest(title, body)
+	if err != nil {
+		panic(errors.New(fmt.Sprintf("pushed %s, but could not open a pull request: %v", updateBranch, err)))
+	}
+	fmt.Fprintf(os.Stdout, "opened %s\n", link)
+}
+
+// Returns the branch pull requests are merged into: the remote's default
+// branch.
+func pullRequestBase() string {
+	if ref, err := vcs.ExecuteCommand("git", "symbolic-ref", "--short", "refs/remotes/"+UPDATE_REMOTE+"/HEAD"); err == nil {
+		return strings.TrimPrefix(strings.TrimSpace(ref), UPDATE_REMOTE+"/")
+	}
+	return "master"
+}
+
+// Opens a pull request, or a GitLab merge request, from the update branch
+// into the remote's default branch. Returns its web address.
+func openPullRequest(title, body string) (string, error) {
+	location, _ := vcs.ParseSource(projectGit("remote", "get-url", UPDATE_REMOTE))
+	provider := pullRequestProvider(location)
+	token := pullRequestToken(provider)
+
+	var apiUrl string
+	var request map[string]string
+	header := map[string]string{}
+	if provider == "github" {
+		apiUrl = fmt.Sprintf("https://%s/api/v3/repos/%s/pulls", location.Host, location.Path)
+		if location.Host == "github.com" {
+			apiUrl = fmt.Sprintf("https://api.github.com/repos/%s/pulls", location.Path)
+		}
+		request = map[string]string{"title": title, "body": body, "head": updateBranch, "base": pullRequestBase()}
+		header["Authorization"] = "Bearer " + token
+		header["Accept"] = "application/vnd.github+json"
+	} else {
+		apiUrl = fmt.Sprintf("https://%s/api/v4/projects/%s/merge_requests", location.Host, url.PathEscape(location.Path))
+		request = map[string]string{"title": title, "description": body, "source_branch": updateBranch, "target_branch": pullRequestBase()}
+		header["PRIVATE-TOKEN"] = token
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", apiUrl, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	if *verbose {
+		fmt.Fprintf(os.Stdout, "POST %s\n", apiUrl)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	answer, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		return "", errors.New(fmt.Sprintf("%s: %s: %s", apiUrl, resp.Status, strings.TrimSpace(string(answer))))
+	}
+	var created struct {
+		HtmlUrl string `json:"html_url"`
+		WebUrl  string `json:"web_url"`
+	}
+	json.Unmarshal(answer, &created)
+	if created.WebUrl != "" {
+		return created.WebUrl, nil
+	}
+	return created.HtmlUrl, nil
+}
@@ -0,0 +1,88 @@
+package vcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// The OSV API, which knows the commits of git repositories affected by
+// published vulnerabilities.
+const OSV_QUERY_URL string = "https://api.osv.dev/v1/query"
+
+// A published vulnerability.
+type Advisory struct {
+	ID      string
+	Summary string `json:",omitempty"`
+	Link    string
+	// Commits that fix the vulnerability, on the branches OSV knows of.
+	Fixed []string `json:",omitempty"`
+}
+
+// Returns the advisories OSV has for a commit. Repositories OSV doesn't track
+// have none.
+func AdvisoriesFor(revision string) ([]*Advisory, error) {
+	if NetworkDenied {
+		return nil, networkDeniedError("look up advisories at", OSV_QUERY_URL)
+	}
+	if Verbose {
+		fmt.Fprintln(os.Stdout, "query", OSV_QUERY_URL, revision)
+	}
+	query, err := json.Marshal(map[string]string{"commit": revision})
+	if err != nil {
+		return nil, err
+	}
+
+	defer waitForHost(OSV_QUERY_URL)()
+	resp, err := http.Post(OSV_QUERY_URL, "application/json", bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("%s: %s", OSV_QUERY_URL, resp.Status))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var answer struct {
+		Vulns []struct {
+			Id       string
+			Summary  string
+			Affected []struct {
+				Ranges []struct {
+					Type   string
+					Events []struct {
+						Fixed string
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &answer); err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %v", OSV_QUERY_URL, err))
+	}
+	advisories := []*Advisory{}
+	for _, vuln := range answer.Vulns {
+		advisory := &Advisory{ID: vuln.Id, Summary: vuln.Summary, Link: "https://osv.dev/vulnerability/" + vuln.Id}
+		for _, affected := range vuln.Affected {
+			for _, r := range affected.Ranges {
+				if r.Type != "GIT" {
+					continue
+				}
+				for _, event := range r.Events {
+					if event.Fixed != "" {
+						advisory.Fixed = append(advisory.Fixed, event.Fixed)
+					}
+				}
+			}
+		}
+		advisories = append(advisories, advisory)
+	}
+	return advisories, nil
+}
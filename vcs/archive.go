@@ -0,0 +1,259 @@
+package vcs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Replaced by the package's revision in its Archive URL.
+const ARCHIVE_REVISION string = "{revision}"
+
+// Credentials for downloading archives over http and https. If only ArchivePassword is set, it is
+// sent as a bearer token, which is how Artifactory and Nexus access tokens
+// are used.
+var ArchiveUser, ArchivePassword string
+
+// The host the archive credentials belong to. They are only sent to it, since
+// the Archive URLs in dependencies' lockfiles may point anywhere.
+var ArchiveHost string
+
+// Returns the URL of the archive of the package's revision.
+func ArchiveUrl(packageInfo *manifest.Package) string {
+	return strings.Replace(packageInfo.Archive, ARCHIVE_REVISION, packageInfo.Revision, -1)
+}
+
+// Installs the package's revision from its Archive URL, which points at a
+// versioned .tar.gz in a generic repository such as Artifactory or Nexus, or
+// in an S3 (s3://) or Google Cloud Storage (gs://) bucket. The archive is
+// checked against the SHA-256 checksum published for it before it is
+// unpacked.
+func DownloadArchive(packageInfo *manifest.Package, dir string) error {
+	if revision, ok := TarballRevision(dir); ok && revision == packageInfo.Revision {
+		return nil
+	}
+	archiveUrl := ArchiveUrl(packageInfo)
+	if NetworkDenied {
+		return networkDeniedError("download", archiveUrl)
+	}
+
+	if DryRun || Verbose {
+		fmt.Fprintln(os.Stdout, "download", archiveUrl)
+	}
+	if DryRun {
+		return nil
+	}
+
+	if err := os.MkdirAll(path.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(path.Dir(dir), ".deliver-archive-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	expected, err := downloadObject(archiveUrl, tmp.Name())
+	if err != nil {
+		return errors.New(fmt.Sprintf("could not download %s: %v", archiveUrl, err))
+	}
+	// Cloud tools may replace the file rather than write to it.
+	f, err := os.Open(tmp.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := verifyChecksum(archiveUrl, f, expected); err != nil {
+		return errors.New(fmt.Sprintf("could not download %s: %v", archiveUrl, err))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	strip, err := topLevelDirs(f)
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s is not a .tar.gz archive: %v", archiveUrl, err))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := replaceWithTarball(f, dir, strip); err != nil {
+		return errors.New(fmt.Sprintf("could not unpack %s: %v", archiveUrl, err))
+	}
+	return ioutil.WriteFile(path.Join(dir, TARBALL_REVISION_FILE), []byte(packageInfo.Revision+"\n"), 0644)
+}
+
+// Downloads the object at objectUrl into file. Buckets are read with the aws
+// and gcloud command-line tools, so the usual cloud credentials apply. Returns
+// the SHA-256 checksum the server sent along with the object, such as
+// Artifactory's X-Checksum-Sha256 header, if there was one.
+func downloadObject(objectUrl, file string) (string, error) {
+	defer waitForHost(objectUrl)()
+	switch {
+	case strings.HasPrefix(objectUrl, "s3://"):
+		_, err := ExecuteCommand("aws", "s3", "cp", "--only-show-errors", objectUrl, file)
+		return "", cloudError("aws", err)
+	case strings.HasPrefix(objectUrl, "gs://"):
+		_, err := ExecuteCommand("gcloud", "storage", "cp", "--quiet", objectUrl, file)
+		return "", cloudError("gcloud", err)
+	}
+
+	resp, err := archiveGet(objectUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("X-Checksum-Sha256"), nil
+}
+
+// Returns the contents of a small object, such as a checksum file.
+func readObject(objectUrl string) (string, error) {
+	defer waitForHost(objectUrl)()
+	switch {
+	case strings.HasPrefix(objectUrl, "s3://"):
+		out, err := ExecuteCommand("aws", "s3", "cp", "--only-show-errors", objectUrl, "-")
+		return out, cloudError("aws", err)
+	case strings.HasPrefix(objectUrl, "gs://"):
+		out, err := ExecuteCommand("gcloud", "storage", "cat", objectUrl)
+		return out, cloudError("gcloud", err)
+	}
+
+	resp, err := archiveGet(objectUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return string(data), err
+}
+
+// Adds what a cloud tool printed to its error, or says that it is missing.
+func cloudError(tool string, err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return errors.New(fmt.Sprintf("%s is not installed", tool))
+	}
+	return err
+}
+
+// Checks r against expected, a hex SHA-256 checksum. If expected is empty,
+// the checksum is read from the .sha256 file next to the archive.
+func verifyChecksum(archiveUrl string, r io.Reader, expected string) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+
+	if expected == "" {
+		data, err := readObject(archiveUrl + ".sha256")
+		if err != nil {
+			return errors.New(fmt.Sprintf("no checksum to verify it with: %v", err))
+		}
+		// The file may be in sha256sum's format, with the file name after
+		// the checksum.
+		if fields := strings.Fields(data); len(fields) > 0 {
+			expected = fields[0]
+		}
+	}
+	if !strings.EqualFold(expected, actual) {
+		return errors.New(fmt.Sprintf("checksum mismatch: expected sha256 %s, got %s", expected, actual))
+	}
+	return nil
+}
+
+// Requests url, with the archive credentials if it is on the archive host.
+func archiveGet(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(req.URL.Host, ArchiveHost) {
+		if ArchiveUser != "" {
+			req.SetBasicAuth(ArchiveUser, ArchivePassword)
+		} else if ArchivePassword != "" {
+			req.Header.Set("Authorization", "Bearer "+ArchivePassword)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("%s: %s", url, resp.Status))
+	}
+	return resp, nil
+}
+
+// Returns how many leading path elements to strip from the entries of a
+// gzipped tar stream: 1 if they are all inside one top-level directory, as
+// they are in archives made with git archive --prefix, and 0 otherwise. A
+// leading ./ counts as one more.
+func topLevelDirs(r io.Reader) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	top, dotted := "", false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		name := header.Name
+		if strings.HasPrefix(name, "./") {
+			name, dotted = name[2:], true
+		}
+		if name == "" {
+			continue
+		}
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) < 2 && header.Typeflag != tar.TypeDir {
+			// A file at the top level.
+			return 0, nil
+		}
+		if top != "" && parts[0] != top {
+			return 0, nil
+		}
+		top = parts[0]
+	}
+	if top == "" {
+		return 0, nil
+	}
+	if dotted {
+		return 2, nil
+	}
+	return 1, nil
+}
@@ -0,0 +1,39 @@
+package vcs
+
+import (
+	"os"
+	"path"
+)
+
+// Caches that were already brought up to date during this run.
+var updatedCaches = make(map[string]bool)
+
+// Creates the bare mirror of source at cachePath, or brings it up to date if
+// it already exists. Each cache is fetched at most once per run.
+func UpdateCache(source, cachePath string) error {
+	if updatedCaches[cachePath] {
+		return nil
+	}
+	updatedCaches[cachePath] = true
+
+	_, err := os.Stat(cachePath)
+	if NetworkDenied {
+		// Use the cache as it is.
+		if err != nil {
+			return networkDeniedError("clone", source)
+		}
+		return nil
+	}
+
+	defer waitForHost(source)()
+	if os.IsNotExist(err) {
+		if _, err := ExecuteCommand("mkdir", "-p", path.Dir(cachePath)); err != nil {
+			return err
+		}
+		_, err := ExecuteCommand("git", "clone", "--mirror", source, cachePath)
+		return err
+	}
+
+	_, err = RunInDirectory(cachePath, "git", "remote", "update", "--prune")
+	return err
+}
@@ -0,0 +1,49 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Returns the content hash a checksum database recorded for a package's
+// revision, and false if the database doesn't know the revision. The database
+// answers GET <db>/lookup/<package>@<revision> with lines of "<package>
+// <revision> <hash>", and with 404 or 410 for revisions it has never seen.
+func LookupChecksum(db, name, revision string) (string, bool, error) {
+	lookupUrl := fmt.Sprintf("%s/lookup/%s@%s", strings.TrimSuffix(db, "/"), name, revision)
+	if NetworkDenied {
+		return "", false, networkDeniedError("look up", lookupUrl)
+	}
+	if Verbose {
+		fmt.Fprintln(os.Stdout, "lookup", lookupUrl)
+	}
+
+	defer waitForHost(lookupUrl)()
+	resp, err := http.Get(lookupUrl)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return "", false, nil
+	default:
+		return "", false, errors.New(fmt.Sprintf("%s: %s", lookupUrl, resp.Status))
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == name && fields[1] == revision {
+			return fields[2], true, nil
+		}
+	}
+	return "", false, errors.New(fmt.Sprintf("%s: no hash for %s@%s in the answer", lookupUrl, name, revision))
+}
@@ -0,0 +1,63 @@
+// Package vcs fetches and checks out package sources: git repositories, the
+// shared repository cache and source archives.
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// If true, commands are printed but not run.
+var DryRun bool
+
+// If true, commands are printed while they run.
+var Verbose bool
+
+// Executes a shell command. Depending on DryRun and Verbose,
+// it may just print the command to run, or both print and
+// run the command.
+func ExecuteCommand(args ...string) (out string, err error) {
+	return RunInDirectory("", args...)
+}
+
+// Executes a shell command in dir, or in the current directory if dir is
+// empty. Returns the command's standard output.
+func RunInDirectory(dir string, args ...string) (out string, err error) {
+	return RunLabeled("", dir, args...)
+}
+
+// Same as RunInDirectory. In verbose mode, the command's output and errors
+// are shown as they are written, each line prefixed with [label].
+func RunLabeled(label string, dir string, args ...string) (out string, err error) {
+	prefix := ""
+	if label != "" {
+		prefix = "[" + label + "] "
+	}
+	if DryRun || Verbose {
+		fmt.Fprintf(os.Stdout, "%s%s\n", prefix, strings.Join(args, " "))
+	}
+	if DryRun {
+		return "", nil
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	if !Verbose {
+		outBytes, err := cmd.Output()
+		return string(outBytes), err
+	}
+
+	var outBuffer bytes.Buffer
+	stdout := NewPrefixWriter(os.Stdout, prefix, nil)
+	stderr := NewPrefixWriter(os.Stderr, prefix, nil)
+	cmd.Stdout = io.MultiWriter(&outBuffer, stdout)
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	return outBuffer.String(), err
+}
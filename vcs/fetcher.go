@@ -0,0 +1,38 @@
+package vcs
+
+import (
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Installs packages from one kind of source, such as git repositories or
+// source archives.
+type Fetcher interface {
+	// Names the fetcher in messages, e.g. "git".
+	Name() string
+	// Whether the fetcher can install the package into dest.
+	Supports(packageInfo *manifest.Package, dest string) bool
+	// Installs the package's revision into dest. Packages without a revision
+	// are installed at the newest one the fetcher can find, which is saved to
+	// packageInfo.
+	Fetch(packageInfo *manifest.Package, dest string) error
+}
+
+var fetchers []Fetcher
+
+// Adds a fetcher. Fetchers are tried in the order they are registered, so more
+// specific ones should be registered first.
+func RegisterFetcher(f Fetcher) {
+	fetchers = append(fetchers, f)
+}
+
+// Returns the registered fetchers that can install the package into dest, in
+// order.
+func FetchersFor(packageInfo *manifest.Package, dest string) []Fetcher {
+	var supported []Fetcher
+	for _, f := range fetchers {
+		if f.Supports(packageInfo, dest) {
+			supported = append(supported, f)
+		}
+	}
+	return supported
+}
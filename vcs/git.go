@@ -0,0 +1,634 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// How long a temporary clone directory must go unmodified before a clone of
+// the same package removes it.
+const abandonedCloneAge = 24 * time.Hour
+
+// Encapsulates commands to run on a git repository.
+type GitRepository struct {
+	// Label for the output of commands in verbose mode, usually the package
+	// name.
+	Name     string
+	RepoUrl  string
+	RepoPath string
+	// Shared bare repository for RepoUrl. It is used when Reference or
+	// Worktree is set, and is the only remote allowed when NetworkDenied is.
+	CachePath string
+	// If true, clones borrow objects from the repository at CachePath.
+	Reference bool
+	// If true, RepoPath is a worktree of the repository at CachePath.
+	Worktree bool
+	// If true, locked revisions are checked out on a local branch named after
+	// the package's branch instead of on a detached HEAD. Ignored for
+	// worktrees, which can't check out a branch another worktree has.
+	LocalBranch bool
+	// Extra arguments for git clone and git fetch, such as --filter=tree:0.
+	CloneArgs []string
+	FetchArgs []string
+	// Patterns, in the syntax of .gitignore, of files to leave out of the
+	// checkout.
+	Exclude []string
+	// Import paths to rewrite in the checkout's Go files, mapped to what to
+	// rewrite them to.
+	RewriteImports map[string]string
+}
+
+// Runs a command in dir, labeling its output with the repository's name.
+func (g *GitRepository) run(dir string, args ...string) (string, error) {
+	return RunLabeled(g.Name, dir, args...)
+}
+
+// Returns the path of a file in the checkout's git directory, which is
+// elsewhere for worktrees.
+func (g *GitRepository) gitPath(name string) (string, error) {
+	out, err := g.run(g.RepoPath, "git", "rev-parse", "--git-path", name)
+	if err != nil {
+		return "", err
+	}
+	file := strings.TrimSpace(out)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(g.RepoPath, file)
+	}
+	return file, nil
+}
+
+// Returns whether RepoPath holds a git checkout.
+func (g *GitRepository) IsCloned() bool {
+	_, err := os.Stat(path.Join(g.RepoPath, ".git"))
+	return err == nil
+}
+
+// Removes the checkout's .git directory, and those of its submodules, leaving
+// the checked out revision in TARBALL_REVISION_FILE as for a package installed
+// from an archive. A worktree is unregistered from the cache.
+func (g *GitRepository) RemoveMetadata() error {
+	revision, err := g.CurrentRevision()
+	if err != nil {
+		return err
+	}
+	gitDirs := []string{}
+	err = filepath.Walk(g.RepoPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == ".git" {
+			gitDirs = append(gitDirs, file)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := ExecuteCommand(append([]string{"rm", "-rf"}, gitDirs...)...); err != nil {
+		return err
+	}
+	if g.Worktree {
+		if _, err := g.run(g.CachePath, "git", "worktree", "prune"); err != nil {
+			return err
+		}
+	}
+	if DryRun {
+		return nil
+	}
+	return ioutil.WriteFile(path.Join(g.RepoPath, TARBALL_REVISION_FILE), []byte(revision+"\n"), 0644)
+}
+
+// Returns whether RepoPath looks like a checkout but has no commit checked
+// out, as happens when a clone is interrupted.
+func (g *GitRepository) IsBroken() bool {
+	if !g.IsCloned() {
+		return false
+	}
+	_, err := g.run(g.RepoPath, "git", "rev-parse", "--verify", "--quiet", "HEAD^{commit}")
+	return err != nil
+}
+
+// Returns the URL of the checkout's origin remote.
+func (g *GitRepository) OriginURL() (string, error) {
+	out, err := g.run(g.RepoPath, "git", "remote", "get-url", "origin")
+	return strings.TrimSpace(out), err
+}
+
+// Points the checkout's origin remote at RepoUrl.
+func (g *GitRepository) SetOriginURL() error {
+	_, err := g.run(g.RepoPath, "git", "remote", "set-url", "origin", g.RepoUrl)
+	return err
+}
+
+func (g *GitRepository) CurrentRevision() (string, error) {
+	revisionString, err := g.run(g.RepoPath, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	// Strip newline character at the end
+	if len(revisionString) > 0 {
+		return revisionString[:len(revisionString)-1], nil
+	} else {
+		return "<REV>", nil
+	}
+}
+
+// Abbreviates a revision for messages and tables.
+func ShortRevision(revision string) string {
+	if len(revision) > 12 {
+		return revision[:12]
+	}
+	return revision
+}
+
+// Returns the branch checked out, or "" if HEAD is detached.
+func (g *GitRepository) CurrentBranch() (string, error) {
+	out, err := g.run(g.RepoPath, "git", "symbolic-ref", "--quiet", "--short", "HEAD")
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return "", nil
+	}
+	return strings.TrimSpace(out), err
+}
+
+// Returns the tracked files with changes in the checkout, other than those
+// RewriteCheckoutImports changed.
+func (g *GitRepository) ModifiedFiles() ([]string, error) {
+	out, err := g.run(g.RepoPath, "git", "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return nil, err
+	}
+	rewritten := map[string]bool{}
+	if file, err := g.gitPath(REWRITTEN_FILE); err == nil {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			for _, name := range strings.Fields(string(data)) {
+				rewritten[name] = true
+			}
+		}
+	}
+	files := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) > 3 && !rewritten[line[3:]] {
+			files = append(files, line[3:])
+		}
+	}
+	return files, nil
+}
+
+// Returns the author and date of the checked out commit.
+func (g *GitRepository) LastCommit() (author, date string, err error) {
+	out, err := g.run(g.RepoPath, "git", "log", "-1", "--format=%an <%ae>%n%ad")
+	if err != nil {
+		return "", "", err
+	}
+	lines := strings.SplitN(strings.TrimSpace(out), "\n", 2)
+	if len(lines) < 2 {
+		return "<AUTHOR>", "<DATE>", nil
+	}
+	return lines[0], lines[1], nil
+}
+
+// Returns how many commits the checkout is behind the last fetched tip of
+// branch, or -1 if that can't be determined.
+func (g *GitRepository) CountBehind(branch string) int {
+	out, err := g.run(g.RepoPath, "git", "rev-list", "--count", "HEAD..origin/"+branch)
+	if err != nil {
+		// The branch may not exist on the remote.
+		return -1
+	}
+	var count int
+	if _, err := fmt.Sscan(out, &count); err != nil {
+		return -1
+	}
+	return count
+}
+
+// Returns the committer date of ref. The second value is false if ref does not
+// exist in the repository.
+func (g *GitRepository) CommitTime(ref string) (time.Time, bool) {
+	out, err := g.run(g.RepoPath, "git", "show", "-s", "--format=%ct", ref)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var seconds int64
+	if _, err := fmt.Sscan(out, &seconds); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// Returns the tags in the checkout that point at revision.
+func (g *GitRepository) TagsAt(revision string) []string {
+	if !g.IsCloned() {
+		return nil
+	}
+	out, err := g.run(g.RepoPath, "git", "tag", "--points-at", revision)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(out)
+}
+
+// Returns the revision at the tip of branch in the remote repository, without
+// fetching it. With the network denied, the cache is asked instead.
+func (g *GitRepository) RemoteRevision(branch string) (string, error) {
+	remote, err := g.remote("query")
+	if err != nil {
+		return "", err
+	}
+	done := waitForHost(remote)
+	out, err := g.run("", "git", "ls-remote", remote, "refs/heads/"+branch)
+	done()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("could not query %s: %v", remote, err))
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", errors.New(fmt.Sprintf("branch %s not found in %s", branch, remote))
+	}
+	return fields[0], nil
+}
+
+// Returns the commit each tag of the remote points to.
+func (g *GitRepository) RemoteTags() (map[string]string, error) {
+	remote, err := g.remote("query")
+	if err != nil {
+		return nil, err
+	}
+	done := waitForHost(remote)
+	out, err := g.run("", "git", "ls-remote", "--tags", remote)
+	done()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not query %s: %v", remote, err))
+	}
+	tags := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "refs/tags/") {
+			continue
+		}
+		tag := strings.TrimPrefix(fields[1], "refs/tags/")
+		if strings.HasSuffix(tag, "^{}") {
+			// The commit an annotated tag points to.
+			tags[strings.TrimSuffix(tag, "^{}")] = fields[0]
+		} else if _, ok := tags[tag]; !ok {
+			tags[tag] = fields[0]
+		}
+	}
+	return tags, nil
+}
+
+// Returns the contents of file at revision, read from the checkout or, if the
+// repository isn't cloned yet, from the cache. Returns false if the revision
+// has no such file, and an error if the revision isn't available locally.
+func (g *GitRepository) ReadFileAt(revision, file string) (string, bool, error) {
+	dir := g.RepoPath
+	if !g.IsCloned() {
+		dir = g.CachePath
+	}
+	if _, err := g.run(dir, "git", "cat-file", "-e", revision+"^{commit}"); err != nil {
+		return "", false, errors.New(fmt.Sprintf("revision %s of %s is not available locally", revision, g.RepoUrl))
+	}
+	if _, err := g.run(dir, "git", "cat-file", "-e", revision+":"+file); err != nil {
+		return "", false, nil
+	}
+	out, err := g.run(dir, "git", "show", revision+":"+file)
+	return out, err == nil, err
+}
+
+// Returns whether ancestor is revision or one of its ancestors. Both must be
+// present in the local repository.
+func (g *GitRepository) IsAncestor(ancestor, revision string) (bool, error) {
+	_, err := g.run(g.RepoPath, "git", "merge-base", "--is-ancestor", ancestor, revision)
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("could not compare %s with %s in %s", ancestor, revision, g.RepoPath))
+	}
+	return true, nil
+}
+
+// Returns whether revision is already present in the local repository.
+func (g *GitRepository) HasCommit(revision string) bool {
+	_, err := g.run(g.RepoPath, "git", "cat-file", "-e", revision+"^{commit}")
+	return err == nil
+}
+
+// Makes sure revision is in the local repository before it is checked out. A
+// revision the fetch didn't bring in, such as one only a shallow clone's
+// missing history or a deleted branch leads to, is fetched by itself, and
+// then with the full history. If it is still missing, upstream no longer has
+// it, usually because its history was rewritten.
+func (g *GitRepository) EnsureRevision(revision string) error {
+	if DryRun || g.HasCommit(revision) {
+		return nil
+	}
+	if NetworkDenied {
+		return networkDeniedError("fetch revision "+revision+" of", g.RepoUrl)
+	}
+	dir := g.RepoPath
+	if g.Worktree {
+		// The worktree's objects are the cache's.
+		dir = g.CachePath
+	}
+	fmt.Fprintf(os.Stdout, "[%s] %s is missing after the fetch; fetching it with the full history\n", g.Name, revision)
+	defer waitForHost(g.RepoUrl)()
+	// Servers that allow it send a commit asked for by its hash.
+	g.run(dir, "git", "fetch", "origin", revision)
+	if g.HasCommit(revision) {
+		return nil
+	}
+	args := []string{"git", "fetch", "--tags"}
+	if shallow, _ := g.run(dir, "git", "rev-parse", "--is-shallow-repository"); strings.TrimSpace(shallow) == "true" {
+		args = append(args, "--unshallow")
+	}
+	args = append(args, "origin")
+	if !g.Worktree {
+		args = append(args, "+refs/heads/*:refs/remotes/origin/*")
+	}
+	if _, err := g.run(dir, args...); err != nil {
+		return errors.New(fmt.Sprintf("revision %s of %s is missing, and fetching the full history failed: %v", revision, g.RepoUrl, err))
+	}
+	if g.HasCommit(revision) {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("revision %s vanished from %s: it isn't there even with the full history fetched, "+
+		"probably because upstream rewrote it. Run deliver update %s to lock a revision that exists", revision, g.RepoUrl, g.Name))
+}
+
+func (g *GitRepository) CheckoutRevision(revision ...string) error {
+	_, err := g.run(g.RepoPath, append([]string{"git", "checkout"}, revision...)...)
+	return err
+}
+
+// Checks out revision on the local branch named branch, created or reset to
+// it. A branch with commits that aren't on any remote is left alone, and
+// revision is checked out on a detached HEAD instead.
+func (g *GitRepository) CheckoutLocalBranch(branch, revision string) error {
+	if out, err := g.run(g.RepoPath, "git", "rev-list", "refs/heads/"+branch, "--not", "--remotes", "--"); err == nil && strings.TrimSpace(out) != "" {
+		fmt.Fprintf(os.Stdout, "[%s] branch %s has commits that aren't on any remote, so it is left alone and %s is checked out detached\n", g.Name, branch, revision)
+		return g.CheckoutRevision(revision)
+	}
+	return g.CheckoutRevision("-B", branch, revision)
+}
+
+func (g *GitRepository) CheckoutBranchTip(branch string) error {
+	if g.Worktree {
+		// The cache mirrors the remote branches, so its branch is already the
+		// tip. Detach so other worktrees can check out the same branch.
+		return g.CheckoutRevision("--detach", branch)
+	}
+	if err := g.CheckoutRevision(branch); err != nil {
+		return err
+	}
+	return g.PullBranch(branch)
+}
+
+// Pulls the git repo from origin in the given repo path.
+func (g *GitRepository) PullBranch(branch string) error {
+	remote := "origin"
+	if NetworkDenied {
+		var err error
+		if remote, err = g.remote("pull"); err != nil {
+			return err
+		}
+	}
+	if remote == "origin" {
+		defer waitForHost(g.RepoUrl)()
+	}
+	_, err := g.run(g.RepoPath, "git", "pull", remote, branch)
+	return err
+}
+
+// Clones the git repo into the given directory.
+func (g *GitRepository) Clone(destinationPath, branch string) error {
+	if g.Worktree {
+		if err := UpdateCache(g.RepoUrl, g.CachePath); err != nil {
+			return err
+		}
+		// Forget worktrees whose directories were deleted, so the path can
+		// be registered again.
+		if _, err := g.run(g.CachePath, "git", "worktree", "prune"); err != nil {
+			return err
+		}
+		_, err := g.run(g.CachePath, "git", "worktree", "add", "--detach", destinationPath, branch)
+		return err
+	}
+
+	args := []string{"git", "clone", "-b", branch}
+	if Verbose {
+		args = append(args, "--progress")
+	}
+	args = append(args, g.CloneArgs...)
+	if g.Reference {
+		if err := UpdateCache(g.RepoUrl, g.CachePath); err != nil {
+			return err
+		}
+		args = append(args, "--reference", g.CachePath)
+	}
+	remote, err := g.remote("clone")
+	if err != nil {
+		return err
+	}
+
+	// Clone next to the destination and move the clone into place once it is
+	// complete, so an interrupted clone doesn't leave a directory that looks
+	// like a checkout. The temporary directory is hidden from the go tool.
+	cloneDir := destinationPath
+	if !DryRun {
+		pattern := "." + path.Base(destinationPath) + ".clone-"
+		// Clean up after clones that were killed before they could, leaving
+		// those another process may still be writing.
+		stale, _ := filepath.Glob(path.Join(path.Dir(destinationPath), pattern+"*"))
+		for _, dir := range stale {
+			if abandoned(dir, abandonedCloneAge) {
+				os.RemoveAll(dir)
+			}
+		}
+		tempDir, err := ioutil.TempDir(path.Dir(destinationPath), pattern)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tempDir)
+		cloneDir = path.Join(tempDir, path.Base(destinationPath))
+	}
+
+	args = append(args, remote, cloneDir)
+	done := waitForHost(remote)
+	_, err = g.run("", args...)
+	done()
+	if err != nil {
+		return err
+	}
+	if remote != g.RepoUrl {
+		// Cloned from the cache, but later fetches should use the real source.
+		if _, err := g.run(cloneDir, "git", "remote", "set-url", "origin", g.RepoUrl); err != nil {
+			return err
+		}
+	}
+	if cloneDir == destinationPath {
+		return nil
+	}
+	// Only an empty directory, such as one made for the clone, is replaced.
+	if err := os.Remove(destinationPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(cloneDir, destinationPath)
+}
+
+// Fetches the current repository.
+func (g *GitRepository) Fetch() error {
+	if g.Reference || g.Worktree {
+		// Objects already in the cache don't need to be fetched again.
+		if err := UpdateCache(g.RepoUrl, g.CachePath); err != nil {
+			return err
+		}
+	}
+	if g.Worktree {
+		// Worktrees share the cache's refs, so there is nothing else to fetch.
+		return nil
+	}
+	if NetworkDenied {
+		// Fetch from the cache if there is one. Otherwise the checkout has to
+		// make do with what it already has.
+		if _, err := os.Stat(g.CachePath); err == nil {
+			_, err := g.run(g.RepoPath, "git", "fetch", g.CachePath, "+refs/heads/*:refs/remotes/origin/*")
+			return err
+		}
+		return nil
+	}
+	args := []string{"git", "fetch"}
+	if Verbose {
+		args = append(args, "--progress")
+	}
+	args = append(args, g.FetchArgs...)
+	defer waitForHost(g.RepoUrl)()
+	_, err := g.run(g.RepoPath, args...)
+	return err
+}
+
+// Fetches only what checking out revision needs, rather than every branch and
+// tag: nothing if revision is a commit hash that is already present, or else
+// just that commit, or the tag of that name. Falls back to Fetch if the remote
+// won't send it on its own, as servers that don't allow fetching unadvertised
+// commits do.
+func (g *GitRepository) FetchRevision(revision string) error {
+	if g.Reference || g.Worktree || NetworkDenied {
+		// The cache is fetched as a whole.
+		return g.Fetch()
+	}
+	isHash := fullRevisionPattern.MatchString(revision)
+	if isHash && g.HasCommit(revision) {
+		return nil
+	}
+	refspec := revision
+	if !isHash {
+		refspec = "+refs/tags/" + revision + ":refs/tags/" + revision
+	}
+	args := append(append([]string{"git", "fetch"}, g.FetchArgs...), "origin", refspec)
+	done := waitForHost(g.RepoUrl)
+	_, err := g.run(g.RepoPath, args...)
+	done()
+	if err != nil || !g.HasCommit(revision) {
+		if Verbose {
+			fmt.Fprintf(os.Stdout, "[%s] could not fetch %s by itself; fetching everything\n", g.Name, revision)
+		}
+		return g.Fetch()
+	}
+	return nil
+}
+
+// Returns whether the checkout's submodules should be checked out: as the
+// package says, or if it has any.
+func (g *GitRepository) UsesSubmodules(packageInfo *manifest.Package) bool {
+	if packageInfo.Submodules != nil {
+		return *packageInfo.Submodules
+	}
+	_, err := os.Stat(path.Join(g.RepoPath, ".gitmodules"))
+	return err == nil
+}
+
+// Checks out the submodules at the commits the checked out revision records
+// for them, recursively.
+func (g *GitRepository) UpdateSubmodules() error {
+	args := []string{"git", "submodule", "update", "--init", "--recursive"}
+	if NetworkDenied {
+		args = append(args, "--no-fetch")
+	}
+	_, err := g.run(g.RepoPath, args...)
+	return err
+}
+
+// Checks out the package's locked revision, or the tip of its branch if it
+// isn't locked. In the latter case, the new revision is saved to packageInfo.
+// Submodules are checked out too if the package uses them, excluded files are
+// removed and imports are rewritten.
+func (g *GitRepository) Update(packageInfo *manifest.Package) error {
+	if NetworkDenied && packageInfo.HasRevision() && !DryRun && !g.HasCommit(packageInfo.Revision) {
+		return networkDeniedError("fetch revision "+packageInfo.Revision+" of", g.RepoUrl)
+	}
+	if err := g.RestoreRewrittenImports(); err != nil {
+		return err
+	}
+	if packageInfo.HasRevision() {
+		if err := g.EnsureRevision(packageInfo.Revision); err != nil {
+			return err
+		}
+		var err error
+		if g.LocalBranch && !g.Worktree {
+			err = g.CheckoutLocalBranch(packageInfo.GetBranch(), packageInfo.Revision)
+		} else {
+			err = g.CheckoutRevision(packageInfo.Revision)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := g.CheckoutBranchTip(packageInfo.GetBranch()); err != nil {
+			return err
+		}
+		revision, err := g.CurrentRevision()
+		if err != nil {
+			return err
+		}
+		packageInfo.Revision = revision
+	}
+	if err := g.ApplyExcludes(); err != nil {
+		return err
+	}
+	if g.UsesSubmodules(packageInfo) {
+		if err := g.UpdateSubmodules(); err != nil {
+			return err
+		}
+	}
+	return g.RewriteCheckoutImports()
+}
+
+// Returns whether nothing in dir has been modified for age, so whatever was
+// writing it has stopped.
+func abandoned(dir string, age time.Duration) bool {
+	cutoff := time.Now().Add(-age)
+	recent := errors.New("modified recently")
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			return recent
+		}
+		return nil
+	})
+	return err == nil
+}
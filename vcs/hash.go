@@ -0,0 +1,132 @@
+package vcs
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Returns the content hash of a package's files: "h1:" and the base64 SHA-256
+// of a summary listing the SHA-256 of every file, sorted by path. Symlinks
+// count as their targets. The hash is the same whether the package was
+// checked out with git or unpacked from an archive of the same revision.
+func contentHash(files map[string]string) string {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	summary := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(summary, "%s  %s\n", files[name], name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil))
+}
+
+func sha256Hex(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Returns the content hash of the files of revision, as git archive exports
+// them.
+func (g *GitRepository) ContentHash(revision string) (string, error) {
+	args := []string{"git", "archive", "--format=tar", revision}
+	if Verbose {
+		fmt.Fprintf(os.Stdout, "[%s] %s\n", g.Name, strings.Join(args, " "))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = g.RepoPath
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(out)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cmd.Wait()
+			return "", err
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			sum, err := sha256Hex(tr)
+			if err != nil {
+				cmd.Wait()
+				return "", err
+			}
+			files[header.Name] = sum
+		case tar.TypeSymlink:
+			sum, _ := sha256Hex(strings.NewReader(header.Linkname))
+			files[header.Name] = sum
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return "", errors.New(fmt.Sprintf("git archive %s: %v: %s", revision, err, strings.TrimSpace(stderr.String())))
+	}
+	return contentHash(files), nil
+}
+
+// Returns the content hash of a package installed from an archive, leaving
+// out the file deliver records its revision in.
+func DirContentHash(dir string) (string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		switch {
+		case info.IsDir() && info.Name() == ".git":
+			return filepath.SkipDir
+		case name == TARBALL_REVISION_FILE:
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			files[name], _ = sha256Hex(strings.NewReader(target))
+		case info.Mode().IsRegular():
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if files[name], err = sha256Hex(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return contentHash(files), nil
+}
@@ -0,0 +1,128 @@
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// How long to wait for a host to present its key.
+const HOST_KEY_TIMEOUT = 10 * time.Second
+
+// SSH key types in the order their fingerprints are preferred.
+var sshKeyTypes = []string{"ssh-ed25519", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521", "ssh-rsa"}
+
+// Returns the endpoint source is fetched from, such as ssh://github.com or
+// https://git.example.com:8443, whose key can be pinned. Local sources and
+// plain http and git URLs have no key, and are reported as not ok.
+func HostEndpoint(source string) (string, bool) {
+	location, ok := ParseSource(source)
+	if !ok {
+		return "", false
+	}
+	host := location.Host
+	if strings.Contains(source, "://") {
+		// ParseSource drops the port.
+		if u, err := url.Parse(source); err == nil {
+			host = strings.ToLower(u.Host)
+		}
+	}
+	switch location.Scheme {
+	case "ssh", "git+ssh", "ssh+git":
+		return "ssh://" + host, true
+	case "https":
+		return "https://" + host, true
+	}
+	return "", false
+}
+
+// Returns the fingerprint of the key an endpoint from HostEndpoint presents:
+// its SSH host key, such as "ssh-ed25519 SHA256:...", or the public key of
+// its TLS certificate, as "tls SHA256:...". The fingerprints have the format
+// ssh-keygen -l prints. The key is read with ssh-keyscan or a TLS handshake
+// of its own, not from the connection git uses.
+func HostFingerprint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "https" {
+		return tlsFingerprint(u.Host)
+	}
+	return sshFingerprint(u.Hostname(), u.Port())
+}
+
+func fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func sshFingerprint(host, port string) (string, error) {
+	args := []string{"ssh-keyscan", "-T", fmt.Sprint(int(HOST_KEY_TIMEOUT.Seconds()))}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, host)
+	if Verbose {
+		fmt.Fprintf(os.Stdout, "%s\n", strings.Join(args, " "))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*HOST_KEY_TIMEOUT)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("ssh-keyscan %s: %v", host, err))
+	}
+
+	// Each line is "host type base64-key".
+	keys := map[string][]byte{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		keys[fields[1]] = key
+	}
+	for _, keyType := range sshKeyTypes {
+		if key, ok := keys[keyType]; ok {
+			return keyType + " " + fingerprint(key), nil
+		}
+	}
+	return "", errors.New(fmt.Sprintf("%s presented no SSH host key", host))
+}
+
+func tlsFingerprint(address string) (string, error) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+	if Verbose {
+		fmt.Fprintf(os.Stdout, "tls dial %s\n", address)
+	}
+	// The certificate chain is checked by git; only the key is wanted here.
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: HOST_KEY_TIMEOUT}, "tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	certificates := conn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return "", errors.New(fmt.Sprintf("%s presented no certificate", address))
+	}
+	return "tls " + fingerprint(certificates[0].RawSubjectPublicKeyInfo), nil
+}
@@ -0,0 +1,124 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How hard deliver may work a host, so downloads stay within the limits of
+// providers such as GitHub and don't overload internal git servers.
+type HostLimit struct {
+	// Most operations to run against the host at once. 0 means no limit.
+	Connections int
+	// Least time between the starts of operations against the host.
+	Interval time.Duration
+}
+
+// Limits by host name. The limit for "*" applies to hosts without their own.
+var HostLimits map[string]*HostLimit
+
+// Parses limits written as host=connections/interval, separated by commas,
+// such as "github.com=4/250ms,*=8". Either part may be left out, as in
+// "git.example.com=/1s".
+func ParseHostLimits(spec string) (map[string]*HostLimit, error) {
+	limits := make(map[string]*HostLimit)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, value := entry, ""
+		if i := strings.IndexByte(entry, '='); i >= 0 {
+			host, value = entry[:i], entry[i+1:]
+		}
+		connections, interval := value, ""
+		if i := strings.IndexByte(value, '/'); i >= 0 {
+			connections, interval = value[:i], value[i+1:]
+		}
+		if host == "" || (connections == "" && interval == "") {
+			return nil, errors.New(fmt.Sprintf("invalid host limit %q: must be host=connections/interval", entry))
+		}
+		limit := &HostLimit{}
+		if connections != "" {
+			n, err := strconv.Atoi(connections)
+			if err != nil || n < 1 {
+				return nil, errors.New(fmt.Sprintf("invalid host limit %q: connections must be a positive number", entry))
+			}
+			limit.Connections = n
+		}
+		if interval != "" {
+			d, err := time.ParseDuration(interval)
+			if err != nil || d < 0 {
+				return nil, errors.New(fmt.Sprintf("invalid host limit %q: interval must be a duration such as 500ms", entry))
+			}
+			limit.Interval = d
+		}
+		limits[strings.ToLower(host)] = limit
+	}
+	return limits, nil
+}
+
+// What is in flight against a host.
+type hostState struct {
+	slots chan bool
+	mutex sync.Mutex
+	// When the next operation may start.
+	next time.Time
+}
+
+var hostStatesMutex sync.Mutex
+var hostStates = map[string]*hostState{}
+
+// Waits until an operation against the host of source may start, and returns
+// the function to call once it is done. Local paths are not limited.
+func waitForHost(source string) func() {
+	location, ok := ParseSource(source)
+	if !ok {
+		return func() {}
+	}
+	limit, ok := HostLimits[location.Host]
+	if !ok {
+		if limit, ok = HostLimits["*"]; !ok {
+			return func() {}
+		}
+	}
+
+	hostStatesMutex.Lock()
+	state, ok := hostStates[location.Host]
+	if !ok {
+		state = &hostState{}
+		if limit.Connections > 0 {
+			state.slots = make(chan bool, limit.Connections)
+		}
+		hostStates[location.Host] = state
+	}
+	hostStatesMutex.Unlock()
+
+	if state.slots != nil {
+		state.slots <- true
+	}
+	state.mutex.Lock()
+	wait := time.Until(state.next)
+	if wait < 0 {
+		wait = 0
+	}
+	state.next = time.Now().Add(wait + limit.Interval)
+	state.mutex.Unlock()
+	if wait > 0 {
+		if Verbose {
+			fmt.Fprintf(os.Stdout, "waiting %v before contacting %s\n", wait.Round(time.Millisecond), location.Host)
+		}
+		time.Sleep(wait)
+	}
+
+	return func() {
+		if state.slots != nil {
+			<-state.slots
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package vcs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Credentials for a host, in the fields of git's credential helper protocol.
+type Credential struct {
+	Protocol string
+	Host     string
+	Username string
+	Password string
+}
+
+// Returns the URL the credential is for, such as https://github.com.
+func (c *Credential) Url() string {
+	return c.Protocol + "://" + c.Host
+}
+
+// Where deliver keeps the tokens it is asked to remember: the macOS Keychain,
+// the Secret Service through libsecret, or, where it is installed, Git
+// Credential Manager, which keeps them in the Windows Credential Manager.
+type Keychain interface {
+	Name() string
+	// Returns the stored username and password, or false if there are none.
+	Get(c *Credential) bool
+	Store(c *Credential) error
+	Erase(c *Credential) error
+}
+
+// Returns the keychain of this system, or nil if it has none deliver can use.
+func SystemKeychain() Keychain {
+	var keychain Keychain
+	switch {
+	case runtime.GOOS == "darwin":
+		keychain = macKeychain{}
+	case hasCommand("secret-tool"):
+		keychain = secretServiceKeychain{}
+	case hasCommand("git-credential-manager") || hasCommand("git-credential-manager-core"):
+		keychain = credentialManagerKeychain{}
+	}
+	return keychain
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// Runs a keychain tool with stdin as its input. Returns its output, and its
+// error output as the error if it fails.
+func runKeychain(stdin string, args ...string) (string, error) {
+	return runKeychainWithEnv(stdin, nil, args...)
+}
+
+func runKeychainWithEnv(stdin string, env []string, args ...string) (string, error) {
+	if Verbose {
+		fmt.Fprintf(os.Stderr, "%s\n", strings.Join(args, " "))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		return string(out), errors.New(strings.TrimSpace(stderr.String()))
+	}
+	return string(out), err
+}
+
+// Entries are kept under one service per URL, so they don't clash with the
+// ones git's own helpers keep.
+func keychainService(c *Credential) string {
+	return "deliver " + c.Url()
+}
+
+type macKeychain struct{}
+
+var macAccountPattern = regexp.MustCompile(`"acct"<blob>="(.*)"`)
+
+func (macKeychain) Name() string { return "the macOS Keychain" }
+
+func (macKeychain) Get(c *Credential) bool {
+	out, err := runKeychain("", "security", "find-generic-password", "-s", keychainService(c), "-g")
+	if err != nil {
+		return false
+	}
+	m := macAccountPattern.FindStringSubmatch(out)
+	if m == nil {
+		return false
+	}
+	password, err := runKeychain("", "security", "find-generic-password", "-s", keychainService(c), "-a", m[1], "-w")
+	if err != nil {
+		return false
+	}
+	c.Username, c.Password = m[1], strings.TrimRight(password, "\n")
+	return true
+}
+
+func (macKeychain) Store(c *Credential) error {
+	// The command is read from stdin with -i, so the password isn't on the
+	// command line for other users to see. -U replaces an existing entry.
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		securityQuote(keychainService(c)), securityQuote(c.Username), securityQuote(c.Password))
+	if _, err := runKeychain(command, "security", "-i"); err != nil {
+		return err
+	}
+	// security -i doesn't fail when a command it reads does.
+	if _, err := runKeychain("", "security", "find-generic-password", "-s", keychainService(c), "-a", c.Username); err != nil {
+		return errors.New(fmt.Sprintf("the entry was not stored: %v", err))
+	}
+	return nil
+}
+
+// Quotes an argument for a command read by security -i.
+func securityQuote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+func (macKeychain) Erase(c *Credential) error {
+	_, err := runKeychain("", "security", "delete-generic-password", "-s", keychainService(c))
+	return err
+}
+
+type secretServiceKeychain struct{}
+
+func (secretServiceKeychain) Name() string { return "the Secret Service keyring" }
+
+func (secretServiceKeychain) Get(c *Credential) bool {
+	// search prints the attributes and the secret of each match.
+	out, err := runKeychain("", "secret-tool", "search", "service", keychainService(c))
+	if err != nil {
+		return false
+	}
+	found := &Credential{}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(line, " = ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "attribute.user":
+			found.Username = parts[1]
+		case "secret":
+			found.Password = parts[1]
+		}
+	}
+	if found.Username == "" || found.Password == "" {
+		return false
+	}
+	c.Username, c.Password = found.Username, found.Password
+	return true
+}
+
+func (secretServiceKeychain) Store(c *Credential) error {
+	_, err := runKeychain(c.Password, "secret-tool", "store", "--label", "deliver: "+c.Url(),
+		"service", keychainService(c), "user", c.Username)
+	return err
+}
+
+func (secretServiceKeychain) Erase(c *Credential) error {
+	_, err := runKeychain("", "secret-tool", "clear", "service", keychainService(c))
+	return err
+}
+
+type credentialManagerKeychain struct{}
+
+func (credentialManagerKeychain) Name() string { return "Git Credential Manager" }
+
+// Git Credential Manager speaks git's credential helper protocol itself.
+func (credentialManagerKeychain) run(action string, c *Credential) (string, error) {
+	command := "git-credential-manager"
+	if !hasCommand(command) {
+		command = "git-credential-manager-core"
+	}
+	// Never let it open a window or prompt on the terminal.
+	return runKeychainWithEnv(FormatCredential(c), []string{"GCM_INTERACTIVE=never"}, command, action)
+}
+
+func (k credentialManagerKeychain) Get(c *Credential) bool {
+	out, err := k.run("get", c)
+	if err != nil {
+		return false
+	}
+	found := ParseCredential(out)
+	if found.Username == "" || found.Password == "" {
+		return false
+	}
+	c.Username, c.Password = found.Username, found.Password
+	return true
+}
+
+func (k credentialManagerKeychain) Store(c *Credential) error {
+	_, err := k.run("store", c)
+	return err
+}
+
+func (k credentialManagerKeychain) Erase(c *Credential) error {
+	_, err := k.run("erase", c)
+	return err
+}
+
+// Parses the key=value lines of git's credential helper protocol.
+func ParseCredential(text string) *Credential {
+	c := &Credential{}
+	for _, line := range strings.Split(text, "\n") {
+		parts := strings.SplitN(strings.TrimRight(line, "\r"), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "protocol":
+			c.Protocol = parts[1]
+		case "host":
+			c.Host = parts[1]
+		case "username":
+			c.Username = parts[1]
+		case "password":
+			c.Password = parts[1]
+		}
+	}
+	return c
+}
+
+// Returns the credential in the format of git's credential helper protocol,
+// leaving out empty fields.
+func FormatCredential(c *Credential) string {
+	var buf bytes.Buffer
+	for _, field := range [][2]string{{"protocol", c.Protocol}, {"host", c.Host}, {"username", c.Username}, {"password", c.Password}} {
+		if field[1] != "" {
+			fmt.Fprintf(&buf, "%s=%s\n", field[0], field[1])
+		}
+	}
+	buf.WriteString("\n")
+	return buf.String()
+}
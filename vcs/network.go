@@ -0,0 +1,52 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// If true, remote repositories are never contacted. Only the cache, existing
+// checkouts and bundles are used.
+var NetworkDenied bool
+
+// Returns the error for an operation that would need network access.
+func networkDeniedError(operation, source string) error {
+	return errors.New(fmt.Sprintf("cannot %s %s: network access is denied (-network=deny). "+
+		"Populate the cache (-reference_cache) or restore from a bundle while the network is allowed.", operation, source))
+}
+
+// Returns where to clone or pull the repository from. With the network
+// denied, that is the repository's cache, and it is an error if there is none.
+func (g *GitRepository) remote(operation string) (string, error) {
+	if !NetworkDenied {
+		return g.RepoUrl, nil
+	}
+	if _, err := os.Stat(g.CachePath); err != nil {
+		return "", networkDeniedError(operation, g.RepoUrl)
+	}
+	return g.CachePath, nil
+}
+
+// Returns the body of a GET of url, failing unless the answer is 200 OK.
+func FetchUrl(url string) ([]byte, error) {
+	if NetworkDenied {
+		return nil, networkDeniedError("fetch", url)
+	}
+	if Verbose {
+		fmt.Fprintln(os.Stdout, "fetch", url)
+	}
+
+	defer waitForHost(url)()
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("%s: %s", url, resp.Status))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,55 @@
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Serializes lines written by PrefixWriters that don't have their own mutex.
+var outputMutex sync.Mutex
+
+// Writes complete lines to an underlying writer, each starting with a prefix.
+// A carriage return also ends a line, so progress meters keep working.
+// Writers sharing a mutex never interleave their lines.
+type PrefixWriter struct {
+	w      io.Writer
+	prefix string
+	mutex  *sync.Mutex
+	buffer bytes.Buffer
+}
+
+// Returns a writer that prefixes each line written to w. If mutex is nil, the
+// writer shares one with every other PrefixWriter in this package.
+func NewPrefixWriter(w io.Writer, prefix string, mutex *sync.Mutex) *PrefixWriter {
+	if mutex == nil {
+		mutex = &outputMutex
+	}
+	return &PrefixWriter{w: w, prefix: prefix, mutex: mutex}
+}
+
+func (p *PrefixWriter) Write(data []byte) (int, error) {
+	p.buffer.Write(data)
+	for {
+		i := bytes.IndexAny(p.buffer.Bytes(), "\r\n")
+		if i < 0 {
+			return len(data), nil
+		}
+		p.writeLine(p.buffer.Next(i + 1))
+	}
+}
+
+// Writes out a final line that had no trailing newline.
+func (p *PrefixWriter) Flush() {
+	if p.buffer.Len() > 0 {
+		p.writeLine(append(p.buffer.Bytes(), '\n'))
+		p.buffer.Reset()
+	}
+}
+
+func (p *PrefixWriter) writeLine(line []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+}
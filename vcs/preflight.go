@@ -0,0 +1,51 @@
+package vcs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Checks that source can be reached and read with the credentials at hand,
+// by listing its HEAD. Fails rather than prompting for a password or a host
+// key, and gives up after timeout. If prompt is set, git may ask for a
+// password, and there is no time limit.
+func CheckRemote(source string, timeout time.Duration, prompt bool) error {
+	defer waitForHost(source)()
+	if Verbose {
+		fmt.Fprintf(os.Stdout, "git ls-remote %s HEAD\n", source)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if prompt {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", source, "HEAD")
+	cmd.Env = os.Environ()
+	if !prompt {
+		cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0")
+	}
+	if os.Getenv("GIT_SSH_COMMAND") == "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New(fmt.Sprintf("no answer after %v", timeout))
+	}
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if message := strings.TrimSpace(lines[0]); message != "" {
+			// The first line names the problem; the rest is advice.
+			return errors.New(message)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PROTOCOL_SSH       = "ssh"
+	PROTOCOL_HTTPS     = "https"
+	PROTOCOL_SSH_HTTPS = "ssh+https"
+)
+
+// How long to wait for a host to answer over SSH before falling back to
+// HTTPS.
+const SSH_PROBE_TIMEOUT = 30 * time.Second
+
+// How to fetch from each host, by host name, with "*" for the rest. Hosts
+// without a preference are fetched from as their sources are written.
+var ProtocolPreferences map[string]string
+
+// Parses preferences written as host=protocol, separated by commas, such as
+// "github.com=https,*=ssh+https".
+func ParseProtocolPreferences(spec string) (map[string]string, error) {
+	preferences := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New(fmt.Sprintf("invalid protocol preference %q: must be host=protocol", entry))
+		}
+		switch parts[1] {
+		case PROTOCOL_SSH, PROTOCOL_HTTPS, PROTOCOL_SSH_HTTPS:
+		default:
+			return nil, errors.New(fmt.Sprintf("invalid protocol preference %q: the protocol must be %s, %s or %s",
+				entry, PROTOCOL_SSH, PROTOCOL_HTTPS, PROTOCOL_SSH_HTTPS))
+		}
+		preferences[strings.ToLower(parts[0])] = parts[1]
+	}
+	return preferences, nil
+}
+
+var gitConfigMutex sync.Mutex
+
+// Sets a git config value for every git command deliver runs from now on,
+// through the environment, which needs git 2.31 or later.
+func AddGitConfig(key, value string) {
+	gitConfigMutex.Lock()
+	defer gitConfigMutex.Unlock()
+	count, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	os.Setenv(fmt.Sprintf("GIT_CONFIG_KEY_%d", count), key)
+	os.Setenv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", count), value)
+	os.Setenv("GIT_CONFIG_COUNT", strconv.Itoa(count+1))
+}
+
+var protocolMutex sync.Mutex
+
+// The protocol chosen for each host with a preference.
+var chosenProtocols = map[string]string{}
+
+func sshPrefix(host string) string   { return "git@" + host + ":" }
+func httpsPrefix(host string) string { return "https://" + host + "/" }
+
+// Picks the protocol to fetch source's host with, the first time it is
+// called for the host, and has git rewrite the host's URLs to it: SSH URLs
+// are fetched as https://host/path, and HTTPS URLs as git@host:path. With
+// ssh+https, SSH is used if the source can be listed over it, and HTTPS
+// otherwise, as on machines without SSH keys.
+func ChooseProtocol(source string) {
+	location, ok := ParseSource(source)
+	if !ok || (location.Scheme != "ssh" && location.Scheme != "https") {
+		return
+	}
+	preference, ok := ProtocolPreferences[location.Host]
+	if !ok {
+		if preference, ok = ProtocolPreferences["*"]; !ok {
+			return
+		}
+	}
+
+	protocolMutex.Lock()
+	defer protocolMutex.Unlock()
+	if _, ok := chosenProtocols[location.Host]; ok {
+		return
+	}
+	protocol := preference
+	if preference == PROTOCOL_SSH_HTTPS {
+		protocol = PROTOCOL_SSH
+		if !NetworkDenied {
+			sshSource := sshPrefix(location.Host) + location.Path + ".git"
+			if err := CheckRemote(sshSource, SSH_PROBE_TIMEOUT, false); err != nil {
+				fmt.Fprintf(os.Stdout, "%s can't be reached over SSH (%v), using HTTPS\n", location.Host, err)
+				protocol = PROTOCOL_HTTPS
+			}
+		}
+	}
+	chosenProtocols[location.Host] = protocol
+
+	if protocol == PROTOCOL_HTTPS {
+		AddGitConfig("url."+httpsPrefix(location.Host)+".insteadOf", sshPrefix(location.Host))
+		AddGitConfig("url."+httpsPrefix(location.Host)+".insteadOf", "ssh://git@"+location.Host+"/")
+	} else {
+		AddGitConfig("url."+sshPrefix(location.Host)+".insteadOf", httpsPrefix(location.Host))
+	}
+}
+
+// Returns source as git fetches it, once ChooseProtocol has picked the
+// protocol for its host.
+func PreferredSource(source string) string {
+	location, ok := ParseSource(source)
+	if !ok {
+		return source
+	}
+	protocolMutex.Lock()
+	protocol := chosenProtocols[location.Host]
+	protocolMutex.Unlock()
+	switch protocol {
+	case PROTOCOL_HTTPS:
+		for _, prefix := range []string{sshPrefix(location.Host), "ssh://git@" + location.Host + "/"} {
+			if strings.HasPrefix(source, prefix) {
+				return httpsPrefix(location.Host) + strings.TrimPrefix(source, prefix)
+			}
+		}
+	case PROTOCOL_SSH:
+		if strings.HasPrefix(source, httpsPrefix(location.Host)) {
+			return sshPrefix(location.Host) + strings.TrimPrefix(source, httpsPrefix(location.Host))
+		}
+	}
+	return source
+}
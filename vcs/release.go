@@ -0,0 +1,162 @@
+package vcs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Returns the name of the binary's release asset for this platform.
+func ReleaseAssetName(binary *manifest.Binary) string {
+	return strings.NewReplacer(
+		"{os}", runtime.GOOS,
+		"{arch}", runtime.GOARCH,
+		"{tag}", binary.Tag,
+		"{version}", strings.TrimPrefix(binary.Tag, "v"),
+	).Replace(binary.Asset)
+}
+
+// Returns where the binary's release asset for this platform is downloaded
+// from.
+func ReleaseAssetUrl(binary *manifest.Binary) string {
+	return fmt.Sprintf("https://github.com/%s/releases/download/%s/%s", binary.Repository, binary.Tag, ReleaseAssetName(binary))
+}
+
+// Downloads the binary's release asset for this platform and installs the
+// binary named name from it as the executable dest. If checksum isn't empty,
+// the asset must have that SHA-256 checksum. Returns the asset's checksum.
+func DownloadReleaseAsset(name string, binary *manifest.Binary, dest string, checksum string) (string, error) {
+	assetUrl := ReleaseAssetUrl(binary)
+	if NetworkDenied {
+		return "", networkDeniedError("download", assetUrl)
+	}
+	if DryRun || Verbose {
+		fmt.Fprintln(os.Stdout, "download", assetUrl)
+	}
+	if DryRun {
+		return checksum, nil
+	}
+
+	if err := os.MkdirAll(path.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	asset, err := ioutil.TempFile(path.Dir(dest), ".deliver-asset-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(asset.Name())
+	defer asset.Close()
+
+	defer waitForHost(assetUrl)()
+	resp, err := http.Get(assetUrl)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("could not download %s: %v", assetUrl, err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("could not download %s: %s", assetUrl, resp.Status))
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(asset, hash), resp.Body)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("could not download %s: %v", assetUrl, err))
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && !strings.EqualFold(checksum, actual) {
+		return "", errors.New(fmt.Sprintf("%s does not match the lockfile: expected sha256 %s, got %s", assetUrl, checksum, actual))
+	}
+
+	if _, err := asset.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(path.Dir(dest), ".deliver-binary-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	assetName := ReleaseAssetName(binary)
+	switch {
+	case strings.HasSuffix(assetName, ".tar.gz") || strings.HasSuffix(assetName, ".tgz"):
+		err = copyFromTarball(asset, name, tmp)
+	case strings.HasSuffix(assetName, ".zip"):
+		err = copyFromZip(asset, size, name, tmp)
+	default:
+		_, err = io.Copy(tmp, asset)
+	}
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("%s: %v", assetUrl, err))
+	}
+	if err := tmp.Chmod(0755); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return actual, os.Rename(tmp.Name(), dest)
+}
+
+// Returns whether an archive entry is the binary called name.
+func isBinaryEntry(entry, name string) bool {
+	base := path.Base(entry)
+	return base == name || base == name+".exe"
+}
+
+// Copies the binary called name out of a gzipped tar stream into w.
+func copyFromTarball(r io.Reader, name string, w io.Writer) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return errors.New(fmt.Sprintf("the archive has no file called %s", name))
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg && isBinaryEntry(header.Name, name) {
+			_, err := io.Copy(w, tr)
+			return err
+		}
+	}
+}
+
+// Copies the binary called name out of a zip archive of the given size into w.
+func copyFromZip(r io.ReaderAt, size int64, name string, w io.Writer) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isBinaryEntry(f.Name, name) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(w, rc)
+		return err
+	}
+	return errors.New(fmt.Sprintf("the archive has no file called %s", name))
+}
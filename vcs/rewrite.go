@@ -0,0 +1,186 @@
+package vcs
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Lists, in the git directory, the files RewriteCheckoutImports changed, so they can
+// be restored before the next checkout.
+const REWRITTEN_FILE string = "deliver-rewritten"
+
+// Returns path with the import path prefix from replaced by to, if path is
+// from or a package under it.
+func rewriteImportPath(path, from, to string) (string, bool) {
+	if path == from || strings.HasPrefix(path, from+"/") {
+		return to + strings.TrimPrefix(path, from), true
+	}
+	return path, false
+}
+
+// Rewrites the imports of a Go file that refer to from, or to packages under
+// it, to refer to to instead, along with its canonical import comment. Only
+// the import paths change, so the rest of the file keeps its formatting.
+// Returns whether the file changed.
+func rewriteGoFile(file, from, to string) (bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, data, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		// Files the go tool can't build either are left alone.
+		return false, nil
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	edits := []edit{}
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		if rewritten, ok := rewriteImportPath(path, from, to); ok {
+			edits = append(edits, edit{fset.Position(spec.Path.Pos()).Offset, fset.Position(spec.Path.End()).Offset, strconv.Quote(rewritten)})
+		}
+	}
+	// A canonical import comment, such as // import "example.com/fork", on
+	// the package clause's line.
+	packageLine := fset.Position(f.Name.End()).Line
+	for _, group := range f.Comments {
+		for _, comment := range group.List {
+			if fset.Position(comment.Pos()).Line != packageLine {
+				continue
+			}
+			fields := strings.Fields(strings.TrimPrefix(strings.TrimPrefix(comment.Text, "//"), "/*"))
+			if len(fields) < 2 || fields[0] != "import" {
+				continue
+			}
+			path, err := strconv.Unquote(strings.TrimSuffix(fields[1], "*/"))
+			if err != nil {
+				continue
+			}
+			if rewritten, ok := rewriteImportPath(path, from, to); ok {
+				start := fset.Position(comment.Pos()).Offset
+				quoted := strings.Index(string(data[start:]), strconv.Quote(path))
+				if quoted >= 0 {
+					edits = append(edits, edit{start + quoted, start + quoted + len(strconv.Quote(path)), strconv.Quote(rewritten)})
+				}
+			}
+		}
+	}
+	if len(edits) == 0 {
+		return false, nil
+	}
+
+	// Edits are applied from the end, so earlier offsets stay valid.
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	for _, e := range edits {
+		data = append(data[:e.start], append([]byte(e.text), data[e.end:]...)...)
+	}
+	return true, ioutil.WriteFile(file, data, 0644)
+}
+
+// Rewrites the module path in a go.mod file if it is from. Returns whether
+// the file changed.
+func rewriteGoMod(file, from, to string) (bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" && strings.Trim(fields[1], `"`) == from {
+			lines[i] = "module " + to
+			return true, ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644)
+		}
+	}
+	return false, nil
+}
+
+// Rewrites the imports of the checkout's Go files by g.RewriteImports, for a
+// fork whose code still refers to itself by another import path. The changed
+// files are recorded so the next checkout can restore them first.
+func (g *GitRepository) RewriteCheckoutImports() error {
+	if len(g.RewriteImports) == 0 || DryRun {
+		return nil
+	}
+	changed := []string{}
+	err := filepath.Walk(g.RepoPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if info.IsDir() {
+			if file != g.RepoPath && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || (!strings.HasSuffix(name, ".go") && file != filepath.Join(g.RepoPath, "go.mod")) {
+			return nil
+		}
+		for from, to := range g.RewriteImports {
+			rewrite := rewriteGoFile
+			if name == "go.mod" {
+				rewrite = rewriteGoMod
+			}
+			ok, err := rewrite(file, from, to)
+			if err != nil {
+				return err
+			}
+			if ok {
+				rel, _ := filepath.Rel(g.RepoPath, file)
+				changed = append(changed, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	if err != nil || len(changed) == 0 {
+		return err
+	}
+	if Verbose {
+		fmt.Fprintf(os.Stdout, "[%s] rewrote the imports of %d files\n", g.Name, len(changed))
+	}
+	file, err := g.gitPath(REWRITTEN_FILE)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(strings.Join(changed, "\n")+"\n"), 0644)
+}
+
+// Restores the files RewriteCheckoutImports changed to their committed
+// contents, so a checkout doesn't take them for local changes.
+func (g *GitRepository) RestoreRewrittenImports() error {
+	if !g.IsCloned() || DryRun {
+		return nil
+	}
+	file, err := g.gitPath(REWRITTEN_FILE)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if files := strings.Fields(string(data)); len(files) > 0 {
+		if _, err := g.run(g.RepoPath, append([]string{"git", "checkout", "HEAD", "--"}, files...)...); err != nil {
+			return err
+		}
+	}
+	return os.Remove(file)
+}
@@ -0,0 +1,55 @@
+package vcs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A semantic version read from a tag such as v1.2.3 or 1.2.3-rc.1.
+type Version struct {
+	Major, Minor, Patch int
+	Prerelease          string
+}
+
+// Parses a tag as a semantic version. Build metadata is ignored.
+func ParseVersion(tag string) (*Version, bool) {
+	s := strings.TrimPrefix(tag, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	v := &Version{}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.Prerelease = s[:i], s[i+1:]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part != strconv.Itoa(n) {
+			return nil, false
+		}
+		*numbers[i] = n
+	}
+	return v, true
+}
+
+// Whether v comes before other. Prereleases are compared as strings, which is
+// right for the common rc.1, rc.2 and so on.
+func (v *Version) Less(other *Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	if v.Patch != other.Patch {
+		return v.Patch < other.Patch
+	}
+	if v.Prerelease == "" || other.Prerelease == "" {
+		return v.Prerelease != "" && other.Prerelease == ""
+	}
+	return v.Prerelease < other.Prerelease
+}
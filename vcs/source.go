@@ -0,0 +1,212 @@
+package vcs
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Matches scp-like git URLs such as git@github.com:owner/repo.git.
+var scpSourcePattern = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// The parts of a remote repository URL.
+type SourceLocation struct {
+	// "ssh" for scp-like URLs, otherwise the URL scheme.
+	Scheme string
+	Host   string
+	// Path of the repository on the host, without a .git suffix.
+	Path string
+}
+
+// Returns the last element of the repository path.
+func (l *SourceLocation) RepoName() string {
+	return l.Path[strings.LastIndex(l.Path, "/")+1:]
+}
+
+// Returns source with surrounding space and trailing slashes removed, and its
+// scheme and host in lower case. Local paths and sources with variables are
+// only trimmed.
+func NormalizeSource(source string) string {
+	source = strings.TrimRight(strings.TrimSpace(source), "/")
+	if strings.Contains(source, "${") {
+		// Leave variables as they are written.
+		return source
+	}
+	if strings.Contains(source, "://") {
+		if u, err := url.Parse(source); err == nil && u.Host != "" {
+			u.Scheme = strings.ToLower(u.Scheme)
+			u.Host = strings.ToLower(u.Host)
+			return u.String()
+		}
+	} else if m := scpSourcePattern.FindStringSubmatchIndex(source); m != nil {
+		return source[:m[2]] + strings.ToLower(source[m[2]:m[3]]) + source[m[3]:]
+	}
+	return source
+}
+
+// Returns the import path of the repository: its host followed by its path.
+func (l *SourceLocation) ImportPath() string {
+	return l.Host + "/" + l.Path
+}
+
+// Returns the import path of the git repository in dir, derived from the URL
+// of its origin remote.
+func OriginImportPath(dir string) (string, bool) {
+	out, err := RunInDirectory(dir, "git", "remote", "get-url", "origin")
+	if err != nil {
+		return "", false
+	}
+	location, ok := ParseSource(strings.TrimSpace(out))
+	if !ok {
+		return "", false
+	}
+	return location.ImportPath(), true
+}
+
+// Parses a git remote URL. Local paths and unrecognized URLs are reported as
+// not ok.
+func ParseSource(source string) (location *SourceLocation, ok bool) {
+	var scheme, host, repoPath string
+	if strings.Contains(source, "://") {
+		u, err := url.Parse(source)
+		if err != nil || u.Host == "" {
+			return nil, false
+		}
+		scheme, host, repoPath = u.Scheme, u.Hostname(), u.Path
+	} else if m := scpSourcePattern.FindStringSubmatch(source); m != nil {
+		scheme, host, repoPath = "ssh", m[1], m[2]
+	} else {
+		return nil, false
+	}
+
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	if repoPath == "" {
+		return nil, false
+	}
+	return &SourceLocation{Scheme: scheme, Host: strings.ToLower(host), Path: repoPath}, true
+}
+
+// Hosts whose repositories live at host/owner/repo and can be cloned over SSH
+// as git@host:owner/repo.git.
+var wellKnownHosts = []string{"github.com", "gitlab.com", "bitbucket.org"}
+
+// Returns the clone URL for a package hosted on a well-known host, without
+// touching the network.
+func InferSource(packageName string) (string, bool) {
+	parts := strings.Split(packageName, "/")
+	if len(parts) != 3 {
+		return "", false
+	}
+	for _, host := range wellKnownHosts {
+		if parts[0] == host {
+			return fmt.Sprintf("git@%s:%s/%s.git", host, parts[1], parts[2]), true
+		}
+	}
+	return "", false
+}
+
+// Finds the source for a package whose manifest entry doesn't specify one.
+func ResolveSource(packageName string) (string, error) {
+	if source, ok := InferSource(packageName); ok {
+		return source, nil
+	}
+	return DiscoverSource(packageName)
+}
+
+// Client used for go-import discovery.
+var discoveryClient = &http.Client{Timeout: 30 * time.Second}
+
+// A <meta name="go-import" content="prefix vcs repoRoot"> tag.
+type goImport struct {
+	prefix, vcs, repoRoot string
+}
+
+// Finds the source of packageName using the go-import meta tags served at
+// https://<packageName>?go-get=1, the same way `go get` does.
+func DiscoverSource(packageName string) (string, error) {
+	if NetworkDenied {
+		return "", errors.New(fmt.Sprintf("cannot discover the source of %s: network access is denied (-network=deny); set Source in the manifest", packageName))
+	}
+
+	discoveryUrl := "https://" + packageName + "?go-get=1"
+	if DryRun || Verbose {
+		fmt.Fprintln(os.Stdout, "discover", discoveryUrl)
+	}
+	resp, err := discoveryClient.Get(discoveryUrl)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("cannot discover the source of %s: %v", packageName, err))
+	}
+	defer resp.Body.Close()
+
+	imports, err := parseGoImports(resp.Body)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("cannot discover the source of %s: %v", packageName, err))
+	}
+	for _, imp := range imports {
+		if packageName != imp.prefix && !strings.HasPrefix(packageName, imp.prefix+"/") {
+			continue
+		}
+		if imp.vcs != "git" {
+			return "", errors.New(fmt.Sprintf("%s is hosted in %s, but only git is supported", packageName, imp.vcs))
+		}
+		if imp.prefix != packageName {
+			return "", errors.New(fmt.Sprintf("%s is part of the repository for %s; use that as the package name", packageName, imp.prefix))
+		}
+		return imp.repoRoot, nil
+	}
+	return "", errors.New(fmt.Sprintf("cannot discover the source of %s: no go-import meta tag found at %s", packageName, discoveryUrl))
+}
+
+// Reads the go-import meta tags from the head of an HTML document.
+func parseGoImports(r io.Reader) ([]goImport, error) {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	imports := []goImport{}
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				return imports, nil
+			}
+			return nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return imports, nil
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return imports, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		if xmlAttr(e, "name") != "go-import" {
+			continue
+		}
+		if f := strings.Fields(xmlAttr(e, "content")); len(f) == 3 {
+			imports = append(imports, goImport{prefix: f[0], vcs: f[1], repoRoot: f[2]})
+		}
+	}
+}
+
+func xmlAttr(e xml.StartElement, name string) string {
+	for _, attr := range e.Attr {
+		if strings.EqualFold(attr.Name.Local, name) {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Returns the sparse-checkout file that keeps every file of the checkout but
+// those matching the patterns, which use the syntax of .gitignore. A pattern
+// starting with ! brings back files an earlier pattern excluded. The lockfile
+// is always kept, since the package's dependencies are read from it.
+func sparseCheckoutFile(patterns []string) string {
+	lines := []string{"/*"}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			lines = append(lines, pattern[1:])
+		} else {
+			lines = append(lines, "!"+pattern)
+		}
+	}
+	lines = append(lines, "/"+manifest.LOCK_FILE)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Removes the files matching g.Exclude from the checkout with a sparse
+// checkout, which later checkouts keep to, or restores them if the package no
+// longer excludes any. The objects are still fetched, so hashes of the
+// revision are unaffected.
+func (g *GitRepository) ApplyExcludes() error {
+	enabled, _ := g.run(g.RepoPath, "git", "config", "--get", "core.sparseCheckout")
+	if len(g.Exclude) == 0 && strings.TrimSpace(enabled) != "true" {
+		return nil
+	}
+	if DryRun {
+		return nil
+	}
+	file, err := g.gitPath("info/sparse-checkout")
+	if err != nil {
+		return err
+	}
+	content := sparseCheckoutFile(g.Exclude)
+	if len(g.Exclude) == 0 {
+		content = "/*\n"
+	}
+	if current, err := ioutil.ReadFile(file); err == nil && string(current) == content && len(g.Exclude) > 0 {
+		return nil
+	}
+
+	if g.Worktree {
+		if err := g.enableWorktreeConfig(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		return err
+	}
+	if _, err := g.run(g.RepoPath, "git", "config", "--worktree", "core.sparseCheckout", "true"); err != nil {
+		return err
+	}
+	if _, err := g.run(g.RepoPath, "git", "read-tree", "-mu", "HEAD"); err != nil {
+		return err
+	}
+	if len(g.Exclude) > 0 {
+		return nil
+	}
+	// Every file is back; stop checking out sparsely.
+	if _, err := g.run(g.RepoPath, "git", "config", "--worktree", "--unset", "core.sparseCheckout"); err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+// Lets the worktrees of the cache each have their own config, so one can be
+// checked out sparsely. The cache's core.bare moves to its own worktree config
+// so the worktrees aren't taken for bare repositories too, as git's
+// documentation of extensions.worktreeConfig requires.
+func (g *GitRepository) enableWorktreeConfig() error {
+	if out, _ := g.run(g.CachePath, "git", "config", "--get", "extensions.worktreeConfig"); strings.TrimSpace(out) == "true" {
+		return nil
+	}
+	for _, args := range [][]string{
+		{"core.repositoryFormatVersion", "1"},
+		{"extensions.worktreeConfig", "true"},
+		{"--worktree", "core.bare", "true"},
+		{"--unset", "core.bare"},
+	} {
+		if _, err := g.run(g.CachePath, append([]string{"git", "config"}, args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
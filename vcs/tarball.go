@@ -0,0 +1,240 @@
+package vcs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Written into packages installed from a tarball, since they have no .git
+// directory to record the checked out revision.
+const TARBALL_REVISION_FILE string = ".deliver-revision"
+
+var fullRevisionPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Returns the URL of the source archive for revision, if the source is hosted
+// somewhere that serves them.
+func TarballUrl(source, revision string) (string, bool) {
+	location, ok := ParseSource(source)
+	if !ok {
+		return "", false
+	}
+	switch location.Host {
+	case "github.com":
+		return fmt.Sprintf("https://codeload.github.com/%s/tar.gz/%s", location.Path, revision), true
+	case "gitlab.com":
+		return fmt.Sprintf("https://gitlab.com/%s/-/archive/%s/%s-%s.tar.gz", location.Path, revision, location.RepoName(), revision), true
+	case "bitbucket.org":
+		return fmt.Sprintf("https://bitbucket.org/%s/get/%s.tar.gz", location.Path, revision), true
+	}
+	return "", false
+}
+
+// Returns whether the package's locked revision can be installed from a source
+// archive.
+func CanDownloadTarball(packageInfo *manifest.Package) bool {
+	if !fullRevisionPattern.MatchString(packageInfo.Revision) || NetworkDenied {
+		return false
+	}
+	if packageInfo.Submodules != nil && *packageInfo.Submodules {
+		// Archives don't include submodules.
+		return false
+	}
+	_, ok := TarballUrl(packageInfo.Source, packageInfo.Revision)
+	return ok
+}
+
+// Returns the revision of a package installed from a tarball.
+func TarballRevision(dir string) (string, bool) {
+	data, err := ioutil.ReadFile(path.Join(dir, TARBALL_REVISION_FILE))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+// Installs the locked revision of a package from its host's source archive
+// instead of cloning it. Returns false if the package should be fetched with
+// git instead because it isn't locked to a full revision, isn't hosted
+// somewhere with archives, or is already a git checkout. Returns an error if
+// the download failed; git can still be used then.
+func DownloadTarball(packageInfo *manifest.Package, dir string) (bool, error) {
+	if !fullRevisionPattern.MatchString(packageInfo.Revision) {
+		return false, nil
+	}
+	if _, err := os.Stat(path.Join(dir, ".git")); err == nil {
+		return false, nil
+	}
+	if revision, ok := TarballRevision(dir); ok && revision == packageInfo.Revision {
+		return true, nil
+	}
+	archiveUrl, ok := TarballUrl(packageInfo.Source, packageInfo.Revision)
+	if !ok || NetworkDenied {
+		return false, nil
+	}
+
+	if DryRun || Verbose {
+		fmt.Fprintln(os.Stdout, "download", archiveUrl)
+	}
+	if DryRun {
+		return true, nil
+	}
+
+	if err := extractTarball(archiveUrl, dir); err != nil {
+		return false, errors.New(fmt.Sprintf("could not download %s: %v", archiveUrl, err))
+	}
+	if err := ioutil.WriteFile(path.Join(dir, TARBALL_REVISION_FILE), []byte(packageInfo.Revision+"\n"), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Downloads the archive at archiveUrl and replaces the contents of dir with it.
+// The archive's top-level directory is stripped. The archive is unpacked next
+// to dir first, so a failed download leaves dir untouched.
+func extractTarball(archiveUrl, dir string) error {
+	defer waitForHost(archiveUrl)()
+	resp, err := http.Get(archiveUrl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return replaceWithTarball(resp.Body, dir, 1)
+}
+
+// Replaces the contents of dir with a gzipped tar stream, dropping the first
+// strip path elements of every entry. The archive is unpacked next to dir
+// first, so a broken archive leaves dir untouched.
+func replaceWithTarball(r io.Reader, dir string, strip int) error {
+	if err := os.MkdirAll(path.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(path.Dir(dir), ".deliver-tarball-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := untar(r, tmpDir, strip); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, dir)
+}
+
+// Unpacks a gzipped tar stream into dir, dropping the first strip path
+// elements of every entry.
+func untar(r io.Reader, dir string, strip int) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	return ExtractTar(tar.NewReader(gz), dir, strip)
+}
+
+// Unpacks every entry of tr into dir, dropping the first strip path elements
+// of each entry's name.
+func ExtractTar(tr *tar.Reader, dir string, strip int) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		parts := strings.SplitN(header.Name, "/", strip+1)
+		if len(parts) < strip+1 || parts[strip] == "" {
+			continue
+		}
+		target := filepath.Join(dir, parts[strip])
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return errors.New(fmt.Sprintf("archive entry %s is outside the package", header.Name))
+		}
+		if linked, ok := symlinkOnPath(dir, target); ok {
+			return errors.New(fmt.Sprintf("archive entry %s is written through the symlink %s", header.Name, linked))
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0777)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if !linkStaysInside(dir, target, header.Linkname) {
+				return errors.New(fmt.Sprintf("archive entry %s links to %s, outside the package", header.Name, header.Linkname))
+			}
+			if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Returns the first path below dir, up to and including target, that is a
+// symlink. Entries are never written through links, since an earlier entry
+// could have pointed one anywhere.
+func symlinkOnPath(dir, target string) (string, bool) {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return "", false
+	}
+	current := filepath.Clean(dir)
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return "", false
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return current, true
+		}
+	}
+	return "", false
+}
+
+// Returns whether a symlink at target pointing to linkname resolves to a path
+// inside dir.
+func linkStaysInside(dir, target, linkname string) bool {
+	if filepath.IsAbs(linkname) {
+		return false
+	}
+	resolved := filepath.Join(filepath.Dir(target), linkname)
+	clean := filepath.Clean(dir)
+	return resolved == clean || strings.HasPrefix(resolved, clean+string(os.PathSeparator))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Set at build time, as the Makefile does, with
+// -ldflags "-X main.version=v1.2.3 -X main.commit=... -X main.buildDate=...".
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// Where the newest release of deliver is published.
+const LATEST_RELEASE_URL string = "https://api.github.com/repos/brettshollenberger/deliver/releases/latest"
+
+// Fills in the commit and build date from the build info Go records, for
+// builds that didn't set them.
+func buildInfo() (string, string) {
+	revision, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && revision == "":
+				revision = setting.Value
+			case setting.Key == "vcs.time" && date == "":
+				date = setting.Value
+			}
+		}
+	}
+	if revision == "" {
+		revision = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return revision, date
+}
+
+// Returns the tag of the newest release of deliver.
+func latestRelease() (string, error) {
+	data, err := vcs.FetchUrl(LATEST_RELEASE_URL)
+	if err != nil {
+		return "", err
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.Unmarshal(data, &release); err != nil || release.TagName == "" {
+		return "", errors.New(fmt.Sprintf("%s: no release tag in the answer", LATEST_RELEASE_URL))
+	}
+	return release.TagName, nil
+}
+
+// Prints the version of deliver, how it was built and the file formats it
+// understands. With -check, also reports whether a newer release is out.
+func versionCommand(args []string) {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	check := flags.Bool("check", false, "report whether a newer release of deliver is available")
+	flags.Parse(args)
+
+	revision, date := buildInfo()
+	fmt.Fprintf(os.Stdout, "deliver %s\n", version)
+	fmt.Fprintf(os.Stdout, "commit:  %s\n", revision)
+	fmt.Fprintf(os.Stdout, "built:   %s with %s for %s/%s\n", date, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(os.Stdout, "formats: %s %d, %s %d\n", manifest.PACKAGE_FILE, manifest.PACKAGE_FORMAT_VERSION, manifest.LOCK_FILE, manifest.LOCK_FORMAT_VERSION)
+	if !*check {
+		return
+	}
+
+	latest, err := latestRelease()
+	if err != nil {
+		panic(errors.New(fmt.Sprintf("could not check for a newer release: %v", err)))
+	}
+	current, ok := vcs.ParseVersion(version)
+	newest, newestOk := vcs.ParseVersion(latest)
+	switch {
+	case !ok || !newestOk:
+		fmt.Fprintf(os.Stdout, "the latest release is %s; this build, %s, can't be compared with it\n", latest, version)
+	case current.Less(newest):
+		fmt.Fprintf(os.Stdout, "%s\n", colorize(os.Stdout, COLOR_YELLOW, fmt.Sprintf("deliver %s is available; this is %s", latest, version)))
+	default:
+		fmt.Fprintf(os.Stdout, "this is the latest release\n")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Size and modification time of each watched file. Missing files are absent.
+type fileSnapshot map[string]string
+
+// Records the state of the package file, the lockfile, and the lockfiles of
+// installed packages.
+func snapshotManifests() fileSnapshot {
+	files := []string{manifest.PACKAGE_FILE, manifest.LOCK_FILE}
+	if *env != "" {
+		files = append(files, manifest.OverlayFile(*env))
+	}
+	if lockManifest, err := manifest.Load(manifest.LOCK_FILE); err == nil {
+		for _, packageInfo := range lockManifest.Packages {
+			files = append(files, path.Join(getPackageDir(packageInfo.GetImportPath()), manifest.LOCK_FILE))
+		}
+	}
+
+	snapshot := fileSnapshot{}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			snapshot[file] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return snapshot
+}
+
+// Returns the watched files that were added, removed or modified.
+func (s fileSnapshot) changes(previous fileSnapshot) []string {
+	var changed []string
+	for file, state := range s {
+		if previous[file] != state {
+			changed = append(changed, file)
+		}
+	}
+	for file := range previous {
+		if _, ok := s[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+	return changed
+}
+
+// Whether a package in the package file no longer matches its lockfile entry.
+func packageChanged(wanted, locked *manifest.Package) bool {
+	if wanted.Source != "" && wanted.Source != locked.Source {
+		return true
+	}
+	if wanted.GetBranch() != locked.GetBranch() {
+		return true
+	}
+	return wanted.HasRevision() && wanted.Revision != locked.Revision
+}
+
+// Runs deliver again with the same global flags. Failures are reported and
+// the watch carries on.
+func runDeliver(args ...string) {
+	globalArgs := os.Args[1 : len(os.Args)-len(flag.Args())]
+	cmd := exec.Command(os.Args[0], append(append([]string{}, globalArgs...), args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	fmt.Fprintf(os.Stdout, "watch: deliver %s\n", strings.Join(args, " "))
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "watch: deliver %s failed: %v\n", strings.Join(args, " "), err)
+	}
+}
+
+// Updates the packages added to or changed in the package file, and drops the
+// ones removed from it.
+func reconcilePackageFile() {
+	packageManifest, err := loadPackageFile()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "watch: %v\n", err)
+		return
+	}
+	lockManifest, err := manifest.Load(manifest.LOCK_FILE)
+	if err != nil {
+		// No lockfile yet, so everything is new.
+		runDeliver("update")
+		return
+	}
+
+	removed := false
+	for name := range lockManifest.Packages {
+		if _, ok := packageManifest.Packages[name]; !ok {
+			fmt.Fprintf(os.Stdout, "watch: removing %s from %s\n", name, manifest.LOCK_FILE)
+			delete(lockManifest.Packages, name)
+			removed = true
+		}
+	}
+	if removed {
+		writeManifest(lockManifest, manifest.LOCK_FILE)
+	}
+
+	for name, wanted := range packageManifest.Packages {
+		locked, ok := lockManifest.Packages[name]
+		if !ok || packageChanged(wanted, locked) {
+			runDeliver("update", name)
+		}
+	}
+}
+
+// Keeps the workspace in sync with the manifests while they are edited.
+func watchCommand(args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := flags.Duration("interval", 2*time.Second, "how often to check for changes")
+	flags.Parse(args)
+
+	fmt.Fprintf(os.Stdout, "watching %s and %s for changes\n", manifest.PACKAGE_FILE, manifest.LOCK_FILE)
+	previous := snapshotManifests()
+	for {
+		time.Sleep(*interval)
+		changed := snapshotManifests().changes(previous)
+		if len(changed) == 0 {
+			continue
+		}
+
+		// Wait for the editor to finish writing before acting on the change.
+		time.Sleep(*interval)
+
+		packageFileChanged := false
+		for _, file := range changed {
+			fmt.Fprintf(os.Stdout, "watch: %s changed\n", file)
+			if file == manifest.PACKAGE_FILE || (*env != "" && file == manifest.OverlayFile(*env)) {
+				packageFileChanged = true
+			}
+		}
+		if packageFileChanged {
+			reconcilePackageFile()
+		} else {
+			// The lockfile was replaced (for example by a pull) or a
+			// dependency's requirements moved.
+			runDeliver("install")
+		}
+
+		// Our own changes to the lockfile shouldn't trigger another round.
+		previous = snapshotManifests()
+	}
+}
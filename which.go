@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/brettshollenberger/deliver/resolve"
+)
+
+// Prints the path of a package inside the active workspace. Problems with the
+// checkout are reported on stderr so the path can be used directly by scripts.
+func whichCommand(root *resolve.Node, args []string) {
+	if len(args) != 1 {
+		panic(errors.New("usage: deliver which <package>"))
+	}
+
+	packageInfo, _ := lookupPackage(root, args[0])
+	git := GitRepositoryFromPackage(packageInfo)
+
+	fmt.Fprintln(os.Stdout, git.RepoPath)
+
+	current, ok := getInstalledRevision(git)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s is not installed\n", packageInfo.Name)
+		os.Exit(1)
+	}
+
+	if packageInfo.HasRevision() {
+		if current != packageInfo.Revision {
+			fmt.Fprintf(os.Stderr, "%s is at %s, but %s is locked\n", packageInfo.Name, current, packageInfo.Revision)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+// Marks a copy of the project in the workspace, and names the directory it
+// was copied from.
+const COPY_MARKER_FILE string = ".deliver-copy"
+
+// Returns the directory the copy of a project at dir was made from, if dir is
+// one.
+func CopySource(dir string) (string, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, COPY_MARKER_FILE))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(data)), true
+}
+
+// Copies projectDir into the workspace at repositoryPath, for build systems
+// that don't follow symlinks out of the workspace. Only files that changed
+// since the last copy are copied, and files that were removed from the project
+// are removed from the copy. The copied files are read-only, so changes are
+// made to the project rather than to the copy. The .git directory is left
+// out. Returns false if the copy was already up to date.
+func CopyProject(workspacePath, repositoryPath, projectDir string) (bool, error) {
+	copyPath := PackageDir(workspacePath, repositoryPath)
+
+	info, err := os.Lstat(copyPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return false, err
+	case info.Mode()&os.ModeSymlink != 0:
+		// Linked by an earlier install.
+		if !vcs.DryRun {
+			if err := os.Remove(copyPath); err != nil {
+				return false, err
+			}
+		}
+	default:
+		if same, err := PathCompare(copyPath, projectDir); err == nil && same {
+			// The project is checked out in the workspace itself.
+			return false, nil
+		}
+		if _, ok := CopySource(copyPath); !ok {
+			return false, errors.New(fmt.Sprintf("cannot copy %s into the workspace: %s already exists and is not a copy of the project", projectDir, copyPath))
+		}
+	}
+
+	if vcs.DryRun || vcs.Verbose {
+		fmt.Fprintf(os.Stdout, "copy %s %s\n", projectDir, copyPath)
+	}
+	if vcs.DryRun {
+		return true, nil
+	}
+
+	changed := false
+	seen := map[string]bool{COPY_MARKER_FILE: true}
+	err = filepath.Walk(projectDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(projectDir, file)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (info.Name() == ".git" || file == filepath.Clean(workspacePath)) {
+			// Leave out the history, and the workspace if it is inside the
+			// project.
+			return filepath.SkipDir
+		}
+		seen[rel] = true
+		target := filepath.Join(copyPath, rel)
+		existing, statErr := os.Lstat(target)
+
+		switch {
+		case info.IsDir():
+			if statErr == nil && existing.IsDir() {
+				return nil
+			}
+			os.RemoveAll(target)
+			changed = true
+			return os.MkdirAll(target, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			if current, err := os.Readlink(target); err == nil && current == link {
+				return nil
+			}
+			os.RemoveAll(target)
+			changed = true
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if statErr == nil && existing.Mode().IsRegular() && existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+				return nil
+			}
+			os.RemoveAll(target)
+			changed = true
+			return copyFile(file, target, info)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Remove what was removed from the project.
+	var removed []string
+	filepath.Walk(copyPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(copyPath, file)
+		if !seen[rel] {
+			removed = append(removed, file)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	for _, file := range removed {
+		if err := os.RemoveAll(file); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	marker := filepath.Join(copyPath, COPY_MARKER_FILE)
+	if source, ok := CopySource(copyPath); !ok || source != projectDir {
+		changed = true
+		os.Remove(marker)
+		if err := ioutil.WriteFile(marker, []byte(projectDir+"\n"), 0444); err != nil {
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+// Copies a regular file, read-only and with the original's modification time
+// so later copies can tell whether it changed.
+func copyFile(from, to string, info os.FileInfo) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm()&^0222)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
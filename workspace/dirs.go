@@ -0,0 +1,90 @@
+package workspace
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+)
+
+// Returns the directory that holds project workspaces. If rootDir is set,
+// that is its deliver_workspaces directory. Otherwise it is deliver/workspaces
+// in the user's data directory ($XDG_DATA_HOME or ~/.local/share, ~/Library/
+// Application Support on macOS, %LocalAppData% on Windows).
+func WorkspacesDir(rootDir string) string {
+	legacyDir := path.Join(Root(rootDir), WORKSPACES_DIR)
+	if rootDir != "" {
+		return legacyDir
+	}
+	dataDir, ok := userDataDir()
+	if !ok {
+		return legacyDir
+	}
+	return migrateDir(legacyDir, filepath.Join(dataDir, "deliver", "workspaces"))
+}
+
+// Returns the directory that holds the shared repository cache. If rootDir is
+// set, that is its deliver_cache directory. Otherwise it is deliver in the
+// user's cache directory ($XDG_CACHE_HOME or ~/.cache, ~/Library/Caches on
+// macOS, %LocalAppData% on Windows).
+func CacheDir(rootDir string) string {
+	legacyDir := path.Join(Root(rootDir), CACHE_DIR)
+	if rootDir != "" {
+		return legacyDir
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return legacyDir
+	}
+	return migrateDir(legacyDir, filepath.Join(cacheDir, "deliver"))
+}
+
+// Returns the directory for deliver's configuration: deliver in the user's
+// config directory ($XDG_CONFIG_HOME or ~/.config, ~/Library/Application
+// Support on macOS, %AppData% on Windows).
+func ConfigDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "deliver"), nil
+}
+
+func userDataDir() (string, bool) {
+	switch runtime.GOOS {
+	case "windows":
+		dir := os.Getenv("LocalAppData")
+		return dir, dir != ""
+	case "darwin", "ios":
+		home, err := os.UserHomeDir()
+		return filepath.Join(home, "Library", "Application Support"), err == nil
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return dir, true
+	}
+	home, err := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share"), err == nil
+}
+
+// Moves a directory from where older versions of deliver kept it in the home
+// directory to dir. A symlink is left in its place, since checkouts refer to
+// the cache and the cache to worktrees by absolute path. If the directory
+// can't be moved, it is used where it is.
+func migrateDir(legacyDir, dir string) string {
+	info, err := os.Lstat(legacyDir)
+	if err != nil || !info.IsDir() {
+		return dir
+	}
+	if _, err := os.Stat(dir); err == nil {
+		// Both exist. Leave it to the user to merge them.
+		return dir
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return legacyDir
+	}
+	if err := os.Rename(legacyDir, dir); err != nil {
+		return legacyDir
+	}
+	os.Symlink(dir, legacyDir)
+	return dir
+}
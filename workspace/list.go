@@ -0,0 +1,195 @@
+package workspace
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brettshollenberger/deliver/manifest"
+)
+
+// Records which project a workspace belongs to.
+const WORKSPACE_FILE string = "deliver-workspace.json"
+
+// A project's workspace in the deliver workspaces directory.
+type Workspace struct {
+	Path string `json:"-"`
+	// Directory of the project the workspace was created for.
+	Project string
+	// Whether the workspace is shared by the projects nested in Project, which
+	// need not have a package file of its own.
+	Nested bool `json:",omitempty"`
+	// When a package was last installed into the workspace.
+	LastUsed time.Time `json:"-"`
+}
+
+// Returns whether the workspace's project is still where it was.
+func (w *Workspace) ProjectExists() bool {
+	if w.Nested {
+		info, err := os.Stat(w.Project)
+		return err == nil && info.IsDir()
+	}
+	_, err := os.Stat(path.Join(w.Project, manifest.PACKAGE_FILE))
+	return err == nil
+}
+
+// Deletes the workspace, along with any directories left empty above it.
+func (w *Workspace) Remove(rootDir string) error {
+	if err := os.RemoveAll(w.Path); err != nil {
+		return err
+	}
+	workspacesDir := WorkspacesDir(rootDir)
+	for dir := path.Dir(w.Path); strings.HasPrefix(dir, workspacesDir+"/"); dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			// Not empty.
+			break
+		}
+	}
+	return nil
+}
+
+// Returns the workspace of the project in projectDir: a directory named after
+// a hash of the project's path, so its depth doesn't depend on where the
+// project lives. The workspace's metadata file records the project. A
+// workspace created by an older version of deliver, named after the full
+// path of the project, is moved there.
+func Open(rootDir, projectDir string) (string, error) {
+	return open(rootDir, &Workspace{Project: projectDir})
+}
+
+// Returns the workspace shared by the projects nested in projectDir, as Open
+// does for a single project.
+func OpenNested(rootDir, projectDir string) (string, error) {
+	return open(rootDir, &Workspace{Project: projectDir, Nested: true})
+}
+
+// Returns the workspace shared by the projects nested in projectDir, if
+// OpenNested created one.
+func FindNested(rootDir, projectDir string) (string, bool) {
+	workspacePath := workspaceDir(rootDir, projectDir)
+	data, err := ioutil.ReadFile(path.Join(workspacePath, WORKSPACE_FILE))
+	if err != nil {
+		return "", false
+	}
+	ws := &Workspace{}
+	return workspacePath, json.Unmarshal(data, ws) == nil && ws.Nested
+}
+
+func workspaceDir(rootDir, projectDir string) string {
+	hash := sha256.Sum256([]byte(projectDir))
+	return path.Join(WorkspacesDir(rootDir), hex.EncodeToString(hash[:])[:12])
+}
+
+func open(rootDir string, ws *Workspace) (string, error) {
+	projectDir := ws.Project
+	workspacesDir := WorkspacesDir(rootDir)
+	workspacePath := workspaceDir(rootDir, projectDir)
+	metadataFile := path.Join(workspacePath, WORKSPACE_FILE)
+	if _, err := os.Stat(metadataFile); err == nil {
+		return workspacePath, nil
+	}
+
+	if err := os.MkdirAll(workspacePath, 0755); err != nil {
+		return "", err
+	}
+	if err := migrateLegacyWorkspace(path.Join(workspacesDir, projectDir), workspacePath); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(ws, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return workspacePath, ioutil.WriteFile(metadataFile, append(data, '\n'), 0644)
+}
+
+// Moves Go's directories from a workspace named after the project's path.
+// Workspaces of projects nested in this one are left where they are.
+func migrateLegacyWorkspace(legacyPath, workspacePath string) error {
+	for _, dir := range []string{"src", "pkg", "bin"} {
+		from := path.Join(legacyPath, dir)
+		if _, err := os.Lstat(from); err != nil {
+			continue
+		}
+		if err := os.Rename(from, path.Join(workspacePath, dir)); err != nil {
+			return err
+		}
+	}
+	// Remove the legacy directories if that left them empty.
+	workspacesDir := path.Dir(workspacePath)
+	for dir := legacyPath; strings.HasPrefix(dir, workspacesDir+"/"); dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Returns every workspace under rootDir's deliver workspaces directory,
+// sorted by project.
+func List(rootDir string) ([]*Workspace, error) {
+	workspacesDir := WorkspacesDir(rootDir)
+	var workspaces []*Workspace
+	err := filepath.Walk(workspacesDir, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && dir == workspacesDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		switch path.Base(dir) {
+		case "src", "pkg", "bin":
+			// Go's directories inside a workspace.
+			return filepath.SkipDir
+		}
+		if data, err := ioutil.ReadFile(path.Join(dir, WORKSPACE_FILE)); err == nil {
+			ws := &Workspace{Path: dir, LastUsed: lastModified(path.Join(dir, "src"), 3)}
+			if err := json.Unmarshal(data, ws); err != nil {
+				return err
+			}
+			workspaces = append(workspaces, ws)
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(path.Join(dir, "src")); err == nil {
+			// Workspaces created by older versions of deliver are named
+			// after the absolute path of their project.
+			workspaces = append(workspaces, &Workspace{
+				Path:     dir,
+				Project:  strings.TrimPrefix(dir, workspacesDir),
+				LastUsed: lastModified(path.Join(dir, "src"), 3),
+			})
+		}
+		// Projects nested in this one have workspaces below it.
+		return nil
+	})
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].Project < workspaces[j].Project })
+	return workspaces, err
+}
+
+// Returns the latest modification time of dir and the directories up to depth
+// levels below it, which change whenever a package is installed.
+func lastModified(dir string, depth int) time.Time {
+	var latest time.Time
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		if strings.Count(strings.TrimPrefix(p, dir), "/") >= depth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return latest
+}
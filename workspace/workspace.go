@@ -0,0 +1,191 @@
+// Package workspace locates the Go workspace packages are installed into, and
+// the shared caches that live beside it.
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/brettshollenberger/deliver/manifest"
+	"github.com/brettshollenberger/deliver/vcs"
+)
+
+const (
+	WORKSPACES_DIR string = "deliver_workspaces"
+	CACHE_DIR      string = "deliver_cache"
+)
+
+var unsafeCacheChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Returns the directory that held the deliver workspaces and caches before
+// they moved to the user's data and cache directories. If rootDir is empty,
+// that is the home directory.
+func Root(rootDir string) string {
+	if len(rootDir) == 0 {
+		return os.Getenv("HOME")
+	}
+	return rootDir
+}
+
+// Returns the first entry of $GOPATH.
+func GOPATH() string {
+	if entries := GOPATHs(); len(entries) > 0 {
+		return entries[0]
+	}
+	return ""
+}
+
+// Returns every entry of $GOPATH, in order.
+func GOPATHs() []string {
+	return filepath.SplitList(os.Getenv("GOPATH"))
+}
+
+// Returns the directory of the named package in the first of the workspaces
+// that has it installed, as a git checkout or from a source archive.
+func FindPackage(workspacePaths []string, packageName string) (string, bool) {
+	for _, workspacePath := range workspacePaths {
+		dir := PackageDir(workspacePath, packageName)
+		for _, marker := range []string{".git", vcs.TARBALL_REVISION_FILE} {
+			if _, err := os.Stat(path.Join(dir, marker)); err == nil {
+				return dir, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Returns the nearest directory at or above dir with a packages.json file: the
+// root of the project dir is in, as Find sees it.
+func FindProject(dir string) (string, bool) {
+	for {
+		if _, err := os.Stat(path.Join(dir, manifest.PACKAGE_FILE)); err == nil {
+			return dir, true
+		}
+		if dir == "/" || dir == "." {
+			return "", false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// Traverse the path up towards the root, starting from dir. If a directory has
+// a packages.json file, then the workspace is that project's workspace under
+// rootDir's workspaces directory (see Open and WorkspacesDir), so a project
+// nested in another has its own.
+// If we get to the root directory, return the nearest workspace shared by
+// nested projects (see OpenNested), or else the env GOPATH.
+func Find(dir, rootDir string) (string, error) {
+	nested := ""
+	for {
+		possibleManifest := path.Join(dir, manifest.PACKAGE_FILE)
+		_, err := os.Stat(possibleManifest)
+		if err == nil {
+			// packages.json exists. Crete workspace
+			return Open(rootDir, dir)
+		}
+
+		if os.IsNotExist(err) {
+			// packages.json does not exist.
+			if workspacePath, ok := FindNested(rootDir, dir); ok && nested == "" {
+				nested = workspacePath
+			}
+			if dir == "/" {
+				// If we're already at the root, return the
+				// shared workspace found on the way, or
+				// the GOPATH environment variable.
+				if nested != "" {
+					return nested, nil
+				}
+				return os.Getenv("GOPATH"), nil
+			} else {
+				// Check the parent directory.
+				dir = path.Join(dir, "..")
+			}
+		} else {
+			// some other error occured during os.Stat.
+			return "", err
+		}
+	}
+}
+
+// Returns where the named package is installed in the workspace.
+func PackageDir(workspacePath, packageName string) string {
+	return path.Join(workspacePath, "src", packageName)
+}
+
+// Returns the path of the shared bare repository for source. Every workspace
+// that uses source borrows objects from this repository.
+func CachePath(rootDir, source string) string {
+	name := unsafeCacheChars.ReplaceAllString(strings.TrimSuffix(source, ".git"), "_")
+	return path.Join(CacheDir(rootDir), name+".git")
+}
+
+func PathCompare(a string, b string) (bool, error) {
+	realA, err := filepath.EvalSymlinks(a)
+	if err != nil {
+		return false, err
+	}
+	realB, err := filepath.EvalSymlinks(b)
+	if err != nil {
+		return false, err
+	}
+	return realA == realB, nil
+}
+
+// Links projectDir into the workspace at repositoryPath. Returns false if the
+// project is already there. A broken symlink, one to somewhere else, or a copy
+// made with CopyProject is replaced; anything else in the way is an error.
+func CreateSymlink(workspacePath, repositoryPath, projectDir string) (bool, error) {
+	linkPath := PackageDir(workspacePath, repositoryPath)
+
+	info, err := os.Lstat(linkPath)
+	switch {
+	case os.IsNotExist(err):
+		// Nothing there yet.
+	case err != nil:
+		return false, err
+	case info.Mode()&os.ModeSymlink != 0:
+		if same, err := PathCompare(linkPath, projectDir); err == nil && same {
+			return false, nil
+		}
+		// Broken, or pointing at another directory.
+		if !vcs.DryRun {
+			if err := os.Remove(linkPath); err != nil {
+				return false, err
+			}
+		}
+	default:
+		if same, err := PathCompare(linkPath, projectDir); err == nil && same {
+			// The project is checked out in the workspace itself.
+			return false, nil
+		}
+		if _, ok := CopySource(linkPath); ok {
+			if !vcs.DryRun {
+				if err := os.RemoveAll(linkPath); err != nil {
+					return false, err
+				}
+			}
+			break
+		}
+		return false, errors.New(fmt.Sprintf("cannot link %s into the workspace: %s already exists and is not a symlink", projectDir, linkPath))
+	}
+
+	if vcs.DryRun || vcs.Verbose {
+		fmt.Fprintf(os.Stdout, "ln -s %s %s\n", projectDir, linkPath)
+	}
+	if vcs.DryRun {
+		return true, nil
+	}
+	if err := os.MkdirAll(path.Dir(linkPath), 0755); err != nil {
+		return false, err
+	}
+	if err := os.Symlink(projectDir, linkPath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
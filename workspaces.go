@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/brettshollenberger/deliver/workspace"
+)
+
+const workspacesUsage = "Usage: deliver workspaces list | path [project] | remove <project>"
+
+// Manages the project workspaces in the deliver workspaces directory.
+func workspacesCommand(args []string) {
+	if len(args) < 1 {
+		panic(errors.New(workspacesUsage))
+	}
+
+	switch args[0] {
+	case "list":
+		listWorkspaces()
+	case "path":
+		if len(args) > 2 {
+			panic(errors.New(workspacesUsage))
+		}
+		if len(args) == 1 {
+			// The current project's workspace, created if needed.
+			fmt.Fprintf(os.Stdout, "%s\n", getWorkspacePath())
+			return
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", findWorkspace(args[1]).Path)
+	case "remove":
+		if len(args) != 2 {
+			panic(errors.New(workspacesUsage))
+		}
+		ws := findWorkspace(args[1])
+		if *noRun {
+			fmt.Fprintf(os.Stdout, "would remove %s (%s)\n", ws.Path, ws.Project)
+			return
+		}
+		fmt.Fprintf(os.Stdout, "removing %s (%s)\n", ws.Path, ws.Project)
+		if err := ws.Remove(*rootWorkspaceDir); err != nil {
+			panic(err)
+		}
+	default:
+		panic(errors.New(fmt.Sprintf("Unknown workspaces command %q. %s", args[0], workspacesUsage)))
+	}
+}
+
+// Returns the workspace named name (as shown by list) or belonging to the
+// project in directory name.
+func findWorkspace(name string) *workspace.Workspace {
+	workspaces, err := workspace.List(*rootWorkspaceDir)
+	if err != nil {
+		panic(err)
+	}
+	projectDir, _ := filepath.Abs(name)
+	for _, ws := range workspaces {
+		if path.Base(ws.Path) == name || ws.Project == projectDir {
+			return ws
+		}
+	}
+	panic(errors.New(fmt.Sprintf("No workspace found for %s. Run `deliver workspaces list` to see them all.", name)))
+}
+
+// Prints each workspace with its project, size and when it was last used.
+func listWorkspaces() {
+	workspaces, err := workspace.List(*rootWorkspaceDir)
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "WORKSPACE\tPROJECT\tSIZE\tLAST USED\tSTATUS\n")
+	for _, ws := range workspaces {
+		status := "ok"
+		if !ws.ProjectExists() {
+			status = "project is gone"
+		}
+		lastUsed := "never"
+		if !ws.LastUsed.IsZero() {
+			lastUsed = formatAge(ws.LastUsed, now) + " ago"
+		}
+		size := directorySize(ws.Path, "")
+		total += size
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", path.Base(ws.Path), ws.Project, formatSize(size), lastUsed, status)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stdout, "%d workspaces, %s in total\n", len(workspaces), formatSize(total))
+}